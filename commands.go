@@ -0,0 +1,489 @@
+package main
+
+import (
+	"fmt"
+	"github.com/pakohler/jenkronize/config"
+	"github.com/pakohler/jenkronize/jenkins"
+	"github.com/pakohler/jenkronize/logging"
+	"github.com/pakohler/jenkronize/notifications"
+	"github.com/pakohler/jenkronize/tracking"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+func runCommand(cmd *command, args []string) int {
+	flags := newFlagSet(cmd)
+	if status, ok := parseFlags(flags, args); !ok {
+		return status
+	}
+	if flags.NArg() > 0 {
+		return badUsage(flags, "run takes no arguments")
+	}
+	conf, err := loadConfig(cmd.daemon)
+	if err != nil {
+		logging.GetLogger().Fatal.Fatal(err)
+	}
+	logging.ReopenOnSignal()
+
+	tracker, err := newTracker(conf)
+	if err != nil {
+		logging.GetLogger().Fatal.Fatal(err)
+	}
+	if err := addNotifiers(tracker, conf); err != nil {
+		logging.GetLogger().Fatal.Fatal(err)
+	}
+	if err := tracker.LoadState(); err != nil {
+		logging.GetLogger().Error.Print(err)
+	}
+	// deliver the queued notifications and any pending digest when stopped
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	go tracker.Go()
+	sig := <-stop
+	logging.GetLogger().Info.Printf("received %s; delivering pending notifications before exiting", sig)
+	if err := tracker.Close(); err != nil {
+		logging.GetLogger().Error.Print(err)
+		return 1
+	}
+	return 0
+}
+
+func onceCommand(cmd *command, args []string) int {
+	flags := newFlagSet(cmd)
+	if status, ok := parseFlags(flags, args); !ok {
+		return status
+	}
+	conf, err := loadConfig(cmd.daemon)
+	if err != nil {
+		return fail(err)
+	}
+	tracker, err := newTracker(conf)
+	if err != nil {
+		return fail(err)
+	}
+	if err := tracker.LoadState(); err != nil {
+		return fail(err)
+	}
+	if err := addNotifiers(tracker, conf); err != nil {
+		return fail(err)
+	}
+	// the jobs which could be synced still are if discovery fails
+	_, discoverErr := tracker.Discover(true)
+	jobs, err := selectJobs(tracker, flags.Args())
+	if err != nil {
+		tracker.Close()
+		return fail(err)
+	}
+	syncErr := tracker.SyncOnce(jobs)
+	// wait for the notifications to be delivered before exiting
+	if err := tracker.Close(); err != nil {
+		logging.GetLogger().Error.Print(err)
+	}
+	if syncErr != nil {
+		return fail(syncErr)
+	}
+	if discoverErr != nil {
+		return fail(discoverErr)
+	}
+	return 0
+}
+
+func statusCommand(cmd *command, args []string) int {
+	flags := newFlagSet(cmd)
+	offline := flags.Bool("offline", false, "don't ask Jenkins for the latest build of each job")
+	if status, ok := parseFlags(flags, args); !ok {
+		return status
+	}
+	if flags.NArg() > 0 {
+		return badUsage(flags, "status takes no arguments")
+	}
+	conf, err := loadConfig(cmd.daemon)
+	if err != nil {
+		return fail(err)
+	}
+	tracker, err := newTracker(conf)
+	if err != nil {
+		return fail(err)
+	}
+	if err := tracker.LoadState(); err != nil {
+		return fail(err)
+	}
+	status := 0
+	if !*offline {
+		// newly discovered jobs are listed, but left to be announced when
+		// they're synced
+		if _, err := tracker.Discover(false); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+		}
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "JOB\tSYNCED\tLATEST\tCACHED\tSYNC DIR")
+	for _, job := range tracker.Jobs() {
+		synced := "-"
+		if job.BuildNumber() > 0 {
+			synced = fmt.Sprintf("%d", job.BuildNumber())
+		}
+		latest := "-"
+		if job.Gone != nil {
+			// the branch was deleted from Jenkins, so there's nothing to ask for
+			latest = "deleted"
+		} else if !*offline {
+			build, err := tracker.LatestBuild(job)
+			switch {
+			case err != nil:
+				latest = "error"
+				fmt.Fprintf(os.Stderr, "%s: %v\n", job.GetAlias(), err)
+				status = 1
+			case build != nil:
+				latest = fmt.Sprintf("%d", build.Number)
+			}
+		}
+		cached := "-"
+		if builds, err := tracker.CachedBuilds(job); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", job.GetAlias(), err)
+			status = 1
+		} else if len(builds) > 0 {
+			cached = joinInts(builds)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", job.GetAlias(), synced, latest, cached, job.SyncDir)
+	}
+	w.Flush()
+	return status
+}
+
+func listBuildsCommand(cmd *command, args []string) int {
+	flags := newFlagSet(cmd)
+	if status, ok := parseFlags(flags, args); !ok {
+		return status
+	}
+	if flags.NArg() != 1 {
+		return badUsage(flags, "list-builds takes exactly one job")
+	}
+	conf, err := loadConfig(cmd.daemon)
+	if err != nil {
+		return fail(err)
+	}
+	tracker, err := newTracker(conf)
+	if err != nil {
+		return fail(err)
+	}
+	if err := tracker.LoadState(); err != nil {
+		return fail(err)
+	}
+	job, err := findJob(tracker, flags.Arg(0))
+	if err != nil {
+		return fail(err)
+	}
+	builds, err := newClient(conf).GetBuilds(job.GetName(), 0, 0)
+	if err != nil {
+		return fail(err)
+	}
+	cached := map[int]bool{}
+	if numbers, err := tracker.CachedBuilds(job); err == nil {
+		for _, n := range numbers {
+			cached[n] = true
+		}
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "BUILD\tRESULT\tSTARTED\tDURATION\tLOCAL")
+	for _, build := range builds {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n",
+			build.Number,
+			buildResult(build),
+			time.Unix(build.Timestamp/1000, 0).Format("2006-01-02 15:04"),
+			(time.Duration(build.Duration) * time.Millisecond).Round(time.Second),
+			localState(job, cached, build.Number),
+		)
+	}
+	w.Flush()
+	return 0
+}
+
+func buildResult(build *jenkins.JobBuild) string {
+	if build.Building {
+		return "BUILDING"
+	}
+	if build.Result == "" {
+		return "-"
+	}
+	return build.Result
+}
+
+// localState describes whether the build is the one the job is synced to, or
+// is otherwise still in the sync dir.
+func localState(job *tracking.TrackedJob, cached map[int]bool, number int32) string {
+	if number == job.BuildNumber() {
+		return "synced"
+	}
+	if cached[int(number)] {
+		return "cached"
+	}
+	return ""
+}
+
+func fetchCommand(cmd *command, args []string) int {
+	flags := newFlagSet(cmd)
+	if status, ok := parseFlags(flags, args); !ok {
+		return status
+	}
+	if flags.NArg() != 2 {
+		return badUsage(flags, "fetch takes a job and a build number")
+	}
+	number, err := parseBuildNumber(flags.Arg(1))
+	if err != nil {
+		return badUsage(flags, err.Error())
+	}
+	conf, err := loadConfig(cmd.daemon)
+	if err != nil {
+		return fail(err)
+	}
+	tracker, err := newTracker(conf)
+	if err != nil {
+		return fail(err)
+	}
+	if err := tracker.LoadState(); err != nil {
+		return fail(err)
+	}
+	job, err := findJob(tracker, flags.Arg(0))
+	if err != nil {
+		return fail(err)
+	}
+	if err := tracker.FetchBuild(job, number); err != nil {
+		return fail(err)
+	}
+	fmt.Printf("fetched build %d of %s into %s/%d\n", number, job.GetAlias(), job.SyncDir, number)
+	return 0
+}
+
+func pruneCommand(cmd *command, args []string) int {
+	flags := newFlagSet(cmd)
+	if status, ok := parseFlags(flags, args); !ok {
+		return status
+	}
+	conf, err := loadConfig(cmd.daemon)
+	if err != nil {
+		return fail(err)
+	}
+	tracker, err := newTracker(conf)
+	if err != nil {
+		return fail(err)
+	}
+	if err := tracker.LoadState(); err != nil {
+		return fail(err)
+	}
+	jobs, err := selectJobs(tracker, flags.Args())
+	if err != nil {
+		return fail(err)
+	}
+	for _, job := range jobs {
+		tracker.Prune(job)
+	}
+	return 0
+}
+
+func stateCommand(cmd *command, args []string) int {
+	flags := newFlagSet(cmd)
+	all := flags.Bool("all", false, "reset every job, so all of them are synced again")
+	if status, ok := parseFlags(flags, args); !ok {
+		return status
+	}
+	if flags.NArg() == 0 {
+		return badUsage(flags, "state needs one of show, reset or set")
+	}
+	action, actionArgs := flags.Arg(0), flags.Args()[1:]
+	// flags may also follow the action, eg. `state reset --all`
+	if err := flags.Parse(actionArgs); err != nil {
+		return 2
+	}
+	actionArgs = flags.Args()
+	switch action {
+	case "show":
+		if len(actionArgs) > 0 {
+			return badUsage(flags, "state show takes no arguments")
+		}
+	case "reset":
+		if *all == (len(actionArgs) > 0) {
+			return badUsage(flags, "state reset takes either jobs or --all")
+		}
+	case "set":
+		if len(actionArgs) != 2 {
+			return badUsage(flags, "state set takes a job and a build number")
+		}
+	default:
+		return badUsage(flags, fmt.Sprintf("unknown state action %q", action))
+	}
+
+	conf, err := loadConfig(cmd.daemon)
+	if err != nil {
+		return fail(err)
+	}
+	tracker, err := newTracker(conf)
+	if err != nil {
+		return fail(err)
+	}
+	if err := tracker.LoadState(); err != nil {
+		return fail(err)
+	}
+	switch action {
+	case "show":
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "JOB\tBUILD\tNAME")
+		for _, job := range tracker.Jobs() {
+			fmt.Fprintf(w, "%s\t%d\t%s\n", job.GetAlias(), job.BuildNumber(), job.GetName())
+		}
+		w.Flush()
+		return 0
+	case "reset":
+		jobs, err := selectJobs(tracker, actionArgs)
+		if err != nil {
+			return fail(err)
+		}
+		for _, job := range jobs {
+			job.SetBuild(&jenkins.Build{Number: 0})
+		}
+	case "set":
+		job, err := findJob(tracker, actionArgs[0])
+		if err != nil {
+			return fail(err)
+		}
+		number, err := parseBuildNumber(actionArgs[1])
+		if err != nil {
+			return fail(err)
+		}
+		job.SetBuild(&jenkins.Build{Number: number})
+	}
+	if err := tracker.SaveState(); err != nil {
+		return fail(err)
+	}
+	return 0
+}
+
+func configCommand(cmd *command, args []string) int {
+	flags := newFlagSet(cmd)
+	interactive := flags.Bool("interactive", false, "init: ask for the settings, offering to discover the jobs to track from Jenkins")
+	toStdout := flags.Bool("stdout", false, "init: print the config rather than writing it")
+	if status, ok := parseFlags(flags, args); !ok {
+		return status
+	}
+	if flags.NArg() == 0 {
+		return badUsage(flags, "config needs one of validate or init")
+	}
+	action := flags.Arg(0)
+	// flags may also follow the action, eg. `config init --interactive`
+	if err := flags.Parse(flags.Args()[1:]); err != nil {
+		return 2
+	}
+	if flags.NArg() > 0 {
+		return badUsage(flags, fmt.Sprintf("config %s takes no arguments", action))
+	}
+	switch action {
+	case "validate":
+		conf, err := loadConfig(cmd.daemon)
+		if err != nil {
+			return fail(err)
+		}
+		if _, err := newTracker(conf); err != nil {
+			return fail(err)
+		}
+		// the notifiers are only checked, so they're left unqueued
+		_, err = newRouter(conf, func(name string, n notifications.Notifier) notifications.Notifier {
+			return n
+		})
+		if err != nil {
+			return fail(fmt.Errorf("invalid notification config: %v", err))
+		}
+		path, _ := config.Path()
+		fmt.Printf("%s is valid\n", path)
+		return 0
+	case "init":
+		return initConfig(*interactive, *toStdout)
+	}
+	return badUsage(flags, fmt.Sprintf("unknown config action %q", action))
+}
+
+func verifyCommand(cmd *command, args []string) int {
+	flags := newFlagSet(cmd)
+	publicKey := flags.String("public-key", "", "also check the signature of SHA256SUMS against this ed25519 or OpenPGP public key")
+	if status, ok := parseFlags(flags, args); !ok {
+		return status
+	}
+	if flags.NArg() == 0 {
+		return badUsage(flags, "verify needs the dir of at least one build")
+	}
+	status := 0
+	for _, dir := range flags.Args() {
+		results, err := tracking.VerifyBuild(dir, *publicKey)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+			continue
+		}
+		for _, result := range results {
+			name := filepath.Join(dir, result.File)
+			if result.Err != nil {
+				fmt.Printf("%s: FAILED (%s): %v\n", name, result.List, result.Err)
+				status = 1
+				continue
+			}
+			fmt.Printf("%s: OK (%s)\n", name, result.List)
+		}
+	}
+	return status
+}
+
+func versionCommand(cmd *command, args []string) int {
+	fmt.Printf("jenkronize %s\n", version)
+	return 0
+}
+
+// selectJobs finds the named jobs, or returns every job if none are named.
+func selectJobs(tracker *tracking.Tracker, names []string) ([]*tracking.TrackedJob, error) {
+	if len(names) == 0 {
+		return tracker.Jobs(), nil
+	}
+	jobs := []*tracking.TrackedJob{}
+	for _, name := range names {
+		job, err := findJob(tracker, name)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// findJob finds the named job, searching Jenkins for it if it may match a
+// name_pattern or name_regex but hasn't been discovered yet.
+func findJob(tracker *tracking.Tracker, name string) (*tracking.TrackedJob, error) {
+	job, err := tracker.Job(name)
+	if err == nil {
+		return job, nil
+	}
+	if jobs, _ := tracker.Discover(false); len(jobs) == 0 {
+		return nil, err
+	}
+	return tracker.Job(name)
+}
+
+func parseBuildNumber(s string) (int32, error) {
+	number, err := strconv.ParseInt(s, 10, 32)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid build number %q", s)
+	}
+	return int32(number), nil
+}
+
+func joinInts(ints []int) string {
+	strs := make([]string, len(ints))
+	for i, n := range ints {
+		strs[i] = strconv.Itoa(n)
+	}
+	return strings.Join(strs, ",")
+}
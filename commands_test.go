@@ -0,0 +1,187 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/pakohler/jenkronize/config"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// captureOutput runs f, returning what it wrote to stdout and stderr along
+// with its exit status.
+func captureOutput(t *testing.T, f func() int) (string, string, int) {
+	capture := func(file **os.File) func() string {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		original := *file
+		*file = w
+		output := make(chan string)
+		go func() {
+			data, _ := ioutil.ReadAll(r)
+			output <- string(data)
+		}()
+		return func() string {
+			*file = original
+			w.Close()
+			return <-output
+		}
+	}
+	stdout := capture(&os.Stdout)
+	stderr := capture(&os.Stderr)
+	status := f()
+	return stdout(), stderr(), status
+}
+
+// writeConfig writes a config tracking /job/foo from the Jenkins at url into
+// dir, and uses it.
+func writeConfig(t *testing.T, dir string, url string) {
+	configFile := filepath.Join(dir, "jenkronize.yml")
+	conf := fmt.Sprintf(`jenkins:
+  url: %s
+  username: user
+  password: pass
+tracker:
+  interval: 10m
+  trackedjobs:
+  - name: /job/foo
+    alias: foo
+    sync_dir: %s
+state_file: %s
+`, url, filepath.Join(dir, "foo"), filepath.Join(dir, "state.json"))
+	if err := ioutil.WriteFile(configFile, []byte(conf), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config.SetPath(configFile)
+}
+
+func commandNamed(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func TestStatusCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jenkronize-status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	jenkins := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/job/foo/api/json" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"lastSuccessfulBuild": {"number": 7}, "lastCompletedBuild": {"number": 7}}`)
+	}))
+	defer jenkins.Close()
+	writeConfig(t, dir, jenkins.URL)
+	defer config.SetPath("")
+	if err := ioutil.WriteFile(filepath.Join(dir, "state.json"), []byte(`{"/job/foo": {"Name": "/job/foo", "build": {"number": 5}}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, build := range []string{"4", "5"} {
+		if err := os.MkdirAll(filepath.Join(dir, "foo", build), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	status := commandNamed("status")
+	// like main, only warnings are logged so the output stays readable
+	setVerbosity(status.daemon)
+
+	stdout, stderr, code := captureOutput(t, func() int { return statusCommand(status, []string{}) })
+	if code != 0 {
+		t.Fatalf("status exited %d: %s", code, stderr)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("status printed %q", stdout)
+	}
+	if fields := strings.Fields(lines[1]); strings.Join(fields, " ") != "foo 5 7 4,5 "+filepath.Join(dir, "foo") {
+		t.Errorf("the job's status is %q", lines[1])
+	}
+
+	// offline, Jenkins isn't asked for the latest build
+	jenkins.Close()
+	stdout, stderr, code = captureOutput(t, func() int { return statusCommand(status, []string{"--offline"}) })
+	if code != 0 {
+		t.Fatalf("status --offline exited %d: %s", code, stderr)
+	}
+	if fields := strings.Fields(strings.Split(strings.TrimSpace(stdout), "\n")[1]); len(fields) < 3 || fields[2] != "-" {
+		t.Errorf("the offline status is %q", stdout)
+	}
+
+	// but if Jenkins can't be reached online, the status is an error
+	stdout, stderr, code = captureOutput(t, func() int { return statusCommand(status, []string{}) })
+	if code != 1 || !strings.Contains(stderr, "foo: ") {
+		t.Errorf("status exited %d without Jenkins: %s", code, stderr)
+	}
+	if !strings.Contains(stdout, " error ") {
+		t.Errorf("the latest build of the unreachable job is shown as %q", stdout)
+	}
+
+	if _, _, code := captureOutput(t, func() int { return statusCommand(status, []string{"foo"}) }); code != 2 {
+		t.Errorf("status with an argument exited %d", code)
+	}
+}
+
+func TestVerifyCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jenkronize-verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	buildDir := filepath.Join(dir, "3")
+	if err := os.MkdirAll(buildDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("hello"))
+	for name, content := range map[string]string{
+		"a.txt":      "hello",
+		"SHA256SUMS": hex.EncodeToString(sum[:]) + "  a.txt\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(buildDir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	verify := commandNamed("verify")
+
+	stdout, stderr, code := captureOutput(t, func() int { return verifyCommand(verify, []string{buildDir}) })
+	if code != 0 {
+		t.Fatalf("verify exited %d: %s", code, stderr)
+	}
+	if expected := filepath.Join(buildDir, "a.txt") + ": OK (SHA256SUMS)\n"; stdout != expected {
+		t.Errorf("verify printed %q", stdout)
+	}
+
+	// a corrupted artifact fails, as does a dir without checksum lists, but
+	// the other dirs are still checked
+	if err := ioutil.WriteFile(filepath.Join(buildDir, "a.txt"), []byte("jello"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr, code = captureOutput(t, func() int { return verifyCommand(verify, []string{dir, buildDir}) })
+	if code != 1 {
+		t.Errorf("verify of a corrupted build exited %d", code)
+	}
+	if !strings.Contains(stderr, "no checksum lists") {
+		t.Errorf("verify of a dir without checksum lists printed %q", stderr)
+	}
+	if !strings.HasPrefix(stdout, filepath.Join(buildDir, "a.txt")+": FAILED (SHA256SUMS): ") {
+		t.Errorf("verify of a corrupted build printed %q", stdout)
+	}
+
+	if _, _, code := captureOutput(t, func() int { return verifyCommand(verify, []string{}) }); code != 2 {
+		t.Errorf("verify without a dir exited %d", code)
+	}
+}
@@ -0,0 +1,163 @@
+package config
+
+import (
+	"fmt"
+	"github.com/go-yaml/yaml"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const envPrefix = "JENKRONIZE"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// applyEnv overrides the config with environment variables named after the
+// yaml keys of each field, upper-cased and joined with underscores under the
+// JENKRONIZE prefix, eg. JENKRONIZE_JENKINS_URL for the url of the jenkins
+// section. List items are addressed by index, as in
+// JENKRONIZE_TRACKER_TRACKEDJOBS_0_SYNC_DIR, and an index one past the end
+// adds an item. Map entries are addressed by key, as in
+// JENKRONIZE_LOG_LEVELS_JENKINS. Lists of strings are comma separated.
+func (c *Config) applyEnv() error {
+	return applyEnv(reflect.ValueOf(c).Elem(), envPrefix, environ())
+}
+
+func environ() map[string]string {
+	env := map[string]string{}
+	for _, kv := range os.Environ() {
+		if i := strings.Index(kv, "="); i > 0 && strings.HasPrefix(kv, envPrefix+"_") {
+			env[kv[:i]] = kv[i+1:]
+		}
+	}
+	return env
+}
+
+// hasPrefix reports whether any of the variables are under the prefix.
+func hasPrefix(env map[string]string, prefix string) bool {
+	for name := range env {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// yamlKey returns the key a struct field is decoded from, the same way the
+// yaml package does.
+func yamlKey(field reflect.StructField) string {
+	key := strings.Split(field.Tag.Get("yaml"), ",")[0]
+	if key == "" {
+		key = strings.ToLower(field.Name)
+	}
+	return key
+}
+
+func applyEnv(v reflect.Value, name string, env map[string]string) error {
+	if v.Type() == durationType {
+		if s, ok := env[name]; ok {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			v.SetInt(int64(d))
+		}
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		if s, ok := env[name]; ok {
+			v.SetString(s)
+		}
+	case reflect.Bool:
+		if s, ok := env[name]; ok {
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				return fmt.Errorf("%s: %q is not a boolean", name, s)
+			}
+			v.SetBool(b)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if s, ok := env[name]; ok {
+			n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+			if err != nil {
+				return fmt.Errorf("%s: %q is not an integer", name, s)
+			}
+			v.SetInt(n)
+		}
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String || v.Type().Elem().Kind() != reflect.String {
+			return nil
+		}
+		for envName, s := range env {
+			if !strings.HasPrefix(envName, name+"_") {
+				continue
+			}
+			if v.IsNil() {
+				v.Set(reflect.MakeMap(v.Type()))
+			}
+			key := strings.ToLower(strings.TrimPrefix(envName, name+"_"))
+			v.SetMapIndex(reflect.ValueOf(key), reflect.ValueOf(s))
+		}
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.String {
+			if s, ok := env[name]; ok {
+				items := []string{}
+				for _, item := range strings.Split(s, ",") {
+					if item = strings.TrimSpace(item); item != "" {
+						items = append(items, item)
+					}
+				}
+				v.Set(reflect.ValueOf(items))
+			}
+			return nil
+		}
+		for i := 0; ; i++ {
+			itemName := fmt.Sprintf("%s_%d", name, i)
+			if i >= v.Len() {
+				if !hasPrefix(env, itemName+"_") {
+					return nil
+				}
+				// new items get the defaults of items in the config file
+				item := reflect.New(v.Type().Elem())
+				if err := yaml.Unmarshal([]byte("{}"), item.Interface()); err != nil {
+					return fmt.Errorf("%s: %v", itemName, err)
+				}
+				v.Set(reflect.Append(v, item.Elem()))
+			}
+			if err := applyEnv(v.Index(i), itemName, env); err != nil {
+				return err
+			}
+		}
+	case reflect.Ptr:
+		if v.Type().Elem().Kind() != reflect.Struct {
+			return nil
+		}
+		if v.IsNil() {
+			if !hasPrefix(env, name+"_") {
+				return nil
+			}
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return applyEnv(v.Elem(), name, env)
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				// unexported
+				continue
+			}
+			key := yamlKey(field)
+			if key == "-" {
+				continue
+			}
+			if err := applyEnv(v.Field(i), name+"_"+strings.ToUpper(key), env); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
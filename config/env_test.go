@@ -0,0 +1,106 @@
+package config
+
+import (
+	"github.com/go-yaml/yaml"
+	"github.com/pakohler/jenkronize/tracking"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestApplyEnv(t *testing.T) {
+	c := &Config{}
+	err := yaml.Unmarshal([]byte(`
+jenkins:
+  url: https://jenkins.example.org
+tracker:
+  interval: 1m
+  trackedjobs:
+  - name: /job/installer
+    sync_dir: /opt/jenkins-sync/installer
+`), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{
+		"JENKRONIZE_JENKINS_PASSWORD":               "secret",
+		"JENKRONIZE_TRACKER_INTERVAL":               "5m",
+		"JENKRONIZE_TRACKER_TRACKEDJOBS_0_ALIAS":    "installer",
+		"JENKRONIZE_TRACKER_TRACKEDJOBS_1_NAME":     "/job/ui",
+		"JENKRONIZE_TRACKER_TRACKEDJOBS_1_SYNC_DIR": "/opt/jenkins-sync/ui",
+		"JENKRONIZE_EMAIL_TO":                       "ops@example.org, dev@example.org",
+		"JENKRONIZE_EMAIL_PORT":                     "2525",
+		"JENKRONIZE_EMAIL_INSECURE_SKIP_VERIFY":     "true",
+		"JENKRONIZE_LOG_LEVELS_JENKINS":             "debug",
+		"JENKRONIZE_SYSLOG_TAG":                     "mirror",
+	}
+	if err := applyEnv(reflect.ValueOf(c).Elem(), envPrefix, env); err != nil {
+		t.Fatal(err)
+	}
+	if c.Jenkins.URL != "https://jenkins.example.org" || c.Jenkins.Password != "secret" {
+		t.Errorf("jenkins is %+v", c.Jenkins)
+	}
+	if c.Tracker.Interval != 5*time.Minute {
+		t.Errorf("interval is %s", c.Tracker.Interval)
+	}
+	jobs := c.Tracker.TrackedJobs
+	if len(jobs) != 2 {
+		t.Fatalf("%d jobs are tracked, expected 2", len(jobs))
+	}
+	if jobs[0].Name != "/job/installer" || jobs[0].Alias != "installer" {
+		t.Errorf("the first job is %+v", jobs[0])
+	}
+	if jobs[1].Name != "/job/ui" || jobs[1].SyncDir != "/opt/jenkins-sync/ui" {
+		t.Errorf("the added job is %+v", jobs[1])
+	}
+	if jobs[1].BuildsToCache != -1 {
+		t.Errorf("the added job caches %d builds; it should have the same default as a job in the config file", jobs[1].BuildsToCache)
+	}
+	if !reflect.DeepEqual(c.Email.To, []string{"ops@example.org", "dev@example.org"}) {
+		t.Errorf("email recipients are %q", c.Email.To)
+	}
+	if c.Email.Port != 2525 || !c.Email.InsecureSkipVerify {
+		t.Errorf("email is %+v", c.Email)
+	}
+	if c.LogLevels["jenkins"] != "debug" {
+		t.Errorf("log levels are %v", c.LogLevels)
+	}
+	if c.Syslog == nil || c.Syslog.Tag != "mirror" {
+		t.Errorf("syslog is %+v", c.Syslog)
+	}
+}
+
+func TestApplyEnvMap(t *testing.T) {
+	c := &Config{Tracker: TrackerConfig{TrackedJobs: []*tracking.TrackedJob{{Name: "/job/installer"}}}}
+	env := map[string]string{
+		"JENKRONIZE_TRACKER_TRACKEDJOBS_0_MATCH_PARAMETERS_RELEASE_VERSION": "5.2.*",
+		"JENKRONIZE_MESSAGES_SYNC_FAILED":                                   "failed",
+		"JENKRONIZE_LOG_LEVELS_TRACKING":                                    "trace",
+	}
+	if err := applyEnv(reflect.ValueOf(c).Elem(), envPrefix, env); err != nil {
+		t.Fatal(err)
+	}
+	// map keys are lower-cased; parameter names are matched regardless of case
+	if c.Tracker.TrackedJobs[0].MatchParameters["release_version"] != "5.2.*" {
+		t.Errorf("the parameter patterns are %v", c.Tracker.TrackedJobs[0].MatchParameters)
+	}
+	if c.Messages["sync_failed"] != "failed" {
+		t.Errorf("the messages are %v", c.Messages)
+	}
+	if c.LogLevels["tracking"] != "trace" {
+		t.Errorf("log levels are %v", c.LogLevels)
+	}
+}
+
+func TestApplyEnvInvalid(t *testing.T) {
+	for name, value := range map[string]string{
+		"JENKRONIZE_TRACKER_INTERVAL": "soon",
+		"JENKRONIZE_EMAIL_PORT":       "smtp",
+		"JENKRONIZE_JOURNALD":         "maybe",
+	} {
+		c := &Config{}
+		if err := applyEnv(reflect.ValueOf(c).Elem(), envPrefix, map[string]string{name: value}); err == nil {
+			t.Errorf("%s=%s was accepted", name, value)
+		}
+	}
+}
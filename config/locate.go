@@ -0,0 +1,130 @@
+package config
+
+import (
+	"strings"
+)
+
+// sourceLine is a meaningful line of a YAML document, as far as finding where
+// a setting is goes.
+type sourceLine struct {
+	number int
+	// start is the column of the first character, which may be a list dash
+	start int
+	dash  bool
+	// keyCol is the column of the key, after any list dash
+	keyCol int
+	key    string
+}
+
+// yamlSource finds the lines of settings in a config file by their indentation.
+// It only understands block-style YAML, which is all the config needs, and
+// settings written any other way just aren't located.
+type yamlSource struct {
+	lines []sourceLine
+}
+
+func newYamlSource(source []byte) *yamlSource {
+	s := &yamlSource{}
+	for i, text := range strings.Split(string(source), "\n") {
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "---") {
+			continue
+		}
+		line := sourceLine{number: i + 1, start: len(text) - len(trimmed)}
+		line.keyCol = line.start
+		if trimmed == "-" || strings.HasPrefix(trimmed, "- ") {
+			line.dash = true
+			rest := strings.TrimLeft(trimmed[1:], " ")
+			line.keyCol = len(text) - len(rest)
+			trimmed = rest
+		}
+		if i := strings.Index(trimmed, ":"); i > 0 && (i == len(trimmed)-1 || trimmed[i+1] == ' ') {
+			line.key = strings.Trim(trimmed[:i], `"'`)
+		}
+		s.lines = append(s.lines, line)
+	}
+	return s
+}
+
+// locate returns the line number of the setting at path, a list of keys and
+// list indices. If the setting isn't in the file, the line of the nearest
+// parent which is is returned instead, or 0 if there is none.
+func (s *yamlSource) locate(path []interface{}) int {
+	start, end := 0, len(s.lines)
+	found := 0
+	for _, p := range path {
+		if start >= end {
+			break
+		}
+		var i int
+		switch p := p.(type) {
+		case int:
+			i, end = s.item(start, end, p)
+		case string:
+			i, end = s.key(start, end, p)
+		default:
+			i = -1
+		}
+		if i < 0 {
+			break
+		}
+		found = s.lines[i].number
+		start = i
+		if _, ok := p.(string); ok {
+			// the value of a key starts on the following line
+			start = i + 1
+		}
+	}
+	return found
+}
+
+// key finds the key in the mapping spanning lines start to end, returning its
+// line and the end of its value.
+func (s *yamlSource) key(start int, end int, key string) (int, int) {
+	col := s.lines[start].keyCol
+	for i := start; i < end; i++ {
+		line := s.lines[i]
+		if line.keyCol != col || line.key != key {
+			continue
+		}
+		j := i + 1
+		for ; j < end; j++ {
+			next := s.lines[j]
+			// a list may be indented to the same column as its key
+			if next.start < col || (next.start == col && !next.dash) {
+				break
+			}
+		}
+		return i, j
+	}
+	return -1, end
+}
+
+// item finds the nth item of the list spanning lines start to end, returning
+// its line and the end of the item.
+func (s *yamlSource) item(start int, end int, n int) (int, int) {
+	col := s.lines[start].start
+	count := 0
+	for i := start; i < end; i++ {
+		line := s.lines[i]
+		if line.start < col {
+			break
+		}
+		if line.start != col || !line.dash {
+			continue
+		}
+		if count < n {
+			count++
+			continue
+		}
+		j := i + 1
+		for ; j < end; j++ {
+			next := s.lines[j]
+			if next.start < col || (next.start == col && next.dash) {
+				break
+			}
+		}
+		return i, j
+	}
+	return -1, end
+}
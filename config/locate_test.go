@@ -0,0 +1,132 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const locateSource = `# jenkronize
+jenkins:
+  url: https://jenkins.example.org
+
+tracker:
+  interval: 1m
+  trackedjobs:
+  - name: /job/installer
+    sync_dir: /opt/jenkins-sync/installer
+    public_url: https://mirror.example.org/installer
+    builds_to_cache: 2
+  -   name: /job/ui
+      alias: UI
+notifiers:
+- name: ops
+  slack:
+    webhook: https://hooks.slack.com/services/x
+`
+
+func TestLocate(t *testing.T) {
+	source := newYamlSource([]byte(locateSource))
+	tests := []struct {
+		path []interface{}
+		line int
+	}{
+		{[]interface{}{"jenkins", "url"}, 3},
+		{[]interface{}{"tracker", "interval"}, 6},
+		{[]interface{}{"tracker", "trackedjobs"}, 7},
+		{[]interface{}{"tracker", "trackedjobs", 0}, 8},
+		{[]interface{}{"tracker", "trackedjobs", 0, "sync_dir"}, 9},
+		{[]interface{}{"tracker", "trackedjobs", 0, "builds_to_cache"}, 11},
+		{[]interface{}{"tracker", "trackedjobs", 1, "alias"}, 13},
+		{[]interface{}{"notifiers", 0, "slack", "webhook"}, 17},
+		// settings which aren't in the file are located at their parent
+		{[]interface{}{"tracker", "trackedjobs", 1, "sync_dir"}, 12},
+		{[]interface{}{"tracker", "trackedjobs", 2, "name"}, 7},
+		{[]interface{}{"email", "host"}, 0},
+	}
+	for _, test := range tests {
+		if line := source.locate(test.path); line != test.line {
+			t.Errorf("%s is on line %d, expected %d", formatPath(test.path), line, test.line)
+		}
+	}
+}
+
+func TestValidationLines(t *testing.T) {
+	c := &Config{}
+	source := []byte(strings.Replace(locateSource, "  interval: 1m", "  interval: 1m\n  intervall: 2m", 1))
+	v := &validator{source: newYamlSource(source)}
+	if err := c.decode(source, v); err != nil {
+		t.Fatal(err)
+	}
+	v.add([]interface{}{"tracker", "trackedjobs", 1, "sync_dir"}, "no sync_dir")
+	err, ok := v.err("config.yaml").(*ValidationError)
+	if !ok {
+		t.Fatal("the problems weren't reported")
+	}
+	expected := []string{
+		`line 7: unknown setting "intervall"`,
+		"line 13: tracker.trackedjobs[1].sync_dir: no sync_dir",
+	}
+	if strings.Join(err.Problems, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("the problems are:\n%s\nexpected:\n%s", strings.Join(err.Problems, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+// invalidSource has a problem of each kind: a typo, a value of the wrong type,
+// and settings which only validation catches. The jobs after the invalid ones
+// are still located by their index. %[1]s is where the jobs are synced to.
+const invalidSource = `jenkins:
+  url: ftp://jenkins.example.org
+tracker:
+  interval: 0s
+  trackedjobs:
+  - name: /job/installer
+    sync_dir: %[1]s/installer
+    builds_to_cache: many
+  - name: /job/ui
+    alias: UI
+    syncdir: %[1]s/ui
+  - name: /job/installer
+    sync_dir: %[1]s/installer/nested
+checksums:
+  algorithms: [sha256, crc32]
+`
+
+func TestLoadValidationLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jenkronize-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configFile := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(configFile, []byte(fmt.Sprintf(invalidSource, dir)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	SetPath(configFile)
+	defer SetPath("")
+
+	_, err = Load()
+	validationErr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("loading an invalid config returned %v", err)
+	}
+	if validationErr.Path != configFile {
+		t.Errorf("the invalid config is %s", validationErr.Path)
+	}
+	expected := []string{
+		`line 2: jenkins.url: "ftp://jenkins.example.org" is not an http or https URL`,
+		"line 4: tracker.interval: must be greater than zero, eg. 10m",
+		"line 8: cannot unmarshal !!str `many` into int",
+		"line 9: tracker.trackedjobs[1].sync_dir: a dir to sync the artifacts to is required",
+		`line 11: unknown setting "syncdir"`,
+		"line 12: tracker.trackedjobs[2].name: the job is already tracked by trackedjobs[0]",
+		"line 13: tracker.trackedjobs[2].sync_dir: inside the sync_dir of trackedjobs[0]",
+		`line 15: checksums.algorithms[1]: unknown checksum algorithm "crc32"; it should be one of sha256, sha512 or blake2b`,
+	}
+	if strings.Join(validationErr.Problems, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("the problems are:\n%s\nexpected:\n%s", strings.Join(validationErr.Problems, "\n"), strings.Join(expected, "\n"))
+	}
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"github.com/pakohler/jenkronize/common"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// explicitPath is the config file given with SetPath, if any.
+var explicitPath string
+
+// SetPath uses the config file at path rather than searching for one.
+func SetPath(path string) {
+	explicitPath = path
+}
+
+// searchPaths returns the places a config file is looked for, in order. The
+// executable's dir comes first, as it was the only place looked in before.
+func searchPaths() ([]string, error) {
+	dir, err := common.GetExeDir()
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{filepath.Join(dir, configFileName)}
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		if home := os.Getenv("HOME"); home != "" {
+			configHome = filepath.Join(home, ".config")
+		}
+	}
+	if configHome != "" {
+		paths = append(paths, filepath.Join(configHome, "jenkronize", configFileName))
+	}
+	configDirs := os.Getenv("XDG_CONFIG_DIRS")
+	if configDirs == "" {
+		configDirs = "/etc/xdg"
+	}
+	for _, d := range strings.Split(configDirs, string(os.PathListSeparator)) {
+		if d != "" {
+			paths = append(paths, filepath.Join(d, "jenkronize", configFileName))
+		}
+	}
+	paths = append(paths, filepath.Join("/etc/jenkronize", configFileName))
+	return paths, nil
+}
+
+// Path returns the path of the config file: the one given with SetPath or
+// $JENKRONIZE_CONFIG if either is set, otherwise the first of the search paths
+// that exists. If none do, it's the path beside the executable.
+func Path() (string, error) {
+	if explicitPath != "" {
+		return explicitPath, nil
+	}
+	if env := os.Getenv(envPrefix + "_CONFIG"); env != "" {
+		return env, nil
+	}
+	paths, err := searchPaths()
+	if err != nil {
+		return "", err
+	}
+	for _, path := range paths {
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return paths[0], nil
+}
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// setEnv sets the environment variable, returning a func which restores it.
+func setEnv(name string, value string) func() {
+	old, ok := os.LookupEnv(name)
+	os.Setenv(name, value)
+	return func() {
+		if ok {
+			os.Setenv(name, old)
+		} else {
+			os.Unsetenv(name)
+		}
+	}
+}
+
+func TestSearchPaths(t *testing.T) {
+	defer setEnv("XDG_CONFIG_HOME", "/home/user/.config")()
+	defer setEnv("XDG_CONFIG_DIRS", "/etc/xdg:/usr/local/etc/xdg")()
+	paths, err := searchPaths()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		filepath.Join("/home/user/.config", "jenkronize", configFileName),
+		filepath.Join("/etc/xdg", "jenkronize", configFileName),
+		filepath.Join("/usr/local/etc/xdg", "jenkronize", configFileName),
+		filepath.Join("/etc/jenkronize", configFileName),
+	}
+	if len(paths) != len(expected)+1 {
+		t.Fatalf("the search paths are %v", paths)
+	}
+	// the first is beside the executable
+	if filepath.Base(paths[0]) != configFileName {
+		t.Errorf("the first search path is %s", paths[0])
+	}
+	for i, path := range expected {
+		if paths[i+1] != path {
+			t.Errorf("search path %d is %s, expected %s", i+1, paths[i+1], path)
+		}
+	}
+}
+
+func TestPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jenkronize-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configHome := filepath.Join(dir, "home")
+	found := filepath.Join(configHome, "jenkronize", configFileName)
+	if err := os.MkdirAll(filepath.Dir(found), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(found, []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer setEnv("XDG_CONFIG_HOME", configHome)()
+	defer setEnv("XDG_CONFIG_DIRS", filepath.Join(dir, "none"))()
+	defer setEnv(envPrefix+"_CONFIG", "")()
+	defer SetPath("")
+
+	if path, err := Path(); err != nil || path != found {
+		t.Errorf("found %s (%v), expected %s", path, err, found)
+	}
+	os.Setenv(envPrefix+"_CONFIG", "/from/env.yaml")
+	if path, _ := Path(); path != "/from/env.yaml" {
+		t.Errorf("$JENKRONIZE_CONFIG was ignored; found %s", path)
+	}
+	SetPath("/from/flag.yaml")
+	if path, _ := Path(); path != "/from/flag.yaml" {
+		t.Errorf("--config was ignored; found %s", path)
+	}
+}
@@ -0,0 +1,395 @@
+package config
+
+import (
+	"fmt"
+	"github.com/go-yaml/yaml"
+	"github.com/pakohler/jenkronize/logging"
+	"github.com/pakohler/jenkronize/tracking"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ValidationError lists everything wrong with a config file, so it can all be
+// fixed in one go.
+type ValidationError struct {
+	Path     string
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("config file %s is invalid:\n  %s", e.Path, strings.Join(e.Problems, "\n  "))
+}
+
+type problem struct {
+	line int
+	text string
+}
+
+// validator collects problems with a config, locating each in the source.
+type validator struct {
+	source   *yamlSource
+	problems []problem
+}
+
+// add records a problem with the setting at path, a list of keys and list
+// indices such as "tracker", "trackedjobs", 0, "sync_dir".
+func (v *validator) add(path []interface{}, format string, args ...interface{}) {
+	text := formatPath(path) + ": " + fmt.Sprintf(format, args...)
+	line := v.source.locate(path)
+	if line > 0 {
+		text = fmt.Sprintf("line %d: %s", line, text)
+	}
+	v.problems = append(v.problems, problem{line: line, text: text})
+}
+
+func (v *validator) addLine(line int, text string) {
+	v.problems = append(v.problems, problem{line: line, text: text})
+}
+
+func (v *validator) err(configPath string) error {
+	if len(v.problems) == 0 {
+		return nil
+	}
+	// in the order they appear in the file, with anything not in the file last
+	sort.SliceStable(v.problems, func(i, j int) bool {
+		a, b := v.problems[i].line, v.problems[j].line
+		return a != 0 && (b == 0 || a < b)
+	})
+	texts := make([]string, len(v.problems))
+	for i, p := range v.problems {
+		texts[i] = p.text
+	}
+	return &ValidationError{Path: configPath, Problems: texts}
+}
+
+func formatPath(path []interface{}) string {
+	var b strings.Builder
+	for _, p := range path {
+		switch p := p.(type) {
+		case int:
+			fmt.Fprintf(&b, "[%d]", p)
+		default:
+			if b.Len() > 0 {
+				b.WriteString(".")
+			}
+			fmt.Fprint(&b, p)
+		}
+	}
+	return b.String()
+}
+
+var typeErrorLine = regexp.MustCompile(`^line (\d+): (.*)$`)
+var unknownField = regexp.MustCompile(`^field (\S+) not found in type \S+$`)
+
+// decode strictly decodes the config source, recording any unknown fields or
+// values of the wrong type as problems. It only returns an error if the source
+// isn't valid YAML at all.
+func (c *Config) decode(source []byte, v *validator) error {
+	err := yaml.UnmarshalStrict(source, c)
+	typeErr, ok := err.(*yaml.TypeError)
+	if !ok {
+		return err
+	}
+	// the rest of the config is still decoded, so it can be validated too
+	for _, msg := range typeErr.Errors {
+		m := typeErrorLine.FindStringSubmatch(msg)
+		if m == nil {
+			v.addLine(0, msg)
+			continue
+		}
+		line, _ := strconv.Atoi(m[1])
+		text := m[2]
+		if f := unknownField.FindStringSubmatch(text); f != nil {
+			text = fmt.Sprintf("unknown setting %q", f[1])
+		}
+		v.addLine(line, fmt.Sprintf("line %d: %s", line, text))
+	}
+	return nil
+}
+
+// validate checks the settings which can't be checked by decoding alone.
+func (c *Config) validate(v *validator) {
+	c.validateJenkins(v)
+	c.validateTracker(v)
+	c.validateChecksums(v)
+	if c.BlobStore.Dir != "" {
+		if !c.BlobStore.Enabled {
+			v.add([]interface{}{"blob_store", "enabled"}, "must be true for the dir to be used")
+		} else if dir, err := filepath.Abs(c.BlobStore.Dir); err != nil {
+			v.add([]interface{}{"blob_store", "dir"}, "%v", err)
+		} else if err := checkWritable(dir); err != nil {
+			v.add([]interface{}{"blob_store", "dir"}, "%v", err)
+		}
+	}
+	if _, err := tracking.NewMessages(c.Messages); err != nil {
+		v.add([]interface{}{"messages"}, "%v", err)
+	}
+	if c.LogLevel != "" {
+		if _, err := logging.ParseLevel(c.LogLevel); err != nil {
+			v.add([]interface{}{"log_level"}, "%v", err)
+		}
+	}
+	for pkg, name := range c.LogLevels {
+		if _, err := logging.ParseLevel(name); err != nil {
+			v.add([]interface{}{"log_levels", pkg}, "%v", err)
+		}
+	}
+	if _, err := logging.ParseFormat(c.LogFormat); err != nil {
+		v.add([]interface{}{"log_format"}, "%v", err)
+	}
+}
+
+func (c *Config) validateJenkins(v *validator) {
+	path := []interface{}{"jenkins", "url"}
+	if c.Jenkins.URL == "" {
+		v.add(path, "the URL of the Jenkins server is required")
+		return
+	}
+	u, err := url.Parse(c.Jenkins.URL)
+	if err != nil {
+		v.add(path, "%v", err)
+		return
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		v.add(path, "%q is not an http or https URL", c.Jenkins.URL)
+	}
+}
+
+func (c *Config) validateChecksums(v *validator) {
+	for i, name := range c.Checksums.Algorithms {
+		if _, err := tracking.ParseChecksumAlgorithm(name); err != nil {
+			v.add([]interface{}{"checksums", "algorithms", i}, "%v", err)
+		}
+	}
+	signing := c.Checksums.Signing
+	if signing.KeyFile == "" {
+		if signing.Type != "" {
+			v.add([]interface{}{"checksums", "signing", "key_file"}, "the key to sign with is required")
+		}
+		return
+	}
+	if signing.Type == "" {
+		v.add([]interface{}{"checksums", "signing", "type"}, "the type of key is required; it should be %s or %s", tracking.SignEd25519, tracking.SignOpenPGP)
+		return
+	}
+	// the key is loaded, so a wrong passphrase is caught too
+	if _, err := tracking.NewSigner(signing.Type, signing.KeyFile, signing.Passphrase); err != nil {
+		v.add([]interface{}{"checksums", "signing"}, "%v", err)
+	}
+}
+
+func (c *Config) validateTracker(v *validator) {
+	if c.Tracker.Interval <= 0 {
+		v.add([]interface{}{"tracker", "interval"}, "must be greater than zero, eg. 10m")
+	}
+	if c.Tracker.DiscoveryInterval < 0 {
+		v.add([]interface{}{"tracker", "discovery_interval"}, "can't be negative")
+	}
+	if len(c.Tracker.TrackedJobs) == 0 {
+		v.add([]interface{}{"tracker", "trackedjobs"}, "no jobs are tracked")
+	}
+	names := map[string]int{}
+	aliases := map[string]int{}
+	syncDirs := map[int]string{}
+	for i, job := range c.Tracker.TrackedJobs {
+		path := []interface{}{"tracker", "trackedjobs", i}
+		if job == nil {
+			v.add(path, "empty job")
+			continue
+		}
+		at := func(key string) []interface{} {
+			return append(append([]interface{}{}, path...), key)
+		}
+		validateBuildSelection(v, job, at)
+		if job.IsSelector() {
+			c.validateSelector(v, job, at)
+			continue
+		}
+		name := strings.ToLower(strings.TrimRight(job.Name, "/"))
+		duplicate := false
+		if name == "" {
+			v.add(at("name"), "the path of the job is required, eg. /job/foo, or a name_pattern or name_regex to select jobs by")
+		} else if first, ok := names[name]; ok {
+			v.add(at("name"), "the job is already tracked by trackedjobs[%d]", first)
+			duplicate = true
+		} else {
+			names[name] = i
+		}
+		// aliases default to the name, so a duplicate name has already been
+		// reported
+		alias := job.Alias
+		if alias == "" {
+			alias = job.Name
+		}
+		if first, ok := aliases[alias]; ok && alias != "" && !duplicate {
+			v.add(at("alias"), "%q is already the alias of trackedjobs[%d]", alias, first)
+		} else if !ok {
+			aliases[alias] = i
+		}
+		if job.SyncDir == "" {
+			v.add(at("sync_dir"), "a dir to sync the artifacts to is required")
+			continue
+		}
+		dir, err := filepath.Abs(job.SyncDir)
+		if err != nil {
+			v.add(at("sync_dir"), "%v", err)
+			continue
+		}
+		if err := checkWritable(dir); err != nil {
+			v.add(at("sync_dir"), "%v", err)
+		}
+		for j := 0; j < i; j++ {
+			other, ok := syncDirs[j]
+			if !ok {
+				continue
+			}
+			switch {
+			case other == dir:
+				v.add(at("sync_dir"), "the same dir as the sync_dir of trackedjobs[%d]", j)
+			case strings.HasPrefix(dir, other+string(filepath.Separator)):
+				v.add(at("sync_dir"), "inside the sync_dir of trackedjobs[%d]", j)
+			case strings.HasPrefix(other, dir+string(filepath.Separator)):
+				v.add(at("sync_dir"), "contains the sync_dir of trackedjobs[%d]", j)
+			}
+		}
+		syncDirs[i] = dir
+	}
+}
+
+// validateBuildSelection checks how the builds of a job to sync are chosen,
+// and that only the settings which apply to them are set.
+func validateBuildSelection(v *validator, job *tracking.TrackedJob, at func(key string) []interface{}) {
+	selection, err := tracking.ParseBuildSelection(string(job.BuildSelection))
+	if err != nil {
+		v.add(at("build_selection"), "%v", err)
+		return
+	}
+	for i, result := range job.AllowedResults {
+		if _, err := tracking.ParseBuildResult(result); err != nil {
+			v.add(append(at("allowed_results"), i), "%v", err)
+		}
+	}
+	if len(job.AllowedResults) > 0 && selection != tracking.SelectLastCompleted {
+		v.add(at("allowed_results"), "only applies to a build_selection of %s", tracking.SelectLastCompleted)
+	}
+	if job.Promotion != "" && selection != tracking.SelectPromoted {
+		v.add(at("promotion"), "only applies to a build_selection of %s", tracking.SelectPromoted)
+	}
+	mode, err := tracking.ParseSyncMode(string(job.Mode))
+	if err != nil {
+		v.add(at("mode"), "%v", err)
+		return
+	}
+	switch {
+	case job.InitialBackfill < 0:
+		v.add(at("initial_backfill"), "can't be negative")
+	case job.InitialBackfill > 0 && mode != tracking.ModeAllBuilds:
+		v.add(at("initial_backfill"), "only applies to a mode of %s", tracking.ModeAllBuilds)
+	}
+	for name := range job.MatchParameters {
+		if name == "" {
+			v.add(at("match_parameters"), "the name of a parameter is empty")
+		}
+	}
+	switch {
+	case job.PinBuild < 0:
+		v.add(at("pin_build"), "can't be negative")
+	case job.PinBuild == 0:
+	case job.BuildSelection != "":
+		v.add(at("pin_build"), "can't be combined with a build_selection")
+	case mode == tracking.ModeAllBuilds:
+		v.add(at("pin_build"), "can't be combined with a mode of %s", tracking.ModeAllBuilds)
+	case job.HasMatchers():
+		v.add(at("pin_build"), "can't be combined with match_parameters, match_description or match_display_name")
+	}
+}
+
+// validateSelector checks a job with a name_pattern, name_regex or multibranch.
+// The jobs it matches aren't known until they're discovered, so only the
+// literal part of its sync_dir can be checked.
+func (c *Config) validateSelector(v *validator, job *tracking.TrackedJob, at func(key string) []interface{}) {
+	if job.Name != "" && !job.Multibranch {
+		v.add(at("name"), "can't be set along with a name_pattern or name_regex")
+	}
+	if job.BranchRetention < 0 {
+		v.add(at("branch_retention"), "can't be negative; leave it unset to keep the builds of deleted branches")
+	}
+	if job.SyncDir == "" {
+		if job.Multibranch {
+			v.add(at("sync_dir"), "a dir to sync the artifacts to is required; each branch is synced into a subdir of it")
+		} else {
+			v.add(at("sync_dir"), "a dir to sync the artifacts to is required, templated by the job, eg. /opt/jenkins-sync/{{index .Matches 0}}")
+		}
+		return
+	}
+	if _, err := tracking.NewJobSelector(job); err != nil {
+		key := "name_pattern"
+		switch {
+		case strings.HasPrefix(err.Error(), "multibranch"):
+			key = "multibranch"
+		case strings.HasPrefix(err.Error(), "name: "):
+			key = "name"
+		case strings.HasPrefix(err.Error(), "name_regex"):
+			key = "name_regex"
+		case strings.HasPrefix(err.Error(), "alias"):
+			key = "alias"
+		case strings.HasPrefix(err.Error(), "sync_dir"):
+			key = "sync_dir"
+		case strings.HasPrefix(err.Error(), "public_url"):
+			key = "public_url"
+		}
+		v.add(at(key), "%v", strings.TrimPrefix(err.Error(), key+": "))
+		return
+	}
+	literal := job.SyncDir
+	if i := strings.Index(literal, "{{"); i >= 0 {
+		literal = literal[:i]
+	}
+	if literal == "" {
+		return
+	}
+	dir, err := filepath.Abs(literal)
+	if err != nil {
+		v.add(at("sync_dir"), "%v", err)
+		return
+	}
+	if err := checkWritable(dir); err != nil {
+		v.add(at("sync_dir"), "%v", err)
+	}
+}
+
+// checkWritable returns why files can't be created in dir. A dir which doesn't
+// exist yet is created when syncing, so its nearest existing parent is checked
+// instead.
+func checkWritable(dir string) error {
+	for {
+		info, err := os.Stat(dir)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%s is not a dir", dir)
+			}
+			break
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return err
+		}
+		dir = parent
+	}
+	f, err := ioutil.TempFile(dir, ".jenkronize-")
+	if err != nil {
+		return fmt.Errorf("not writable: %v", err)
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return nil
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/pakohler/jenkronize/common"
+	"github.com/pakohler/jenkronize/config"
+	"github.com/pakohler/jenkronize/jenkins"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// prompter asks questions on the terminal for `config init --interactive`.
+type prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+func newPrompter() *prompter {
+	// prompts go to stderr, so they stay out of the config printed with --stdout
+	return &prompter{in: bufio.NewReader(os.Stdin), out: os.Stderr}
+}
+
+// ask returns the answer to the question, or def if nothing was entered.
+func (p *prompter) ask(question string, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", question)
+	}
+	line, err := p.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		fmt.Fprintln(p.out)
+		return "", errors.New("cancelled; no config was written")
+	}
+	if answer := strings.TrimSpace(line); answer != "" {
+		return answer, nil
+	}
+	return def, nil
+}
+
+func (p *prompter) confirm(question string, def bool) (bool, error) {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+	for {
+		answer, err := p.ask(question+" ("+hint+")", "")
+		if err != nil {
+			return false, err
+		}
+		switch strings.ToLower(answer) {
+		case "":
+			return def, nil
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+	}
+}
+
+// interactiveExample fills in an example config from the answers to prompts,
+// offering to discover the jobs to track from the Jenkins server. The Jenkins
+// settings default to any given as environment variables.
+func interactiveExample() (*config.Example, error) {
+	example := &config.Example{
+		JenkinsURL: os.Getenv("JENKRONIZE_JENKINS_URL"),
+		Username:   os.Getenv("JENKRONIZE_JENKINS_USERNAME"),
+		Password:   os.Getenv("JENKRONIZE_JENKINS_PASSWORD"),
+		Interval:   10 * time.Minute,
+		LogFile:    config.DefaultExample().LogFile,
+	}
+	p := newPrompter()
+	fmt.Fprintln(p.out, "Answer the following to create a config file; press enter to accept the value in brackets.")
+	for {
+		answer, err := p.ask("Jenkins URL", example.JenkinsURL)
+		if err != nil {
+			return nil, err
+		}
+		u, err := url.Parse(answer)
+		if err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+			example.JenkinsURL = strings.TrimRight(answer, "/")
+			break
+		}
+		fmt.Fprintln(p.out, "Please enter an http or https URL, eg. https://jenkins.yourdomain.org")
+	}
+	var err error
+	if example.Username, err = p.ask("Jenkins username", example.Username); err != nil {
+		return nil, err
+	}
+	if example.Password, err = p.ask("Jenkins password or API token (shown as it's typed)", example.Password); err != nil {
+		return nil, err
+	}
+	for {
+		answer, err := p.ask("How often to check for new builds", example.Interval.String())
+		if err != nil {
+			return nil, err
+		}
+		interval, err := time.ParseDuration(answer)
+		if err == nil && interval > 0 {
+			example.Interval = interval
+			break
+		}
+		fmt.Fprintln(p.out, "Please enter a duration, eg. 10m or 1h")
+	}
+
+	jobPaths, err := chooseJobs(p, example)
+	if err != nil {
+		return nil, err
+	}
+	if len(jobPaths) > 0 {
+		dir, err := common.GetExeDir()
+		if err != nil {
+			return nil, err
+		}
+		syncRoot, err := p.ask("Dir to sync artifacts to, in a subdir per job", filepath.Join(dir, "data"))
+		if err != nil {
+			return nil, err
+		}
+		example.Jobs = []config.ExampleJob{}
+		for _, jobPath := range jobPaths {
+			names := jobNames(jobPath)
+			example.Jobs = append(example.Jobs, config.ExampleJob{
+				Name:    jobPath,
+				Alias:   strings.Join(names, "::"),
+				SyncDir: filepath.Join(append([]string{syncRoot}, names...)...),
+			})
+		}
+	}
+
+	if example.LogFile, err = p.ask("Log file (enter - to only log to stdout and stderr)", example.LogFile); err != nil {
+		return nil, err
+	}
+	if example.LogFile == "-" {
+		example.LogFile = ""
+	}
+	return example, nil
+}
+
+// chooseJobs asks which jobs to track, either from those discovered on the
+// Jenkins server or by entering their paths.
+func chooseJobs(p *prompter, example *config.Example) ([]string, error) {
+	discover, err := p.confirm("Discover jobs from the Jenkins server?", true)
+	if err != nil {
+		return nil, err
+	}
+	if discover {
+		folder, err := p.ask("Folder to discover jobs in, eg. /job/team (empty for the whole server)", "")
+		if err != nil {
+			return nil, err
+		}
+		client := jenkins.
+			New().
+			SetUser(example.Username).
+			SetPassword(example.Password).
+			SetBaseUrl(example.JenkinsURL)
+		discovered, err := client.DiscoverJobs(folder)
+		switch {
+		case err != nil:
+			fmt.Fprintf(p.out, "Unable to discover jobs: %v\n", err)
+		case len(discovered) == 0:
+			fmt.Fprintln(p.out, "No jobs were found.")
+		default:
+			for i, jobPath := range discovered {
+				fmt.Fprintf(p.out, "%4d) %s\n", i+1, jobPath)
+			}
+			for {
+				answer, err := p.ask("Jobs to track, eg. 1,3-5 (empty for none)", "")
+				if err != nil {
+					return nil, err
+				}
+				chosen, err := parseSelection(answer, discovered)
+				if err == nil {
+					return chosen, nil
+				}
+				fmt.Fprintln(p.out, err)
+			}
+		}
+	}
+	jobPaths := []string{}
+	for {
+		answer, err := p.ask("Path of a job to track, eg. /job/foo/job/master (empty when done)", "")
+		if err != nil {
+			return nil, err
+		}
+		if answer == "" {
+			return jobPaths, nil
+		}
+		jobPaths = append(jobPaths, "/"+strings.Trim(answer, "/"))
+	}
+}
+
+// parseSelection picks items by their 1-based numbers, given as a comma
+// separated list of numbers and ranges.
+func parseSelection(answer string, items []string) ([]string, error) {
+	chosen := []string{}
+	for _, part := range strings.Split(answer, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		bounds := strings.SplitN(part, "-", 2)
+		first, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+		last := first
+		if err == nil && len(bounds) == 2 {
+			last, err = strconv.Atoi(strings.TrimSpace(bounds[1]))
+		}
+		if err != nil || first < 1 || last > len(items) || first > last {
+			return nil, fmt.Errorf("%q isn't a number or range from 1 to %d", part, len(items))
+		}
+		for i := first; i <= last; i++ {
+			chosen = append(chosen, items[i-1])
+		}
+	}
+	return chosen, nil
+}
+
+// jobNames returns the names of a job and the folders it's in, from its path.
+func jobNames(jobPath string) []string {
+	names := []string{}
+	for _, part := range strings.Split(jobPath, "/job/") {
+		part = strings.Trim(part, "/")
+		if part == "" {
+			continue
+		}
+		if name, err := url.PathUnescape(part); err == nil {
+			part = name
+		}
+		names = append(names, part)
+	}
+	return names
+}
+
+// initConfig writes an example config, or prints it with toStdout.
+func initConfig(interactive bool, toStdout bool) int {
+	example := config.DefaultExample()
+	if interactive {
+		var err error
+		if example, err = interactiveExample(); err != nil {
+			return fail(err)
+		}
+	}
+	if toStdout {
+		source, err := example.Render()
+		if err != nil {
+			return fail(err)
+		}
+		os.Stdout.Write(source)
+		return 0
+	}
+	path, err := config.WriteExample(example)
+	if err != nil {
+		return fail(err)
+	}
+	fmt.Printf("wrote a config file to %s; check it with `jenkronize config validate`\n", path)
+	return 0
+}
@@ -0,0 +1,114 @@
+package jenkins
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Action is one of the actions attached to a build. Jenkins has many kinds of
+// action, and only the fields of those that are used are decoded; the rest are
+// left empty.
+type Action struct {
+	Class string `json:"_class"`
+	// Parameters are set by a hudson.model.ParametersAction
+	Parameters []*Parameter
+	// Causes are set by a hudson.model.CauseAction
+	Causes []*Cause
+	// LastBuiltRevision and RemoteUrls are set by the BuildData of the git
+	// plugin, for each repo checked out by the build
+	LastBuiltRevision *Revision
+	RemoteUrls        []string
+	// FailCount, SkipCount and TotalCount are set by the test result actions
+	// of the JUnit plugin
+	FailCount  int
+	SkipCount  int
+	TotalCount int
+	// Promotions are set by the PromotedBuildAction of the Promoted Builds
+	// plugin
+	Promotions []*Promotion
+}
+
+// Parameter is the value a build was run with for one of its parameters.
+type Parameter struct {
+	Class string `json:"_class"`
+	Name  string
+	// Value is a string, bool or number depending on the kind of parameter,
+	// and nil for those without a simple value, such as file parameters
+	Value interface{}
+}
+
+// String returns the value of the parameter as it would be shown by Jenkins.
+func (p *Parameter) String() string {
+	switch v := p.Value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		// JSON numbers are decoded as floats, but parameters are integers
+		if v == float64(int64(v)) {
+			return fmt.Sprintf("%d", int64(v))
+		}
+	}
+	return fmt.Sprint(p.Value)
+}
+
+// Cause is why a build was started, eg. by a user, an upstream build or a
+// change in SCM.
+type Cause struct {
+	Class            string `json:"_class"`
+	ShortDescription string
+	// UserId and UserName are set when a user started the build
+	UserId   string
+	UserName string
+	// UpstreamProject, UpstreamBuild and UpstreamUrl are set when another
+	// build triggered this one
+	UpstreamProject string
+	UpstreamBuild   int32
+	UpstreamUrl     string
+}
+
+// Revision is a commit built by the git plugin, and the branches it's on.
+type Revision struct {
+	SHA1   string
+	Branch []*Branch
+}
+
+// BranchName returns the name of the first branch the commit is on, without
+// the refs/remotes/ prefix, eg. origin/master.
+func (r *Revision) BranchName() string {
+	if len(r.Branch) == 0 {
+		return ""
+	}
+	return strings.TrimPrefix(r.Branch[0].Name, "refs/remotes/")
+}
+
+// Branch is a git branch, as named by the git plugin, eg. origin/master.
+type Branch struct {
+	SHA1 string
+	Name string
+}
+
+// TestResult summarizes the tests run by a build.
+type TestResult struct {
+	Failed  int
+	Skipped int
+	Total   int
+}
+
+// String describes the test results, eg. "120 tests, 2 failed, 3 skipped".
+func (t *TestResult) String() string {
+	s := fmt.Sprintf("%d tests", t.Total)
+	if t.Failed > 0 {
+		s += fmt.Sprintf(", %d failed", t.Failed)
+	}
+	if t.Skipped > 0 {
+		s += fmt.Sprintf(", %d skipped", t.Skipped)
+	}
+	return s
+}
+
+// Promotion is a promotion of a build by the Promoted Builds plugin.
+type Promotion struct {
+	Name string
+}
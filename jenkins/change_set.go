@@ -0,0 +1,28 @@
+package jenkins
+
+// ChangeSet is the changes in one repo since the previous build.
+type ChangeSet struct {
+	Class string `json:"_class"`
+	// Kind is the kind of SCM, eg. git
+	Kind  string
+	Items []*ChangeSetItem
+}
+
+// ChangeSetItem is a single change, ie. a commit.
+type ChangeSetItem struct {
+	Class       string `json:"_class"`
+	CommitId    string
+	Msg         string
+	Comment     string
+	Timestamp   int64
+	Author      *User
+	AuthorEmail string
+	// AffectedPaths are the paths of the files changed
+	AffectedPaths []string
+}
+
+// User is a Jenkins user, or the author of a change.
+type User struct {
+	AbsoluteUrl string
+	FullName    string
+}
@@ -3,10 +3,12 @@ package jenkins
 import (
 	"crypto/tls"
 	"encoding/json"
+	"fmt"
 	"github.com/cavaliercoder/grab"
 	"github.com/pakohler/jenkronize/logging"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"strings"
@@ -29,26 +31,26 @@ func New() *JenkinsAPIClient {
 	j := JenkinsAPIClient{
 		http: &http.Client{Transport: transport},
 		grab: grab.NewClient(),
-		log:  logging.GetLogger(),
+		log:  logging.GetPackageLogger("jenkins"),
 	}
 	j.grab.HTTPClient = j.http
 	return &j
 }
 
 func (j *JenkinsAPIClient) SetUser(user string) *JenkinsAPIClient {
-	j.log.Info.Print("setting username to " + user)
+	j.log.With(logging.Fields{"user": user}).Debug("setting username")
 	j.user = user
 	return j
 }
 
 func (j *JenkinsAPIClient) SetPassword(pass string) *JenkinsAPIClient {
-	j.log.Info.Print("set password")
+	j.log.Debug.Print("set password")
 	j.password = pass
 	return j
 }
 
 func (j *JenkinsAPIClient) SetBaseUrl(baseUrl string) *JenkinsAPIClient {
-	j.log.Info.Print("set base URL to " + baseUrl)
+	j.log.With(logging.Fields{"url": baseUrl}).Debug("set base URL")
 	j.baseUrl = strings.TrimRight(baseUrl, "/")
 	return j
 }
@@ -65,81 +67,251 @@ func (j *JenkinsAPIClient) cleanUrl(urlPath string) string {
 }
 
 func (j *JenkinsAPIClient) getJson(urlPath string) ([]byte, error) {
-	url := j.cleanUrl(urlPath) + "/api/json"
-	j.log.Info.Print("GETing " + url)
+	return j.get(j.cleanUrl(urlPath) + "/api/json")
+}
+
+// getJsonTree is like getJson, but only asks for the properties selected by
+// tree, eg. `builds[number,result]`.
+func (j *JenkinsAPIClient) getJsonTree(urlPath string, tree string) ([]byte, error) {
+	return j.get(j.cleanUrl(urlPath) + "/api/json?tree=" + url.QueryEscape(tree))
+}
+
+func (j *JenkinsAPIClient) get(url string) ([]byte, error) {
+	log := j.log.With(logging.Fields{"url": url})
+	log.Debug("GETing")
 	req, err := http.NewRequest("GET", url, nil)
 	req.SetBasicAuth(j.user, j.password)
 	resp, err := j.http.Do(req)
 	if err != nil {
 		err = newJenkinsError("Request to "+url+" failed", err)
-		j.log.Error.Print(err.Error())
+		log.With(logging.Fields{"error": err}).Error("request failed")
 		return []byte{}, err
 	}
 	defer resp.Body.Close()
-	j.log.Info.Print("attempting to read response from " + url)
+	log.With(logging.Fields{"status": resp.StatusCode}).Trace("attempting to read response")
+	if resp.StatusCode == http.StatusNotFound {
+		return []byte{}, newJenkinsError("Not found: "+url, nil)
+	}
 	body, err := ioutil.ReadAll(resp.Body)
 	return body, err
 }
 
-func (j *JenkinsAPIClient) DownloadFile(urlPath string, destDir string) error {
+// partialDownloadSuffix is added to the name of a file while it's downloading.
+const partialDownloadSuffix = ".part"
+
+// DownloadPath returns the path DownloadFile saves the file at urlPath to.
+func (j *JenkinsAPIClient) DownloadPath(urlPath string, destDir string) string {
+	urlSplit := strings.Split(j.cleanUrl(urlPath), "/")
+	return path.Join(destDir, urlSplit[len(urlSplit)-1])
+}
+
+// DownloadFile fetches the file at urlPath into destDir and returns the path of
+// the downloaded file.
+func (j *JenkinsAPIClient) DownloadFile(urlPath string, destDir string) (string, error) {
 	url := j.cleanUrl(urlPath)
-	urlSplit := strings.Split(url, "/")
-	fileName := urlSplit[len(urlSplit)-1]
-	filePath := path.Join(destDir, fileName)
+	filePath := j.DownloadPath(urlPath, destDir)
 	if _, err := os.Stat(destDir); os.IsNotExist(err) {
 		os.MkdirAll(destDir, 0700)
 	}
-	j.log.Info.Print("Download starting: " + url)
+	log := j.log.With(logging.Fields{"artifact_url": url, "path": filePath})
+	log.Info("Download starting")
 	// since some artifacts are large and connections are unstable, we'll use
-	// `grab` with auto-resume enabled for the actual download
-	grabReq, _ := grab.NewRequest(filePath, url)
+	// `grab` with auto-resume enabled for the actual download. It downloads
+	// into a file of its own which is renamed into place once it's complete:
+	// the file at filePath may be a hardlink shared with other builds, which
+	// resuming into it would corrupt.
+	partPath := filePath + partialDownloadSuffix
+	grabReq, _ := grab.NewRequest(partPath, url)
 	grabReq.HTTPRequest.SetBasicAuth(j.user, j.password)
 	resp := j.grab.Do(grabReq)
 	<-resp.Done
 	if err := resp.Err(); err != nil {
 		err = newJenkinsError("Download failed: "+url, err)
-		j.log.Error.Print(err.Error())
-		return err
+		log.With(logging.Fields{"error": err}).Error("Download failed")
+		return "", err
+	}
+	if err := os.Rename(partPath, filePath); err != nil {
+		err = newJenkinsError("Download failed: "+url, err)
+		log.With(logging.Fields{"error": err}).Error("Download failed")
+		return "", err
 	}
-	j.log.Info.Print("Download complete: " + url)
-	return nil
+	if info, err := os.Stat(filePath); err == nil {
+		log = log.With(logging.Fields{"bytes": info.Size()})
+	}
+	log.Info("Download complete")
+	return filePath, nil
 }
 
-func (j *JenkinsAPIClient) GetLastSuccessfulBuildForJob(jobPath string) (*Build, error) {
-	j.log.Info.Print("Attempting to get the last successful build for " + jobPath)
+// GetJob returns the details of a job, including its last successful, stable
+// and completed builds.
+func (j *JenkinsAPIClient) GetJob(jobPath string) (*Job, error) {
+	log := j.log.With(logging.Fields{"job_path": jobPath})
+	log.Debug("attempting to get job")
 	resp, err := j.getJson(jobPath)
 	if err != nil {
-		j.log.Error.Print(err.Error())
+		log.With(logging.Fields{"error": err}).Error("failed to get job")
 		return nil, err
 	}
 	var job Job
 	err = json.Unmarshal(resp, &job)
 	if err != nil {
 		err = newJenkinsError(string(resp), err)
-		j.log.Error.Print(err.Error())
+		log.With(logging.Fields{"error": err}).Error("failed to parse job")
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (j *JenkinsAPIClient) GetLastSuccessfulBuildForJob(jobPath string) (*Build, error) {
+	j.log.With(logging.Fields{"job_path": jobPath}).Debug("Attempting to get the last successful build")
+	job, err := j.GetJob(jobPath)
+	if err != nil {
 		return nil, err
 	}
 	return job.LastSuccessfulBuild, nil
 }
 
 func (j *JenkinsAPIClient) GetArtifactUrlsFromBuild(buildPath string) ([]string, error) {
-	j.log.Info.Print("attempting to get artifact URLs from " + buildPath)
-	artifactUrls := []string{}
-	resp, err := j.getJson(buildPath)
+	build, err := j.GetBuildByUrl(buildPath)
 	if err != nil {
-		j.log.Error.Print(err)
 		return []string{}, err
 	}
+	return build.ArtifactUrls(), nil
+}
+
+// GetBuildByUrl returns the details of a build, including its artifacts,
+// actions and changes, from its URL.
+func (j *JenkinsAPIClient) GetBuildByUrl(buildPath string) (*JobBuild, error) {
+	log := j.log.With(logging.Fields{"build_url": buildPath})
+	log.Debug("attempting to get build")
+	resp, err := j.getJson(buildPath)
+	if err != nil {
+		log.With(logging.Fields{"error": err}).Error("failed to get build")
+		return nil, err
+	}
 	var build JobBuild
 	err = json.Unmarshal(resp, &build)
 	if err != nil {
 		err = newJenkinsError(string(resp), err)
-		j.log.Error.Print(err.Error())
-		return []string{}, err
+		log.With(logging.Fields{"error": err}).Error("failed to parse build")
+		return nil, err
+	}
+	return &build, nil
+}
+
+// GetBuild returns the details of a single build of a job.
+func (j *JenkinsAPIClient) GetBuild(jobPath string, number int32) (*JobBuild, error) {
+	log := j.log.With(logging.Fields{"job_path": jobPath, "build": number})
+	log.Debug("attempting to get build")
+	resp, err := j.getJson(fmt.Sprintf("%s/%d", strings.TrimRight(jobPath, "/"), number))
+	if err != nil {
+		log.With(logging.Fields{"error": err}).Error("failed to get build")
+		return nil, err
+	}
+	var build JobBuild
+	err = json.Unmarshal(resp, &build)
+	if err != nil {
+		err = newJenkinsError(string(resp), err)
+		log.With(logging.Fields{"error": err}).Error("failed to parse build")
+		return nil, err
+	}
+	return &build, nil
+}
+
+// GetFingerprints returns the MD5 fingerprints Jenkins recorded for the files
+// of a build, such as its artifacts if they're archived with fingerprinting.
+func (j *JenkinsAPIClient) GetFingerprints(buildPath string) ([]*Fingerprint, error) {
+	log := j.log.With(logging.Fields{"build_url": buildPath})
+	log.Debug("attempting to get fingerprints")
+	resp, err := j.getJsonTree(buildPath, "fingerprint[fileName,hash,original[name,number]]")
+	if err != nil {
+		log.With(logging.Fields{"error": err}).Error("failed to get fingerprints")
+		return nil, err
+	}
+	var build JobBuild
+	err = json.Unmarshal(resp, &build)
+	if err != nil {
+		err = newJenkinsError(string(resp), err)
+		log.With(logging.Fields{"error": err}).Error("failed to parse fingerprints")
+		return nil, err
+	}
+	return build.Fingerprint, nil
+}
+
+// GetBuilds returns the builds of a job that Jenkins still has, newest first,
+// from the start-th newest up to but not including the end-th; an end of 0
+// returns every build from start on. Unlike the builds of a job's details,
+// which are only its newest 100, any build Jenkins still has can be listed.
+// Only the number, URL, result, timing, names, description and whether to keep
+// the build forever are filled in, along with any parameters and promotions in
+// Actions.
+func (j *JenkinsAPIClient) GetBuilds(jobPath string, start int, end int) ([]*JobBuild, error) {
+	log := j.log.With(logging.Fields{"job_path": jobPath, "start": start, "end": end})
+	log.Debug("attempting to list builds")
+	buildRange := fmt.Sprintf("{%d,}", start)
+	if end > 0 {
+		buildRange = fmt.Sprintf("{%d,%d}", start, end)
+	}
+	resp, err := j.getJsonTree(jobPath, "allBuilds[number,url,result,building,timestamp,duration,keepLog,displayName,description,"+
+		"actions[_class,parameters[_class,name,value],promotions[name]]]"+buildRange)
+	if err != nil {
+		log.With(logging.Fields{"error": err}).Error("failed to list builds")
+		return nil, err
+	}
+	var job struct {
+		AllBuilds []*JobBuild
+	}
+	err = json.Unmarshal(resp, &job)
+	if err != nil {
+		err = newJenkinsError(string(resp), err)
+		log.With(logging.Fields{"error": err}).Error("failed to parse builds")
+		return nil, err
+	}
+	return job.AllBuilds, nil
+}
+
+// GetJobs returns the jobs in a folder, or at the top level of the server if
+// folderPath is empty. Only the class, name and URL of each job are filled in,
+// along with Jobs for those which are folders themselves.
+func (j *JenkinsAPIClient) GetJobs(folderPath string) ([]*Job, error) {
+	log := j.log.With(logging.Fields{"job_path": folderPath})
+	log.Debug("attempting to list jobs")
+	resp, err := j.getJsonTree(folderPath, "jobs[_class,name,url,jobs[name]]")
+	if err != nil {
+		log.With(logging.Fields{"error": err}).Error("failed to list jobs")
+		return nil, err
+	}
+	var folder struct {
+		Jobs []*Job
+	}
+	err = json.Unmarshal(resp, &folder)
+	if err != nil {
+		err = newJenkinsError(string(resp), err)
+		log.With(logging.Fields{"error": err}).Error("failed to parse jobs")
+		return nil, err
+	}
+	return folder.Jobs, nil
+}
+
+// DiscoverJobs returns the paths of the jobs in a folder and all of its
+// subfolders, eg. /job/folder/job/name, leaving out the folders themselves.
+func (j *JenkinsAPIClient) DiscoverJobs(folderPath string) ([]string, error) {
+	jobs, err := j.GetJobs(folderPath)
+	if err != nil {
+		return nil, err
 	}
-	for _, artifact := range build.Artifacts {
-		url := build.Url + "artifact/" + artifact.RelativePath
-		artifactUrls = append(artifactUrls, url)
+	paths := []string{}
+	for _, job := range jobs {
+		jobPath := strings.TrimRight(folderPath, "/") + "/job/" + url.PathEscape(job.Name)
+		if job.Jobs == nil {
+			paths = append(paths, jobPath)
+			continue
+		}
+		subPaths, err := j.DiscoverJobs(jobPath)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, subPaths...)
 	}
-	return artifactUrls, nil
+	return paths, nil
 }
@@ -0,0 +1,15 @@
+package jenkins
+
+// Fingerprint is the MD5 checksum Jenkins recorded for a file, eg. an archived
+// artifact, and the build which first produced the file.
+type Fingerprint struct {
+	FileName string
+	Hash     string
+	Original *FingerprintOwner
+}
+
+// FingerprintOwner is the build a fingerprinted file was first seen in.
+type FingerprintOwner struct {
+	Name   string
+	Number int32
+}
@@ -0,0 +1,11 @@
+package jenkins
+
+// HealthReport is one measure of the health of a job, eg. its build stability
+// or test results.
+type HealthReport struct {
+	Description   string
+	IconClassName string
+	IconUrl       string
+	// Score is from 0 to 100, the healthiest
+	Score int
+}
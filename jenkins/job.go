@@ -15,15 +15,17 @@ type Job struct {
 	InQueue               bool
 	KeepDependencies      bool
 	LastBuild             *Build
-	LastCompleteBuild     *Build
+	LastCompleteBuild     *Build `json:"lastCompletedBuild"`
+	LastStableBuild       *Build
 	LastSuccessfulBuild   *Build
 	LastUnstableBuild     *Build
 	LastUnsuccessfulBuild *Build
 	NextBuildNumber       int32
 	ConcurrentBuild       bool
-	// TODO: make proper structs for deserializing these
-	Actions      []interface{}
-	HealthReport []interface{}
-	Property     []interface{}
-	QueueItem    interface{}
+	// Jobs is only set for folders, which contain other jobs
+	Jobs         []*Job
+	Actions      []*Action
+	HealthReport []*HealthReport
+	Property     []*JobProperty
+	QueueItem    *QueueItem
 }
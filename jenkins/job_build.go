@@ -1,15 +1,19 @@
 package jenkins
 
+import (
+	"fmt"
+)
+
 type JobBuild struct {
 	Class             string `json:"_class"`
-	Actions           []interface{}
+	Actions           []*Action
 	Artifacts         []*Artifact
 	Building          bool
 	Description       string
 	DisplayName       string
 	Duration          int64
 	EstimatedDuration int64
-	Executor          interface{}
+	Executor          *Executor
 	FullDisplayName   string
 	Id                string
 	KeepLog           bool
@@ -18,7 +22,152 @@ type JobBuild struct {
 	Result            string
 	Timestamp         int64
 	Url               string
-	ChangeSets        []interface{}
-	NextBuild         *Build
-	PreviousBuild     *Build
+	// ChangeSets are set for pipeline builds, and ChangeSet for others
+	ChangeSets    []*ChangeSet
+	ChangeSet     *ChangeSet
+	NextBuild     *Build
+	PreviousBuild *Build
+	// Fingerprint is only filled in by GetFingerprints, since Jenkins leaves
+	// it out unless it's asked for
+	Fingerprint []*Fingerprint
+}
+
+// Promotions returns the names of the promotions of the build, from the action
+// added by the Promoted Builds plugin.
+func (b *JobBuild) Promotions() []string {
+	names := []string{}
+	for _, action := range b.Actions {
+		if action == nil {
+			continue
+		}
+		for _, promotion := range action.Promotions {
+			names = append(names, promotion.Name)
+		}
+	}
+	return names
+}
+
+// Parameters returns the values the build was run with for its parameters, by
+// their names.
+func (b *JobBuild) Parameters() map[string]string {
+	params := map[string]string{}
+	for _, action := range b.Actions {
+		if action == nil {
+			continue
+		}
+		for _, p := range action.Parameters {
+			params[p.Name] = p.String()
+		}
+	}
+	return params
+}
+
+// ArtifactUrls returns the URLs to download the artifacts of the build from.
+func (b *JobBuild) ArtifactUrls() []string {
+	urls := []string{}
+	for _, artifact := range b.Artifacts {
+		urls = append(urls, b.ArtifactUrl(artifact))
+	}
+	return urls
+}
+
+// ArtifactUrl returns the URL to download one of the artifacts of the build
+// from.
+func (b *JobBuild) ArtifactUrl(artifact *Artifact) string {
+	return b.Url + "artifact/" + artifact.RelativePath
+}
+
+// ArtifactFingerprint returns the MD5 hash Jenkins fingerprinted the artifact
+// with, or an empty string if it wasn't fingerprinted.
+func (b *JobBuild) ArtifactFingerprint(artifact *Artifact) string {
+	for _, f := range b.Fingerprint {
+		if f.FileName == artifact.RelativePath {
+			return f.Hash
+		}
+	}
+	// older versions of Jenkins record just the file name, which is only
+	// trusted if no other fingerprint of the build has it
+	hash := ""
+	for _, f := range b.Fingerprint {
+		if f.FileName != artifact.FileName {
+			continue
+		}
+		if hash != "" {
+			return ""
+		}
+		hash = f.Hash
+	}
+	return hash
+}
+
+// Causes returns why the build was started.
+func (b *JobBuild) Causes() []*Cause {
+	causes := []*Cause{}
+	for _, action := range b.Actions {
+		if action != nil {
+			causes = append(causes, action.Causes...)
+		}
+	}
+	return causes
+}
+
+// StartedBy returns who or what started the build: the name of a user, or the
+// upstream build, or an empty string if it was neither, eg. a timer.
+func (b *JobBuild) StartedBy() string {
+	for _, cause := range b.Causes() {
+		switch {
+		case cause.UserName != "":
+			return cause.UserName
+		case cause.UserId != "":
+			return cause.UserId
+		case cause.UpstreamProject != "":
+			return fmt.Sprintf("%s #%d", cause.UpstreamProject, cause.UpstreamBuild)
+		}
+	}
+	return ""
+}
+
+// Revision returns the commit the build was built from, as recorded by the git
+// plugin, or nil if it wasn't built from git. If the build checked out several
+// repos, eg. for pipeline libraries, the first is the one returned.
+func (b *JobBuild) Revision() *Revision {
+	for _, action := range b.Actions {
+		if action != nil && action.LastBuiltRevision != nil {
+			return action.LastBuiltRevision
+		}
+	}
+	return nil
+}
+
+// TestResult returns a summary of the tests run by the build, or nil if it
+// didn't record any.
+func (b *JobBuild) TestResult() *TestResult {
+	var result *TestResult
+	for _, action := range b.Actions {
+		if action == nil || action.TotalCount == 0 {
+			continue
+		}
+		if result == nil {
+			result = &TestResult{}
+		}
+		result.Failed += action.FailCount
+		result.Skipped += action.SkipCount
+		result.Total += action.TotalCount
+	}
+	return result
+}
+
+// Changes returns the changes since the previous build, in every repo.
+func (b *JobBuild) Changes() []*ChangeSetItem {
+	items := []*ChangeSetItem{}
+	changeSets := b.ChangeSets
+	if b.ChangeSet != nil {
+		changeSets = append(changeSets, b.ChangeSet)
+	}
+	for _, changeSet := range changeSets {
+		if changeSet != nil {
+			items = append(items, changeSet.Items...)
+		}
+	}
+	return items
 }
@@ -0,0 +1,160 @@
+package jenkins
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+// buildJson is the JSON Jenkins returns for a pipeline build, trimmed to the
+// actions and properties jenkronize uses. Actions Jenkins has nothing to say
+// about are empty objects.
+const buildJson = `{
+  "_class": "org.jenkinsci.plugins.workflow.job.WorkflowRun",
+  "actions": [
+    {
+      "_class": "hudson.model.ParametersAction",
+      "parameters": [
+        {"_class": "hudson.model.StringParameterValue", "name": "TARGET", "value": "linux"},
+        {"_class": "hudson.model.BooleanParameterValue", "name": "RELEASE", "value": true},
+        {"_class": "hudson.model.StringParameterValue", "name": "RETRIES", "value": 3},
+        {"_class": "hudson.model.FileParameterValue", "name": "INPUT"}
+      ]
+    },
+    {
+      "_class": "hudson.model.CauseAction",
+      "causes": [
+        {
+          "_class": "hudson.model.Cause$UpstreamCause",
+          "shortDescription": "Started by upstream project \"nightly\" build number 7",
+          "upstreamBuild": 7,
+          "upstreamProject": "nightly",
+          "upstreamUrl": "job/nightly/"
+        }
+      ]
+    },
+    {},
+    {
+      "_class": "hudson.plugins.git.util.BuildData",
+      "lastBuiltRevision": {
+        "SHA1": "0123456789abcdef0123456789abcdef01234567",
+        "branch": [{"SHA1": "0123456789abcdef0123456789abcdef01234567", "name": "refs/remotes/origin/master"}]
+      },
+      "remoteUrls": ["https://git.example.org/app.git"]
+    },
+    {"_class": "hudson.tasks.junit.TestResultAction", "failCount": 2, "skipCount": 1, "totalCount": 120},
+    {"_class": "hudson.tasks.junit.TestResultAction", "failCount": 0, "skipCount": 0, "totalCount": 30},
+    {"_class": "hudson.plugins.promoted_builds.PromotedBuildAction", "promotions": [{"name": "qa"}]}
+  ],
+  "artifacts": [{"displayPath": "app.zip", "fileName": "app.zip", "relativePath": "dist/app.zip"}],
+  "building": false,
+  "number": 42,
+  "result": "SUCCESS",
+  "url": "https://jenkins.example.org/job/app/42/",
+  "changeSets": [
+    {
+      "_class": "hudson.plugins.git.GitChangeSetList",
+      "kind": "git",
+      "items": [
+        {
+          "_class": "hudson.plugins.git.GitChangeSet",
+          "commitId": "0123456789abcdef0123456789abcdef01234567",
+          "msg": "Fix the build",
+          "author": {"absoluteUrl": "https://jenkins.example.org/user/jane", "fullName": "Jane"},
+          "authorEmail": "jane@example.org",
+          "affectedPaths": ["Makefile"]
+        }
+      ]
+    }
+  ]
+}`
+
+func TestDecodeBuild(t *testing.T) {
+	build := &JobBuild{}
+	if err := json.Unmarshal([]byte(buildJson), build); err != nil {
+		t.Fatal(err)
+	}
+	params := build.Parameters()
+	expected := map[string]string{"TARGET": "linux", "RELEASE": "true", "RETRIES": "3", "INPUT": ""}
+	if fmt.Sprint(params) != fmt.Sprint(expected) {
+		t.Errorf("the parameters are %v", params)
+	}
+	if startedBy := build.StartedBy(); startedBy != "nightly #7" {
+		t.Errorf("started by %q", startedBy)
+	}
+	revision := build.Revision()
+	if revision == nil || revision.SHA1 != "0123456789abcdef0123456789abcdef01234567" || revision.BranchName() != "origin/master" {
+		t.Errorf("the revision is %+v", revision)
+	}
+	if tests := build.TestResult(); tests == nil || tests.String() != "150 tests, 2 failed, 1 skipped" {
+		t.Errorf("the test results are %v", tests)
+	}
+	if promotions := build.Promotions(); fmt.Sprint(promotions) != "[qa]" {
+		t.Errorf("the promotions are %v", promotions)
+	}
+	changes := build.Changes()
+	if len(changes) != 1 || changes[0].Msg != "Fix the build" || changes[0].Author == nil || changes[0].Author.FullName != "Jane" {
+		t.Errorf("the changes are %+v", changes)
+	}
+	if url := build.ArtifactUrl(build.Artifacts[0]); url != "https://jenkins.example.org/job/app/42/artifact/dist/app.zip" {
+		t.Errorf("the artifact URL is %s", url)
+	}
+}
+
+func TestStartedBy(t *testing.T) {
+	tests := []struct {
+		causes    string
+		startedBy string
+	}{
+		{`{"_class": "hudson.model.Cause$UserIdCause", "userId": "jane", "userName": "Jane"}`, "Jane"},
+		{`{"_class": "hudson.model.Cause$UserIdCause", "userId": "jane"}`, "jane"},
+		{`{"_class": "hudson.triggers.TimerTrigger$TimerTriggerCause", "shortDescription": "Started by timer"}`, ""},
+	}
+	for _, test := range tests {
+		build := &JobBuild{}
+		data := `{"actions": [{"_class": "hudson.model.CauseAction", "causes": [` + test.causes + `]}]}`
+		if err := json.Unmarshal([]byte(data), build); err != nil {
+			t.Fatal(err)
+		}
+		if startedBy := build.StartedBy(); startedBy != test.startedBy {
+			t.Errorf("%s started by %q, expected %q", test.causes, startedBy, test.startedBy)
+		}
+	}
+	// a build without any recorded actions
+	build := &JobBuild{}
+	if build.StartedBy() != "" || build.Revision() != nil || build.TestResult() != nil || len(build.Parameters()) != 0 {
+		t.Error("a build without actions has details")
+	}
+}
+
+func TestArtifactFingerprint(t *testing.T) {
+	build := &JobBuild{
+		Fingerprint: []*Fingerprint{
+			{FileName: "dist/app.zip", Hash: "aaaa"},
+			// older versions of Jenkins only record the file name
+			{FileName: "setup.exe", Hash: "bbbb"},
+			{FileName: "README.txt", Hash: "cccc"},
+			{FileName: "README.txt", Hash: "dddd"},
+		},
+	}
+	tests := []struct {
+		artifact *Artifact
+		hash     string
+	}{
+		{&Artifact{FileName: "app.zip", RelativePath: "dist/app.zip"}, "aaaa"},
+		{&Artifact{FileName: "setup.exe", RelativePath: "windows/setup.exe"}, "bbbb"},
+		// two fingerprints have the file name, so neither can be trusted
+		{&Artifact{FileName: "README.txt", RelativePath: "docs/README.txt"}, ""},
+		// the full path is matched first
+		{&Artifact{FileName: "app.zip", RelativePath: "app.zip"}, ""},
+		{&Artifact{FileName: "other.zip", RelativePath: "dist/other.zip"}, ""},
+	}
+	for _, test := range tests {
+		if hash := build.ArtifactFingerprint(test.artifact); hash != test.hash {
+			t.Errorf("the fingerprint of %s is %q, expected %q", test.artifact.RelativePath, hash, test.hash)
+		}
+	}
+	if hash := (&JobBuild{}).ArtifactFingerprint(&Artifact{FileName: "a", RelativePath: "a"}); hash != "" {
+		t.Errorf("an unfingerprinted build has the fingerprint %q", hash)
+	}
+}
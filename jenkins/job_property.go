@@ -0,0 +1,17 @@
+package jenkins
+
+// JobProperty is one of the properties of a job. Like actions, only the fields
+// of the kinds of property that are used are decoded.
+type JobProperty struct {
+	Class string `json:"_class"`
+	// ParameterDefinitions are set by a ParametersDefinitionProperty
+	ParameterDefinitions []*ParameterDefinition
+}
+
+// ParameterDefinition is a parameter the builds of a job take.
+type ParameterDefinition struct {
+	Name                  string
+	Type                  string
+	Description           string
+	DefaultParameterValue *Parameter
+}
@@ -0,0 +1,24 @@
+package jenkins
+
+// QueueItem is a build of a job waiting to be run.
+type QueueItem struct {
+	Class        string `json:"_class"`
+	Id           int32
+	Blocked      bool
+	Buildable    bool
+	Stuck        bool
+	InQueueSince int64
+	// Why is the reason the build is still waiting
+	Why string
+}
+
+// Executor is what's running a build.
+type Executor struct {
+	Class       string `json:"_class"`
+	Number      int
+	Idle        bool
+	LikelyStuck bool
+	// Progress is the estimated percentage of the build done, or -1 if it
+	// can't be estimated
+	Progress int
+}
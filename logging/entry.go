@@ -0,0 +1,95 @@
+package logging
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Fields are structured values attached to a log entry. The conventional keys
+// are job, job_path, build, artifact_url, bytes and error.
+type Fields map[string]interface{}
+
+// Entry is a log message in the making, carrying the fields it will be logged
+// with. In the text format the fields are appended to the message as
+// key=value pairs; in the JSON format each becomes a property of the entry.
+type Entry struct {
+	logger *Logger
+	fields Fields
+}
+
+// With returns an entry which logs with the given fields.
+func (l *Logger) With(fields Fields) *Entry {
+	return &Entry{logger: l, fields: fields}
+}
+
+// With returns a new entry with the given fields added to those of e.
+func (e *Entry) With(fields Fields) *Entry {
+	merged := Fields{}
+	for k, v := range e.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &Entry{logger: e.logger, fields: merged}
+}
+
+func (e *Entry) Trace(msg string) { e.log(LevelTrace, msg) }
+func (e *Entry) Debug(msg string) { e.log(LevelDebug, msg) }
+func (e *Entry) Info(msg string)  { e.log(LevelInfo, msg) }
+func (e *Entry) Warn(msg string)  { e.log(LevelWarn, msg) }
+func (e *Entry) Error(msg string) { e.log(LevelError, msg) }
+
+func (e *Entry) Tracef(format string, v ...interface{}) { e.log(LevelTrace, fmt.Sprintf(format, v...)) }
+func (e *Entry) Debugf(format string, v ...interface{}) { e.log(LevelDebug, fmt.Sprintf(format, v...)) }
+func (e *Entry) Infof(format string, v ...interface{})  { e.log(LevelInfo, fmt.Sprintf(format, v...)) }
+func (e *Entry) Warnf(format string, v ...interface{})  { e.log(LevelWarn, fmt.Sprintf(format, v...)) }
+func (e *Entry) Errorf(format string, v ...interface{}) { e.log(LevelError, fmt.Sprintf(format, v...)) }
+
+// log must only be called directly by the exported methods above, as it
+// relies on the depth of the call stack to find the caller.
+func (e *Entry) log(level Level, msg string) {
+	l := e.logger
+	if level < l.level {
+		return
+	}
+	if l.format == FormatJson {
+		caller := ""
+		if _, file, line, ok := runtime.Caller(2); ok {
+			caller = fmt.Sprintf("%s:%d", shortFile(file), line)
+		}
+		writeJson(l.writers()[level], time.Now(), level, caller, msg, e.fields)
+		return
+	}
+	if text := formatFields(e.fields); text != "" {
+		msg += " " + text
+	}
+	l.loggers()[level].Output(3, msg)
+}
+
+func shortFile(file string) string {
+	if i := strings.LastIndex(file, "/"); i >= 0 {
+		return file[i+1:]
+	}
+	return file
+}
+
+func formatFields(fields Fields) string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		value := fmt.Sprintf("%v", fields[k])
+		if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+			value = fmt.Sprintf("%q", value)
+		}
+		pairs = append(pairs, k+"="+value)
+	}
+	return strings.Join(pairs, " ")
+}
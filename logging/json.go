@@ -0,0 +1,94 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+type Format int
+
+const (
+	FormatText Format = iota
+	FormatJson
+)
+
+// ParseFormat converts a log format name as used in configuration into a
+// Format.
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "text":
+		return FormatText, nil
+	case "json":
+		return FormatJson, nil
+	}
+	return FormatText, fmt.Errorf("unknown log format %q; expected text or json", name)
+}
+
+// jsonLineWriter turns the lines written by a log.Logger created with only
+// the Lshortfile flag into JSON entries, so that code using the plain
+// Trace/Info/... loggers produces JSON too.
+type jsonLineWriter struct {
+	level Level
+	out   io.Writer
+}
+
+func (w *jsonLineWriter) Write(p []byte) (int, error) {
+	line := strings.TrimRight(string(p), "\n")
+	caller := ""
+	// lines look like `file.go:12: message`
+	if i := strings.Index(line, ": "); i >= 0 && strings.Contains(line[:i], ".go:") {
+		caller = line[:i]
+		line = line[i+2:]
+	}
+	if err := writeJson(w.out, time.Now(), w.level, caller, line, nil); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeJson writes one entry as a single line of JSON, with the standard
+// properties first and any fields after them in alphabetical order.
+func writeJson(out io.Writer, t time.Time, level Level, caller string, msg string, fields Fields) error {
+	var buf bytes.Buffer
+	buf.WriteString("{")
+	writeProperty(&buf, "time", t.UTC().Format(time.RFC3339Nano), false)
+	writeProperty(&buf, "level", level.String(), true)
+	if caller != "" {
+		writeProperty(&buf, "caller", caller, true)
+	}
+	writeProperty(&buf, "msg", msg, true)
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		writeProperty(&buf, k, fields[k], true)
+	}
+	buf.WriteString("}\n")
+	_, err := out.Write(buf.Bytes())
+	return err
+}
+
+func writeProperty(buf *bytes.Buffer, key string, value interface{}, comma bool) {
+	if comma {
+		buf.WriteString(",")
+	}
+	if err, ok := value.(error); ok {
+		// errors don't marshal to anything useful on their own
+		value = err.Error()
+	}
+	keyBytes, _ := json.Marshal(key)
+	valueBytes, err := json.Marshal(value)
+	if err != nil {
+		valueBytes, _ = json.Marshal(err.Error())
+	}
+	buf.Write(keyBytes)
+	buf.WriteString(":")
+	buf.Write(valueBytes)
+}
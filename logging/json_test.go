@@ -0,0 +1,93 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFormat(t *testing.T) {
+	for name, expected := range map[string]Format{"": FormatText, "text": FormatText, "JSON": FormatJson} {
+		if format, err := ParseFormat(name); err != nil || format != expected {
+			t.Errorf("ParseFormat(%q) returned %v, %v", name, format, err)
+		}
+	}
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Error("ParseFormat accepted xml")
+	}
+}
+
+func TestJsonEntry(t *testing.T) {
+	var buf bytes.Buffer
+	l := newTestLogger(&buf, LevelInfo, FormatJson)
+	l.With(Fields{"job": "foo", "build": 42, "error": fmt.Errorf("no space left")}).Warn("download failed")
+	line := buf.String()
+	if !strings.HasSuffix(line, "\n") || strings.Count(line, "\n") != 1 {
+		t.Fatalf("the entry isn't a single line: %q", line)
+	}
+	// the standard properties come first, and the fields in alphabetical order
+	order := []string{`"time":`, `"level":`, `"caller":`, `"msg":`, `"build":`, `"error":`, `"job":`}
+	last := -1
+	for _, key := range order {
+		i := strings.Index(line, key)
+		if i <= last {
+			t.Errorf("%s is out of order in %s", key, line)
+		}
+		last = i
+	}
+
+	entry := map[string]interface{}{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, fmt.Sprint(entry["time"])); err != nil {
+		t.Errorf("the time isn't RFC 3339: %v", err)
+	}
+	expected := map[string]interface{}{
+		"level": "warn",
+		"msg":   "download failed",
+		"job":   "foo",
+		"build": float64(42),
+		"error": "no space left",
+	}
+	for key, value := range expected {
+		if entry[key] != value {
+			t.Errorf("%s is %v, expected %v", key, entry[key], value)
+		}
+	}
+	if caller := fmt.Sprint(entry["caller"]); !strings.HasPrefix(caller, "json_test.go:") {
+		t.Errorf("the caller is %q", caller)
+	}
+}
+
+func TestJsonPlainLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := newTestLogger(&buf, LevelInfo, FormatJson)
+	l.Flags = log.Ldate | log.Ltime | log.Lshortfile
+	l.Init()
+	l.Info.Printf("synced %d builds", 3)
+	l.Debug.Print("hidden")
+	entry := map[string]interface{}{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("%q isn't a JSON entry: %v", buf.String(), err)
+	}
+	if entry["level"] != "info" || entry["msg"] != "synced 3 builds" {
+		t.Errorf("logged %v", entry)
+	}
+	if caller := fmt.Sprint(entry["caller"]); !strings.HasPrefix(caller, "json_test.go:") {
+		t.Errorf("the caller is %q", caller)
+	}
+}
+
+func TestTextEntry(t *testing.T) {
+	var buf bytes.Buffer
+	l := newTestLogger(&buf, LevelInfo, FormatText)
+	l.With(Fields{"job": "my job", "build": 42, "empty": ""}).With(Fields{"path": "/opt/sync"}).Info("synced")
+	if expected := "INFO:  synced build=42 empty=\"\" job=\"my job\" path=/opt/sync\n"; buf.String() != expected {
+		t.Errorf("logged %q, expected %q", buf.String(), expected)
+	}
+}
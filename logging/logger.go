@@ -1,22 +1,70 @@
 package logging
 
 import (
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"strings"
+	"sync"
 )
 
+type Level int
+
+const (
+	LevelTrace Level = iota
+	LevelDebug
+	LevelInfo
+	LevelWarn
+	LevelError
+	LevelFatal
+)
+
+var levelNames = []string{"trace", "debug", "info", "warn", "error", "fatal"}
+
+func (l Level) String() string {
+	if l < LevelTrace || l > LevelFatal {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel converts a level name as used in configuration into a Level.
+func ParseLevel(name string) (Level, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "warning" {
+		return LevelWarn, nil
+	}
+	for i, n := range levelNames {
+		if n == name {
+			return Level(i), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q; expected one of %s", name, strings.Join(levelNames, ", "))
+}
+
 var logger *Logger
 
+// packageLoggers holds the loggers handed out by GetPackageLogger, so level
+// and output changes can be applied to all of them.
+var packageLoggers = map[string]*Logger{}
+var registryMux sync.Mutex
+
 type Logger struct {
 	Flags       int
 	Trace       *log.Logger
+	Debug       *log.Logger
 	Info        *log.Logger
 	Warn        *log.Logger
 	Error       *log.Logger
 	Fatal       *log.Logger
+	name        string
+	level       Level
+	levelSet    bool
+	format      Format
 	traceWriter io.Writer
+	debugWriter io.Writer
 	infoWriter  io.Writer
 	warnWriter  io.Writer
 	errorWriter io.Writer
@@ -24,45 +72,166 @@ type Logger struct {
 }
 
 func (l *Logger) Init() *Logger {
-	l.Trace = log.New(l.traceWriter, "TRACE:  ", l.Flags)
-	l.Info = log.New(l.infoWriter, "INFO:  ", l.Flags)
-	l.Warn = log.New(l.warnWriter, "WARN:  ", l.Flags)
-	l.Error = log.New(l.errorWriter, "ERROR: ", l.Flags)
-	l.Fatal = log.New(l.fatalWriter, "FATAL: ", l.Flags)
+	l.Trace = l.newLogger(LevelTrace, l.traceWriter, "TRACE:  ")
+	l.Debug = l.newLogger(LevelDebug, l.debugWriter, "DEBUG:  ")
+	l.Info = l.newLogger(LevelInfo, l.infoWriter, "INFO:  ")
+	l.Warn = l.newLogger(LevelWarn, l.warnWriter, "WARN:  ")
+	l.Error = l.newLogger(LevelError, l.errorWriter, "ERROR: ")
+	l.Fatal = l.newLogger(LevelFatal, l.fatalWriter, "FATAL: ")
 	return l
 }
 
-func (l *Logger) AddLogFile(logfile string) *Logger {
-	f, err := os.OpenFile(logfile, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
-	if err != nil {
-		l.Fatal.Fatal(err)
+func (l *Logger) newLogger(level Level, w io.Writer, prefix string) *log.Logger {
+	// fatal messages are always logged, since they're followed by an exit
+	if level < l.level && level != LevelFatal {
+		w = ioutil.Discard
+	}
+	if l.format == FormatJson && w != ioutil.Discard {
+		return log.New(&jsonLineWriter{level: level, out: w}, "", l.Flags&log.Lshortfile)
 	}
-	writers := []io.Writer{
+	return log.New(w, prefix, l.Flags)
+}
+
+func (l *Logger) writers() []io.Writer {
+	return []io.Writer{
 		l.traceWriter,
+		l.debugWriter,
 		l.infoWriter,
 		l.warnWriter,
 		l.errorWriter,
 		l.fatalWriter,
 	}
-	for i, w := range writers {
-		if w != ioutil.Discard {
-			writers[i] = io.MultiWriter(w, f)
-		}
+}
+
+func (l *Logger) loggers() []*log.Logger {
+	return []*log.Logger{
+		l.Trace,
+		l.Debug,
+		l.Info,
+		l.Warn,
+		l.Error,
+		l.Fatal,
 	}
-	l.traceWriter = writers[0]
-	l.infoWriter = writers[1]
-	l.warnWriter = writers[2]
-	l.errorWriter = writers[3]
-	l.fatalWriter = writers[4]
+}
+
+// Level returns the lowest level this logger writes.
+func (l *Logger) Level() Level {
+	return l.level
+}
+
+// SetLevel sets the lowest level written. Set on the root logger returned by
+// GetLogger, it also applies to every package logger without a level of its
+// own.
+func (l *Logger) SetLevel(level Level) *Logger {
+	registryMux.Lock()
+	defer registryMux.Unlock()
+	l.level = level
 	l.Init()
+	if l == logger {
+		for _, p := range packageLoggers {
+			if !p.levelSet {
+				p.level = level
+				p.Init()
+			}
+		}
+	} else {
+		l.levelSet = true
+	}
+	return l
+}
+
+// SetFormat switches every logger between the text and JSON formats.
+func (l *Logger) SetFormat(format Format) *Logger {
+	registryMux.Lock()
+	defer registryMux.Unlock()
+	logger.format = format
+	logger.Init()
+	for _, p := range packageLoggers {
+		p.format = format
+		p.Init()
+	}
 	return l
 }
 
+// SetPackageLevel overrides the level of the logger for the named package.
+func (l *Logger) SetPackageLevel(pkg string, level Level) *Logger {
+	GetPackageLogger(pkg).SetLevel(level)
+	return l
+}
+
+func (l *Logger) AddLogFile(logfile string) *Logger {
+	f, err := NewRotatingFile(logfile)
+	if err != nil {
+		l.Fatal.Fatal(err)
+	}
+	return l.AddWriter(f)
+}
+
+// AddWriter sends the output of every logger to w, in addition to wherever it
+// already goes.
+func (l *Logger) AddWriter(w io.Writer) *Logger {
+	return l.addWriters(func(Level) io.Writer { return w })
+}
+
+// LevelWriter is an output which needs to know the level of what it's
+// writing, such as syslog, where it determines the priority.
+type LevelWriter interface {
+	WriteLevel(level Level, p []byte) (int, error)
+}
+
+type levelWriter struct {
+	level Level
+	out   LevelWriter
+}
+
+func (w *levelWriter) Write(p []byte) (int, error) {
+	return w.out.WriteLevel(w.level, p)
+}
+
+// AddLevelWriter sends the output of every logger to w, in addition to
+// wherever it already goes.
+func (l *Logger) AddLevelWriter(w LevelWriter) *Logger {
+	return l.addWriters(func(level Level) io.Writer {
+		return &levelWriter{level: level, out: w}
+	})
+}
+
+func (l *Logger) addWriters(writerFor func(Level) io.Writer) *Logger {
+	registryMux.Lock()
+	defer registryMux.Unlock()
+	// outputs are shared by every logger, so they're added to all of them
+	all := []*Logger{logger}
+	for _, p := range packageLoggers {
+		all = append(all, p)
+	}
+	for _, target := range all {
+		target.addWriter(writerFor)
+	}
+	return l
+}
+
+func (l *Logger) addWriter(writerFor func(Level) io.Writer) {
+	writers := []*io.Writer{
+		&l.traceWriter,
+		&l.debugWriter,
+		&l.infoWriter,
+		&l.warnWriter,
+		&l.errorWriter,
+		&l.fatalWriter,
+	}
+	for level, w := range writers {
+		*w = io.MultiWriter(*w, writerFor(Level(level)))
+	}
+	l.Init()
+}
+
 func GetLogger() *Logger {
 	if logger == nil {
 		logger = &Logger{
 			Flags:       log.Ldate | log.Ltime | log.Lshortfile,
-			traceWriter: ioutil.Discard,
+			level:       LevelInfo,
+			traceWriter: os.Stdout,
+			debugWriter: os.Stdout,
 			infoWriter:  os.Stdout,
 			warnWriter:  os.Stdout,
 			errorWriter: os.Stderr,
@@ -72,3 +241,30 @@ func GetLogger() *Logger {
 	}
 	return logger
 }
+
+// GetPackageLogger returns the logger for the named package. It writes to the
+// same outputs as the root logger, and follows its level unless overridden
+// with SetPackageLevel.
+func GetPackageLogger(pkg string) *Logger {
+	root := GetLogger()
+	registryMux.Lock()
+	defer registryMux.Unlock()
+	if l, ok := packageLoggers[pkg]; ok {
+		return l
+	}
+	l := &Logger{
+		Flags:       root.Flags,
+		name:        pkg,
+		level:       root.level,
+		format:      root.format,
+		traceWriter: root.traceWriter,
+		debugWriter: root.debugWriter,
+		infoWriter:  root.infoWriter,
+		warnWriter:  root.warnWriter,
+		errorWriter: root.errorWriter,
+		fatalWriter: root.fatalWriter,
+	}
+	l.Init()
+	packageLoggers[pkg] = l
+	return l
+}
@@ -0,0 +1,89 @@
+package logging
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		name  string
+		level Level
+		valid bool
+	}{
+		{"trace", LevelTrace, true},
+		{"DEBUG", LevelDebug, true},
+		{" info ", LevelInfo, true},
+		{"warn", LevelWarn, true},
+		{"warning", LevelWarn, true},
+		{"error", LevelError, true},
+		{"fatal", LevelFatal, true},
+		{"verbose", LevelInfo, false},
+	}
+	for _, test := range tests {
+		level, err := ParseLevel(test.name)
+		if (err == nil) != test.valid || level != test.level {
+			t.Errorf("ParseLevel(%q) returned %s, %v", test.name, level, err)
+		}
+	}
+}
+
+// newTestLogger returns a logger which isn't registered with the package,
+// writing every level to buf without timestamps.
+func newTestLogger(buf *bytes.Buffer, level Level, format Format) *Logger {
+	l := &Logger{
+		level:       level,
+		format:      format,
+		traceWriter: buf,
+		debugWriter: buf,
+		infoWriter:  buf,
+		warnWriter:  buf,
+		errorWriter: buf,
+		fatalWriter: buf,
+	}
+	return l.Init()
+}
+
+func TestLoggerLevel(t *testing.T) {
+	var buf bytes.Buffer
+	l := newTestLogger(&buf, LevelWarn, FormatText)
+	l.Debug.Print("debug")
+	l.Info.Print("info")
+	l.With(Fields{"job": "foo"}).Info("info entry")
+	l.Warn.Print("warn")
+	l.With(Fields{"job": "foo"}).Error("error entry")
+	if expected := "WARN:  warn\nERROR: error entry job=foo\n"; buf.String() != expected {
+		t.Errorf("logged %q, expected %q", buf.String(), expected)
+	}
+}
+
+func TestPackageLevels(t *testing.T) {
+	root := GetLogger()
+	defer root.SetLevel(root.Level())
+	overridden := GetPackageLogger("test-overridden")
+	following := GetPackageLogger("test-following")
+
+	root.SetPackageLevel("test-overridden", LevelDebug)
+	root.SetLevel(LevelError)
+	if following.Level() != LevelError {
+		t.Errorf("the package logger without an override is at %s", following.Level())
+	}
+	if overridden.Level() != LevelDebug {
+		t.Errorf("the overridden package logger is at %s", overridden.Level())
+	}
+	if overridden.Debug.Writer() == ioutil.Discard {
+		t.Error("the overridden package logger discards debug messages")
+	}
+	if following.Warn.Writer() != ioutil.Discard {
+		t.Error("the package logger without an override writes warnings")
+	}
+	// a package logger created later follows the root logger too
+	if level := GetPackageLogger("test-later").Level(); level != LevelError {
+		t.Errorf("a new package logger is at %s", level)
+	}
+	// fatal messages are always written
+	if following.Fatal.Writer() == ioutil.Discard {
+		t.Error("fatal messages are discarded")
+	}
+}
@@ -0,0 +1,214 @@
+package logging
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// reopenable holds every RotatingFile in use, so they can all be reopened
+// after an external tool such as logrotate has moved them.
+var reopenable = []*RotatingFile{}
+var reopenMux sync.Mutex
+
+// RotatingFile is a log file which rotates itself once it grows past a
+// maximum size or age. Rotated files are renamed with a numeric suffix
+// (log.txt.1 being the most recent), optionally gzipped, and only the
+// configured number of them are kept.
+type RotatingFile struct {
+	path     string
+	maxSize  int64
+	maxAge   time.Duration
+	keep     int
+	compress bool
+	file     *os.File
+	size     int64
+	opened   time.Time
+	mux      sync.Mutex
+}
+
+// NewRotatingFile opens (or creates) the log file at path for appending.
+// Without further configuration it never rotates, but can still be reopened.
+func NewRotatingFile(path string) (*RotatingFile, error) {
+	r := &RotatingFile{path: path, keep: 5}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	reopenMux.Lock()
+	reopenable = append(reopenable, r)
+	reopenMux.Unlock()
+	return r, nil
+}
+
+// SetMaxSize rotates the file once it would grow past size bytes; 0 disables
+// size-based rotation.
+func (r *RotatingFile) SetMaxSize(size int64) *RotatingFile {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.maxSize = size
+	return r
+}
+
+// SetMaxAge rotates the file once it has been written to for longer than age;
+// 0 disables age-based rotation.
+func (r *RotatingFile) SetMaxAge(age time.Duration) *RotatingFile {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.maxAge = age
+	return r
+}
+
+// SetRetention sets how many rotated files are kept.
+func (r *RotatingFile) SetRetention(keep int) *RotatingFile {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.keep = keep
+	return r
+}
+
+// SetCompress gzips rotated files.
+func (r *RotatingFile) SetCompress(compress bool) *RotatingFile {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.compress = compress
+	return r
+}
+
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.file = f
+	r.size = info.Size()
+	r.opened = time.Now()
+	if r.size > 0 {
+		// an existing file has been in use since at least its last write
+		r.opened = info.ModTime()
+	}
+	return nil
+}
+
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.size > 0 && r.needsRotation(int64(len(p))) {
+		if err := r.rotate(); err != nil {
+			// keep logging to the current file rather than losing messages
+			fmt.Fprintf(os.Stderr, "unable to rotate log file %s: %v\n", r.path, err)
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *RotatingFile) needsRotation(incoming int64) bool {
+	if r.maxSize > 0 && r.size+incoming > r.maxSize {
+		return true
+	}
+	if r.maxAge > 0 && time.Since(r.opened) >= r.maxAge {
+		return true
+	}
+	return false
+}
+
+// Reopen closes and reopens the file at the same path, for when it has been
+// moved away by external log rotation.
+func (r *RotatingFile) Reopen() error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	old := r.file
+	if err := r.open(); err != nil {
+		return err
+	}
+	return old.Close()
+}
+
+func (r *RotatingFile) Close() error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.file.Close()
+}
+
+func (r *RotatingFile) rotatedName(n int) string {
+	name := fmt.Sprintf("%s.%d", r.path, n)
+	if r.compress {
+		name += ".gz"
+	}
+	return name
+}
+
+// rotate must be called with the mutex held.
+func (r *RotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	err := r.shift()
+	// whatever happened, make sure there's a file to write to again
+	if openErr := r.open(); openErr != nil {
+		return openErr
+	}
+	return err
+}
+
+// shift moves the current file to the first rotated name, moving the older
+// rotated files up by one and removing the oldest.
+func (r *RotatingFile) shift() error {
+	if r.keep < 1 {
+		return os.Remove(r.path)
+	}
+	os.Remove(r.rotatedName(r.keep))
+	for n := r.keep - 1; n >= 1; n-- {
+		if _, err := os.Stat(r.rotatedName(n)); err == nil {
+			if err := os.Rename(r.rotatedName(n), r.rotatedName(n+1)); err != nil {
+				return err
+			}
+		}
+	}
+	if !r.compress {
+		return os.Rename(r.path, r.rotatedName(1))
+	}
+	if err := gzipFile(r.path, r.rotatedName(1)); err != nil {
+		return err
+	}
+	return os.Remove(r.path)
+}
+
+func gzipFile(src string, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Reopen reopens every log file, for use after external log rotation.
+func Reopen() error {
+	reopenMux.Lock()
+	defer reopenMux.Unlock()
+	for _, r := range reopenable {
+		if err := r.Reopen(); err != nil {
+			return fmt.Errorf("unable to reopen log file %s: %v", r.path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package logging
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func tempLogFile(t *testing.T) (string, string) {
+	dir, err := ioutil.TempDir("", "jenkronize-logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, filepath.Join(dir, "jenkronize.log")
+}
+
+func readLog(t *testing.T, path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestRotatingFileSize(t *testing.T) {
+	dir, path := tempLogFile(t)
+	defer os.RemoveAll(dir)
+	r, err := NewRotatingFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	r.SetMaxSize(10).SetRetention(2)
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// each line pushes the file past 10 bytes, so each is in a file of its
+	// own, and only 2 rotated files are kept
+	expected := map[string]string{path: "fourth\n", path + ".1": "third\n", path + ".2": "second\n"}
+	for file, content := range expected {
+		if actual := readLog(t, file); actual != content {
+			t.Errorf("%s has %q, expected %q", filepath.Base(file), actual, content)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("more rotated files were kept than the retention: %v", err)
+	}
+
+	// a single write larger than the maximum still goes into one file
+	if _, err := r.Write([]byte("a very long line\n")); err != nil {
+		t.Fatal(err)
+	}
+	if actual := readLog(t, path); actual != "a very long line\n" {
+		t.Errorf("the log has %q", actual)
+	}
+}
+
+func TestRotatingFileAge(t *testing.T) {
+	dir, path := tempLogFile(t)
+	defer os.RemoveAll(dir)
+	r, err := NewRotatingFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	r.SetMaxAge(10 * time.Millisecond).SetCompress(true)
+
+	r.Write([]byte("old\n"))
+	r.Write([]byte("recent\n"))
+	time.Sleep(20 * time.Millisecond)
+	r.Write([]byte("new\n"))
+	if actual := readLog(t, path); actual != "new\n" {
+		t.Errorf("the log has %q", actual)
+	}
+	// the rotated file is compressed
+	f, err := os.Open(path + ".1.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadAll(gz); err != nil || string(data) != "old\nrecent\n" {
+		t.Errorf("the rotated log has %q, %v", data, err)
+	}
+	if _, err := os.Stat(path + ".1"); !os.IsNotExist(err) {
+		t.Errorf("the uncompressed rotated log was kept: %v", err)
+	}
+}
+
+func TestRotatingFileReopen(t *testing.T) {
+	dir, path := tempLogFile(t)
+	defer os.RemoveAll(dir)
+	r, err := NewRotatingFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	r.Write([]byte("before\n"))
+	// as logrotate would
+	if err := os.Rename(path, path+".moved"); err != nil {
+		t.Fatal(err)
+	}
+	r.Write([]byte("moved\n"))
+	if err := r.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	r.Write([]byte("after\n"))
+	if actual := readLog(t, path+".moved"); actual != "before\nmoved\n" {
+		t.Errorf("the moved log has %q", actual)
+	}
+	if actual := readLog(t, path); actual != "after\n" {
+		t.Errorf("the reopened log has %q", actual)
+	}
+}
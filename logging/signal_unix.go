@@ -0,0 +1,26 @@
+//go:build !windows
+// +build !windows
+
+package logging
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// ReopenOnSignal reopens all log files whenever the process receives SIGUSR1,
+// so that external tools such as logrotate can rotate them.
+func ReopenOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	go func() {
+		for range signals {
+			if err := Reopen(); err != nil {
+				GetLogger().Error.Print(err.Error())
+				continue
+			}
+			GetLogger().Info.Print("reopened log files")
+		}
+	}()
+}
@@ -0,0 +1,49 @@
+//go:build !windows
+// +build !windows
+
+package logging
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReopenOnSignal(t *testing.T) {
+	dir, path := tempLogFile(t)
+	defer os.RemoveAll(dir)
+	// only reopen the file of this test; those of earlier tests are gone
+	reopenMux.Lock()
+	reopenable = nil
+	reopenMux.Unlock()
+	r, err := NewRotatingFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	ReopenOnSignal()
+
+	r.Write([]byte("before\n"))
+	if err := os.Rename(path, path+".moved"); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("the log file wasn't reopened after SIGUSR1")
+		}
+	}
+	r.Write([]byte("after\n"))
+	if actual := readLog(t, path); actual != "after\n" {
+		t.Errorf("the reopened log has %q", actual)
+	}
+	if actual := readLog(t, path+".moved"); actual != "before\n" {
+		t.Errorf("the moved log has %q", actual)
+	}
+}
@@ -0,0 +1,7 @@
+//go:build windows
+// +build windows
+
+package logging
+
+// ReopenOnSignal does nothing on Windows, which has no SIGUSR1.
+func ReopenOnSignal() {}
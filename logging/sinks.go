@@ -0,0 +1,14 @@
+package logging
+
+import (
+	"regexp"
+	"strings"
+)
+
+// textPrefix matches the level and timestamp at the start of a text format
+// line, which syslog and the journal record for themselves.
+var textPrefix = regexp.MustCompile(`^[A-Z]+: +\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2} `)
+
+func stripTextPrefix(line string) string {
+	return strings.TrimRight(textPrefix.ReplaceAllString(line, ""), "\n")
+}
@@ -0,0 +1,134 @@
+//go:build !windows
+// +build !windows
+
+package logging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log/syslog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+const journaldSocket = "/run/systemd/journal/socket"
+
+var syslogFacilities = map[string]syslog.Priority{
+	"kern":     syslog.LOG_KERN,
+	"user":     syslog.LOG_USER,
+	"mail":     syslog.LOG_MAIL,
+	"daemon":   syslog.LOG_DAEMON,
+	"auth":     syslog.LOG_AUTH,
+	"syslog":   syslog.LOG_SYSLOG,
+	"lpr":      syslog.LOG_LPR,
+	"news":     syslog.LOG_NEWS,
+	"uucp":     syslog.LOG_UUCP,
+	"cron":     syslog.LOG_CRON,
+	"authpriv": syslog.LOG_AUTHPRIV,
+	"ftp":      syslog.LOG_FTP,
+	"local0":   syslog.LOG_LOCAL0,
+	"local1":   syslog.LOG_LOCAL1,
+	"local2":   syslog.LOG_LOCAL2,
+	"local3":   syslog.LOG_LOCAL3,
+	"local4":   syslog.LOG_LOCAL4,
+	"local5":   syslog.LOG_LOCAL5,
+	"local6":   syslog.LOG_LOCAL6,
+	"local7":   syslog.LOG_LOCAL7,
+}
+
+type syslogWriter struct {
+	w *syslog.Writer
+}
+
+// NewSyslogWriter connects to a syslog daemon. With an empty network and
+// address it uses the local daemon's unix socket; otherwise network is udp or
+// tcp and address is the daemon's host:port.
+func NewSyslogWriter(network string, address string, tag string, facility string) (LevelWriter, error) {
+	if facility == "" {
+		facility = "daemon"
+	}
+	priority, ok := syslogFacilities[strings.ToLower(facility)]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility %q", facility)
+	}
+	w, err := syslog.Dial(network, address, priority|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogWriter{w: w}, nil
+}
+
+func (s *syslogWriter) WriteLevel(level Level, p []byte) (int, error) {
+	msg := stripTextPrefix(string(p))
+	var err error
+	switch level {
+	case LevelTrace, LevelDebug:
+		err = s.w.Debug(msg)
+	case LevelInfo:
+		err = s.w.Info(msg)
+	case LevelWarn:
+		err = s.w.Warning(msg)
+	case LevelError:
+		err = s.w.Err(msg)
+	default:
+		err = s.w.Crit(msg)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// journaldPriorities are the syslog priorities journald uses for each level.
+var journaldPriorities = map[Level]int{
+	LevelTrace: 7,
+	LevelDebug: 7,
+	LevelInfo:  6,
+	LevelWarn:  4,
+	LevelError: 3,
+	LevelFatal: 2,
+}
+
+type journaldWriter struct {
+	conn       *net.UnixConn
+	identifier string
+	mux        sync.Mutex
+}
+
+// NewJournaldWriter sends log entries to the systemd journal using its native
+// protocol, so each entry gets the right priority.
+func NewJournaldWriter(identifier string) (LevelWriter, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journaldSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &journaldWriter{conn: conn, identifier: identifier}, nil
+}
+
+func (j *journaldWriter) WriteLevel(level Level, p []byte) (int, error) {
+	var buf bytes.Buffer
+	writeJournalField(&buf, "PRIORITY", fmt.Sprintf("%d", journaldPriorities[level]))
+	writeJournalField(&buf, "SYSLOG_IDENTIFIER", j.identifier)
+	writeJournalField(&buf, "SYSLOG_PID", fmt.Sprintf("%d", os.Getpid()))
+	writeJournalField(&buf, "MESSAGE", stripTextPrefix(string(p)))
+	j.mux.Lock()
+	defer j.mux.Unlock()
+	if _, err := j.conn.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func writeJournalField(buf *bytes.Buffer, key string, value string) {
+	if !strings.Contains(value, "\n") {
+		buf.WriteString(key + "=" + value + "\n")
+		return
+	}
+	// values containing newlines are sent with an explicit length instead
+	buf.WriteString(key + "\n")
+	binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value + "\n")
+}
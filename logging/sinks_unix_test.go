@@ -0,0 +1,72 @@
+//go:build !windows
+// +build !windows
+
+package logging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStripTextPrefix(t *testing.T) {
+	tests := map[string]string{
+		"INFO:  2024/01/02 03:04:05 tracker.go:12: synced\n": "tracker.go:12: synced",
+		"ERROR: 2024/01/02 03:04:05 failed\n":                "failed",
+		"no prefix\n":                                        "no prefix",
+	}
+	for line, expected := range tests {
+		if actual := stripTextPrefix(line); actual != expected {
+			t.Errorf("stripTextPrefix(%q) is %q", line, actual)
+		}
+	}
+}
+
+func TestWriteJournalField(t *testing.T) {
+	var buf bytes.Buffer
+	writeJournalField(&buf, "PRIORITY", "6")
+	writeJournalField(&buf, "MESSAGE", "two\nlines")
+	var expected bytes.Buffer
+	expected.WriteString("PRIORITY=6\nMESSAGE\n")
+	binary.Write(&expected, binary.LittleEndian, uint64(len("two\nlines")))
+	expected.WriteString("two\nlines\n")
+	if !bytes.Equal(buf.Bytes(), expected.Bytes()) {
+		t.Errorf("wrote %q, expected %q", buf.Bytes(), expected.Bytes())
+	}
+}
+
+func TestSyslogWriter(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	w, err := NewSyslogWriter("udp", conn.LocalAddr().String(), "jenkronize", "local0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.WriteLevel(LevelWarn, []byte("WARN:  2024/01/02 03:04:05 disk is filling up\n")); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	packet := make([]byte, 1024)
+	n, _, err := conn.ReadFrom(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := string(packet[:n])
+	// local0 is facility 16, and warning priority 4
+	if !strings.HasPrefix(message, "<132>") {
+		t.Errorf("the message has the wrong priority: %q", message)
+	}
+	if !strings.Contains(message, "jenkronize") || !strings.HasSuffix(strings.TrimSpace(message), "disk is filling up") {
+		t.Errorf("the message is %q", message)
+	}
+
+	if _, err := NewSyslogWriter("udp", conn.LocalAddr().String(), "jenkronize", "nope"); err == nil {
+		t.Error("an unknown facility was accepted")
+	}
+}
@@ -0,0 +1,16 @@
+//go:build windows
+// +build windows
+
+package logging
+
+import "fmt"
+
+// NewSyslogWriter is not supported on Windows.
+func NewSyslogWriter(network string, address string, tag string, facility string) (LevelWriter, error) {
+	return nil, fmt.Errorf("syslog is not supported on Windows")
+}
+
+// NewJournaldWriter is not supported on Windows.
+func NewJournaldWriter(identifier string) (LevelWriter, error) {
+	return nil, fmt.Errorf("journald is not supported on Windows")
+}
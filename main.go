@@ -1,39 +1,206 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"github.com/pakohler/jenkronize/config"
 	"github.com/pakohler/jenkronize/jenkins"
+	"github.com/pakohler/jenkronize/logging"
 	"github.com/pakohler/jenkronize/notifications"
 	"github.com/pakohler/jenkronize/tracking"
+	"os"
 )
 
-func main() {
-	conf := config.Get()
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+var (
+	verbose    = flag.Bool("verbose", false, "log debug messages; overrides log_level from the config")
+	quiet      = flag.Bool("quiet", false, "only log warnings and errors; overrides log_level from the config")
+	configPath = flag.String("config", "", "the config file to use; overrides $JENKRONIZE_CONFIG and the search paths")
+)
+
+func init() {
+	flag.BoolVar(verbose, "v", false, "shorthand for --verbose")
+	flag.BoolVar(quiet, "q", false, "shorthand for --quiet")
+}
+
+type command struct {
+	name    string
+	args    string
+	summary string
+	// daemon commands log at the configured level; the others only log
+	// warnings and errors unless --verbose is given, so their output stays
+	// readable.
+	daemon bool
+	run    func(cmd *command, args []string) int
+}
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{name: "run", summary: "track the configured jobs forever (the default)", daemon: true, run: runCommand},
+		{name: "once", args: "[job...]", summary: "sync all or the given jobs once, exiting non-zero if any failed", daemon: true, run: onceCommand},
+		{name: "status", args: "[--offline]", summary: "show the synced and latest builds of each job", run: statusCommand},
+		{name: "list-builds", args: "<job>", summary: "list the builds of a job that Jenkins still has", run: listBuildsCommand},
+		{name: "fetch", args: "<job> <build>", summary: "download the artifacts of a specific build", run: fetchCommand},
+		{name: "prune", args: "[job...]", summary: "remove builds which are no longer meant to be cached", run: pruneCommand},
+		{name: "state", args: "show | reset <job...>|--all | set <job> <build>", summary: "show or change the build each job is synced to", run: stateCommand},
+		{name: "config", args: "validate | init [--interactive] [--stdout]", summary: "check the config file, or write an example one", run: configCommand},
+		{name: "verify", args: "[--public-key path] <build dir...>", summary: "recheck mirrored builds against their checksum lists and signatures", run: verifyCommand},
+		{name: "version", summary: "print the version", run: versionCommand},
+	}
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "usage: %s [--config path] [--verbose|--quiet] <command> [args]\n\ncommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-12s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(out, "\nflags:\n")
+	flag.PrintDefaults()
+}
+
+// newFlagSet returns the flags of a command, which print its usage on error.
+func newFlagSet(cmd *command) *flag.FlagSet {
+	flags := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: %s %s %s\n\n%s\n", os.Args[0], cmd.name, cmd.args, cmd.summary)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parseFlags parses the command's flags, returning the exit status to use if
+// the command shouldn't go ahead.
+func parseFlags(flags *flag.FlagSet, args []string) (int, bool) {
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0, false
+		}
+		return 2, false
+	}
+	return 0, true
+}
+
+// badUsage prints the command's usage along with what was wrong.
+func badUsage(flags *flag.FlagSet, msg string) int {
+	fmt.Fprintln(flags.Output(), msg)
+	flags.Usage()
+	return 2
+}
+
+func fail(err error) int {
+	fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+	return 1
+}
+
+// setVerbosity applies --verbose and --quiet, which override log_level from
+// the config.
+func setVerbosity(daemon bool) {
+	if *verbose {
+		logging.GetLogger().SetLevel(logging.LevelDebug)
+	} else if *quiet || !daemon {
+		logging.GetLogger().SetLevel(logging.LevelWarn)
+	}
+}
+
+// loadConfig loads the config file, or returns an error if it's missing or
+// invalid.
+func loadConfig(daemon bool) (*config.Config, error) {
+	conf, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+	// the flags override the config
+	setVerbosity(daemon)
+	return conf, nil
+}
 
-	leeroy := jenkins.
+func newClient(conf *config.Config) *jenkins.JenkinsAPIClient {
+	return jenkins.
 		New().
 		SetUser(conf.Jenkins.Username).
 		SetPassword(conf.Jenkins.Password).
 		SetBaseUrl(conf.Jenkins.URL)
+}
 
+// newTracker sets up a tracker for the configured jobs, without any notifiers
+// and before its state is loaded.
+func newTracker(conf *config.Config) (*tracking.Tracker, error) {
+	messages, err := tracking.NewMessages(conf.Messages)
+	if err != nil {
+		return nil, fmt.Errorf("invalid message template: %v", err)
+	}
 	tracker := (&tracking.Tracker{}).
 		Init().
-		SetClient(leeroy).
-		SetInterval(conf.Tracker.Interval.String())
-
-	if conf.Slack.Webhook != "" {
-		slack := notifications.NewSlackNotifier(conf.Slack.Webhook)
-		if conf.Slack.Channel != "" {
-			slack.SetChannel(conf.Slack.Channel)
+		SetClient(newClient(conf)).
+		SetInterval(conf.Tracker.Interval.String()).
+		SetDiscoveryInterval(conf.Tracker.DiscoveryInterval).
+		SetMessages(messages)
+	if conf.StateFile != "" {
+		tracker.SetStateFile(conf.StateFile)
+	}
+	algorithms := []tracking.ChecksumAlgorithm{}
+	for _, name := range conf.Checksums.Algorithms {
+		algorithm, err := tracking.ParseChecksumAlgorithm(name)
+		if err != nil {
+			return nil, err
 		}
-		tracker.AddNotifier(slack)
+		algorithms = append(algorithms, algorithm)
 	}
-
+	var signer tracking.Signer
+	if signing := conf.Checksums.Signing; signing.KeyFile != "" {
+		if signer, err = tracking.NewSigner(signing.Type, signing.KeyFile, signing.Passphrase); err != nil {
+			return nil, fmt.Errorf("unable to load signing key: %v", err)
+		}
+	}
+	tracker.
+		SetChecksums(algorithms, signer).
+		SetBlobStore(conf.BlobStore.Enabled, conf.BlobStore.Dir)
 	for _, job := range conf.Tracker.TrackedJobs {
 		job.Init()
 		tracker.Track(job)
 	}
+	return tracker, nil
+}
+
+// addNotifiers sends the tracker's notifications to the configured notifiers,
+// each through its own queue.
+func addNotifiers(tracker *tracking.Tracker, conf *config.Config) error {
+	router, err := newRouter(conf, func(name string, n notifications.Notifier) notifications.Notifier {
+		return newQueue(&conf.Queue, name, n)
+	})
+	if err != nil {
+		return fmt.Errorf("invalid notification config: %v", err)
+	}
+	tracker.
+		AddNotifier(router).
+		SetRateLimit(conf.RateLimit.Max, conf.RateLimit.Period)
+	return nil
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if *configPath != "" {
+		config.SetPath(*configPath)
+	}
 
-	tracker.LoadState()
-	tracker.Go()
+	name := "run"
+	args := flag.Args()
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	for _, cmd := range commands {
+		if cmd.name == name {
+			setVerbosity(cmd.daemon)
+			os.Exit(cmd.run(cmd, args))
+		}
+	}
+	fmt.Fprintf(flag.CommandLine.Output(), "unknown command %q\n", name)
+	usage()
+	os.Exit(2)
 }
@@ -0,0 +1,196 @@
+package notifications
+
+import (
+	"github.com/pakohler/jenkronize/logging"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Alerts sits in front of another Notifier and keeps track of which problems
+// are ongoing. Repeats of an active condition are dropped, a resolved event is
+// only passed on if its condition was actually active, and an optional global
+// rate limit caps how many events get through per period; events over the
+// limit are summarized in a single message once the period ends.
+type Alerts struct {
+	next        Notifier
+	active      map[string]*Event
+	rateLimit   int
+	ratePeriod  time.Duration
+	windowStart time.Time
+	sent        int
+	overflow    []*Event
+	flushTimer  *time.Timer
+	summaryText func(*Summary) string
+	mux         sync.Mutex
+	log         *logging.Logger
+}
+
+func NewAlerts(next Notifier) *Alerts {
+	return &Alerts{
+		next:   next,
+		active: map[string]*Event{},
+		log:    logging.GetPackageLogger("notifications"),
+	}
+}
+
+// SetRateLimit allows at most max events through per period. A max of zero or
+// less disables rate limiting.
+func (a *Alerts) SetRateLimit(max int, period time.Duration) *Alerts {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	a.rateLimit = max
+	a.ratePeriod = period
+	return a
+}
+
+// Summary describes the events held back by the rate limit during a period.
+type Summary struct {
+	Count  int
+	Period time.Duration
+	// Types counts the events of each type held back, eg. "2 sync_failed",
+	// and Jobs are the jobs they were about
+	Types []string
+	Jobs  []string
+}
+
+// SetSummaryText sets how the text of the message summarizing the events held
+// back by the rate limit is written.
+func (a *Alerts) SetSummaryText(text func(*Summary) string) *Alerts {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	a.summaryText = text
+	return a
+}
+
+// IsActive reports whether the condition is currently active for the job path.
+func (a *Alerts) IsActive(jobPath string, condition string) bool {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	_, ok := a.active[conditionKey(jobPath, condition)]
+	return ok
+}
+
+func conditionKey(jobPath string, condition string) string {
+	return jobPath + "|" + condition
+}
+
+func (a *Alerts) Post(event *Event) error {
+	// satisfy the Notifier interface; filter and rate limit before passing on
+	a.mux.Lock()
+	key := conditionKey(event.JobPath, event.Condition)
+	if event.Condition != "" {
+		_, active := a.active[key]
+		if event.Type == EventResolved {
+			if !active {
+				a.mux.Unlock()
+				return nil
+			}
+			event.Resolves = a.active[key]
+			delete(a.active, key)
+		} else if active {
+			a.log.Trace.Printf("suppressing repeated %s notification for %q", event.Condition, event.JobPath)
+			a.mux.Unlock()
+			return nil
+		}
+	}
+	if !a.allow(event) {
+		a.mux.Unlock()
+		return nil
+	}
+	if event.Condition != "" && event.Type != EventResolved {
+		// only a problem which was actually notified is active; one held
+		// back by the rate limit is notified again if it recurs
+		a.active[key] = event
+	}
+	a.mux.Unlock()
+	return a.next.Post(event)
+}
+
+// allow applies the rate limit; it must be called with the mutex held.
+func (a *Alerts) allow(event *Event) bool {
+	if a.rateLimit <= 0 || a.ratePeriod <= 0 {
+		return true
+	}
+	now := time.Now()
+	if now.Sub(a.windowStart) >= a.ratePeriod {
+		a.windowStart = now
+		a.sent = 0
+	}
+	if a.sent < a.rateLimit {
+		a.sent++
+		return true
+	}
+	a.overflow = append(a.overflow, event)
+	if a.flushTimer == nil {
+		a.flushTimer = time.AfterFunc(a.windowStart.Add(a.ratePeriod).Sub(now), func() {
+			if err := a.flush(); err != nil {
+				a.log.Error.Print(err.Error())
+			}
+		})
+	}
+	return false
+}
+
+// Close sends the summary of any events held back by the rate limit, rather
+// than waiting for the period to end.
+func (a *Alerts) Close() error {
+	a.mux.Lock()
+	if a.flushTimer != nil {
+		a.flushTimer.Stop()
+	}
+	a.mux.Unlock()
+	return a.flush()
+}
+
+func (a *Alerts) flush() error {
+	a.mux.Lock()
+	events := a.overflow
+	a.overflow = nil
+	a.flushTimer = nil
+	a.mux.Unlock()
+	if len(events) == 0 {
+		return nil
+	}
+	event := summarize(events, a.ratePeriod)
+	a.mux.Lock()
+	text := a.summaryText
+	a.mux.Unlock()
+	if text != nil {
+		event.Text = text(event.Summary)
+	}
+	return a.next.Post(event)
+}
+
+func summarize(events []*Event, period time.Duration) *Event {
+	event := &Event{
+		Type:     EventSuppressed,
+		Severity: SeverityInfo,
+		Summary:  &Summary{Count: len(events), Period: period, Types: []string{}, Jobs: []string{}},
+	}
+	counts := map[EventType]int{}
+	jobs := map[string]bool{}
+	for _, e := range events {
+		counts[e.Type]++
+		if e.Job != "" {
+			jobs[e.Job] = true
+		}
+		if e.Severity > event.Severity {
+			event.Severity = e.Severity
+		}
+	}
+	types := []string{}
+	for t := range counts {
+		types = append(types, string(t))
+	}
+	sort.Strings(types)
+	for _, t := range types {
+		event.Summary.Types = append(event.Summary.Types, strconv.Itoa(counts[EventType(t)])+" "+t)
+	}
+	for j := range jobs {
+		event.Summary.Jobs = append(event.Summary.Jobs, j)
+	}
+	sort.Strings(event.Summary.Jobs)
+	return event
+}
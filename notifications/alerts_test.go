@@ -0,0 +1,120 @@
+package notifications
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAlertsSuppressRepeats(t *testing.T) {
+	next := &recorder{}
+	alerts := NewAlerts(next)
+	problem := func(jobPath string) *Event {
+		return &Event{Type: EventApiError, Text: "down " + jobPath, JobPath: jobPath, Condition: string(EventApiError)}
+	}
+	resolved := func(jobPath string) *Event {
+		return &Event{Type: EventResolved, Text: "up " + jobPath, JobPath: jobPath, Condition: string(EventApiError)}
+	}
+	for _, event := range []*Event{
+		problem("/job/a"),
+		problem("/job/a"),
+		problem("/job/b"),
+		resolved("/job/a"),
+		resolved("/job/a"),
+		problem("/job/a"),
+		{Type: EventSyncComplete, Text: "synced", JobPath: "/job/a"},
+		{Type: EventSyncComplete, Text: "synced", JobPath: "/job/a"},
+	} {
+		if err := alerts.Post(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := "[down /job/a down /job/b up /job/a down /job/a synced synced]"
+	if texts := fmt.Sprint(next.texts()); texts != expected {
+		t.Errorf("delivered %s, expected %s", texts, expected)
+	}
+	if !alerts.IsActive("/job/b", string(EventApiError)) || alerts.IsActive("/job/c", string(EventApiError)) {
+		t.Error("the active conditions are wrong")
+	}
+}
+
+func summaryText(summary *Summary) string {
+	return fmt.Sprintf("%d in %s: %s; %s", summary.Count, summary.Period, strings.Join(summary.Types, ", "), strings.Join(summary.Jobs, ", "))
+}
+
+func TestAlertsRateLimit(t *testing.T) {
+	next := &recorder{}
+	alerts := NewAlerts(next).SetRateLimit(2, 100*time.Millisecond).SetSummaryText(summaryText)
+	events := []*Event{
+		{Type: EventSyncComplete, Text: "1", Job: "a", Severity: SeveritySuccess},
+		{Type: EventSyncComplete, Text: "2", Job: "a", Severity: SeveritySuccess},
+		{Type: EventSyncComplete, Text: "3", Job: "b", Severity: SeveritySuccess},
+		{Type: EventSyncFailed, Text: "4", Job: "a", Severity: SeverityError},
+		{Type: EventSyncComplete, Text: "5", Job: "c", Severity: SeveritySuccess},
+	}
+	for _, event := range events {
+		if err := alerts.Post(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if texts := fmt.Sprint(next.texts()); texts != "[1 2]" {
+		t.Fatalf("delivered %s before the period ended", texts)
+	}
+	time.Sleep(300 * time.Millisecond)
+	next.mux.Lock()
+	defer next.mux.Unlock()
+	if len(next.events) != 3 {
+		t.Fatalf("delivered %d events, expected the 2 allowed and a summary", len(next.events))
+	}
+	summary := next.events[2]
+	if summary.Type != EventSuppressed || summary.Severity != SeverityError {
+		t.Errorf("the summary is a %s %s", summary.Severity, summary.Type)
+	}
+	if expected := "3 in 100ms: 2 sync_complete, 1 sync_failed; a, b, c"; summary.Text != expected {
+		t.Errorf("the summary is %q, expected %q", summary.Text, expected)
+	}
+}
+
+func TestAlertsCloseSendsSummary(t *testing.T) {
+	next := &recorder{}
+	alerts := NewAlerts(next).SetRateLimit(1, time.Hour).SetSummaryText(summaryText)
+	alerts.Post(&Event{Type: EventNewBuild, Text: "1"})
+	alerts.Post(&Event{Type: EventNewBuild, Text: "2"})
+	if err := alerts.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if texts := fmt.Sprint(next.texts()); texts != "[1 1 in 1h0m0s: 1 new_build; ]" {
+		t.Errorf("delivered %s", texts)
+	}
+}
+
+func TestAlertsRateLimitedCondition(t *testing.T) {
+	next := &recorder{}
+	alerts := NewAlerts(next).SetRateLimit(1, time.Hour).SetSummaryText(summaryText)
+	alerts.Post(&Event{Type: EventNewBuild, Text: "new", JobPath: "/job/a"})
+	// the problem is held back by the rate limit, so it isn't active yet
+	problem := &Event{Type: EventApiError, Text: "down", JobPath: "/job/a", Condition: string(EventApiError)}
+	alerts.Post(problem)
+	if alerts.IsActive("/job/a", string(EventApiError)) {
+		t.Error("a problem held back by the rate limit is active")
+	}
+	// and its resolution isn't sent on its own, since the problem never was
+	alerts.Post(&Event{Type: EventResolved, Text: "up", JobPath: "/job/a", Condition: string(EventApiError)})
+	if err := alerts.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if texts := fmt.Sprint(next.texts()); texts != "[new 1 in 1h0m0s: 1 api_error; ]" {
+		t.Errorf("delivered %s", texts)
+	}
+
+	// once the rate limit allows it, a recurrence is sent and is active
+	alerts.SetRateLimit(0, 0)
+	alerts.Post(&Event{Type: EventApiError, Text: "down again", JobPath: "/job/a", Condition: string(EventApiError)})
+	if !alerts.IsActive("/job/a", string(EventApiError)) {
+		t.Error("the problem isn't active once it's been sent")
+	}
+	if texts := next.texts(); texts[len(texts)-1] != "down again" {
+		t.Errorf("delivered %v", texts)
+	}
+}
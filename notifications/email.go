@@ -0,0 +1,454 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"github.com/pakohler/jenkronize/logging"
+	"html"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// EmailSecurityNone sends mail over a plain connection; only sensible for
+	// local relays or testing against a local SMTP stand-in.
+	EmailSecurityNone = "none"
+	// EmailSecurityStartTLS connects in plain text and upgrades via STARTTLS.
+	EmailSecurityStartTLS = "starttls"
+	// EmailSecurityTLS uses implicit TLS from the start (usually port 465).
+	EmailSecurityTLS = "tls"
+)
+
+type emailEntry struct {
+	time  time.Time
+	event *Event
+}
+
+// spooledEmailEntry is how an emailEntry is kept in the digest spool.
+type spooledEmailEntry struct {
+	Time  time.Time `json:"time"`
+	Event *Event    `json:"event"`
+}
+
+type Email struct {
+	host               string
+	port               int
+	username           string
+	password           string
+	from               string
+	to                 []string
+	subject            string
+	security           string
+	insecureSkipVerify bool
+	timeout            time.Duration
+	digestWindow       time.Duration
+	digestSpool        string
+	pending            []emailEntry
+	sending            []emailEntry
+	timer              *time.Timer
+	mux                sync.Mutex
+	flushMux           sync.Mutex
+	log                *logging.Logger
+}
+
+func NewEmailNotifier(host string, port int, from string, to []string) *Email {
+	e := &Email{
+		host:     host,
+		port:     port,
+		from:     from,
+		to:       to,
+		subject:  "jenkronize",
+		security: EmailSecurityStartTLS,
+		timeout:  30 * time.Second,
+		log:      logging.GetPackageLogger("notifications"),
+	}
+	return e
+}
+
+func (e *Email) SetAuth(username string, password string) *Email {
+	e.username = username
+	e.password = password
+	return e
+}
+
+func (e *Email) SetSubject(subject string) *Email {
+	e.subject = subject
+	return e
+}
+
+// SetSecurity selects how the connection to the SMTP server is secured; one of
+// EmailSecurityNone, EmailSecurityStartTLS or EmailSecurityTLS.
+func (e *Email) SetSecurity(mode string) *Email {
+	e.security = strings.ToLower(mode)
+	return e
+}
+
+func (e *Email) SetInsecureSkipVerify(skip bool) *Email {
+	e.insecureSkipVerify = skip
+	return e
+}
+
+func (e *Email) SetTimeout(timeout time.Duration) *Email {
+	e.timeout = timeout
+	return e
+}
+
+// SetDigestWindow enables digest mode: instead of one email per message,
+// messages are collected for the given window and sent as a single summary.
+// A zero window disables digest mode.
+func (e *Email) SetDigestWindow(window time.Duration) *Email {
+	e.digestWindow = window
+	return e
+}
+
+// SetDigestSpool keeps the messages collected for a digest in the file until
+// the digest has been sent, so they survive restarts. Any messages left in it
+// by a previous run are sent with the next digest.
+func (e *Email) SetDigestSpool(file string) *Email {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.digestSpool = file
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			e.log.Error.Printf("unable to read email digest spool %s: %v", file, err)
+		}
+		return e
+	}
+	spooled := []spooledEmailEntry{}
+	if err := json.Unmarshal(data, &spooled); err != nil {
+		e.log.Error.Printf("discarding unreadable email digest spool %s: %v", file, err)
+		os.Remove(file)
+		return e
+	}
+	for _, entry := range spooled {
+		if entry.Event != nil {
+			e.pending = append(e.pending, emailEntry{time: entry.Time, event: entry.Event})
+		}
+	}
+	if len(e.pending) > 0 {
+		e.log.Info.Printf("re-queued %d undelivered email digest notifications", len(e.pending))
+		e.startTimer()
+	}
+	return e
+}
+
+func (e *Email) Post(event *Event) error {
+	// satisfy the Notifier interface and send (or queue) an email
+	return e.PostContext(context.Background(), event)
+}
+
+// PostContext sends an email, or adds it to the digest, giving up on the SMTP
+// connection at ctx's deadline.
+func (e *Email) PostContext(ctx context.Context, event *Event) error {
+	if e.host == "" {
+		return fmt.Errorf("Email notification impossible; no SMTP host specified")
+	}
+	if len(e.to) == 0 {
+		return fmt.Errorf("Email notification impossible; no recipients specified")
+	}
+	if e.digestWindow <= 0 {
+		return e.send(ctx, e.subject, []emailEntry{{time: time.Now(), event: event}})
+	}
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.pending = append(e.pending, emailEntry{time: time.Now(), event: event})
+	if err := e.saveDigest(); err != nil {
+		// fail the post, so the message is retried rather than lost
+		e.pending = e.pending[:len(e.pending)-1]
+		return fmt.Errorf("Email notification could not be added to the digest: %v", err)
+	}
+	e.startTimer()
+	return nil
+}
+
+// startTimer schedules the pending digest to be sent once the digest window
+// closes; it must be called with the mutex held.
+func (e *Email) startTimer() {
+	if e.timer != nil {
+		return
+	}
+	e.timer = time.AfterFunc(e.digestWindow, func() {
+		if err := e.Flush(); err != nil {
+			e.log.Error.Print(err.Error())
+		}
+	})
+}
+
+// Flush immediately sends any messages waiting for the digest window to close.
+// If the digest can't be sent, the messages are kept for the next one.
+func (e *Email) Flush() error {
+	return e.FlushContext(context.Background())
+}
+
+// FlushContext is like Flush, but gives up on the SMTP connection at ctx's
+// deadline.
+func (e *Email) FlushContext(ctx context.Context) error {
+	e.flushMux.Lock()
+	defer e.flushMux.Unlock()
+	e.mux.Lock()
+	e.sending = e.pending
+	e.pending = nil
+	if e.timer != nil {
+		e.timer.Stop()
+		e.timer = nil
+	}
+	entries := e.sending
+	e.mux.Unlock()
+	if len(entries) == 0 {
+		return nil
+	}
+	subject := e.subject
+	if len(entries) > 1 {
+		subject = fmt.Sprintf("%s - digest of %d notifications", e.subject, len(entries))
+	}
+	err := e.send(ctx, subject, entries)
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	if err != nil {
+		e.pending = append(e.sending, e.pending...)
+		e.sending = nil
+		if e.digestWindow > 0 {
+			e.startTimer()
+		}
+		return err
+	}
+	e.sending = nil
+	if err := e.saveDigest(); err != nil {
+		e.log.Error.Printf("unable to update email digest spool %s: %v", e.digestSpool, err)
+	}
+	return nil
+}
+
+// saveDigest writes the messages which are waiting for a digest, or being
+// sent in one, to the digest spool; it must be called with the mutex held.
+func (e *Email) saveDigest() error {
+	if e.digestSpool == "" {
+		return nil
+	}
+	entries := append(append([]emailEntry{}, e.sending...), e.pending...)
+	if len(entries) == 0 {
+		if err := os.Remove(e.digestSpool); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	spooled := []spooledEmailEntry{}
+	for _, entry := range entries {
+		spooled = append(spooled, spooledEmailEntry{Time: entry.time, Event: entry.event})
+	}
+	data, err := json.Marshal(spooled)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(e.digestSpool), 0700); err != nil {
+		return err
+	}
+	// write a whole new file, so a crash never leaves a partial one behind
+	tmp := e.digestSpool + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, e.digestSpool)
+}
+
+// Close sends any pending digest.
+func (e *Email) Close() error {
+	return e.Flush()
+}
+
+// CloseContext sends any pending digest, giving up at ctx's deadline.
+func (e *Email) CloseContext(ctx context.Context) error {
+	return e.FlushContext(ctx)
+}
+
+func (e *Email) send(ctx context.Context, subject string, entries []emailEntry) error {
+	body, err := e.buildMessage(subject, entries)
+	if err != nil {
+		return err
+	}
+	client, err := e.dial(ctx)
+	if err != nil {
+		return fmt.Errorf("Email notification failed to connect to %s: %v", e.address(), err)
+	}
+	defer client.Close()
+	if e.username != "" {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return fmt.Errorf("Email notification failed; %s does not support authentication", e.address())
+		}
+		if err := client.Auth(smtp.PlainAuth("", e.username, e.password, e.host)); err != nil {
+			return fmt.Errorf("Email notification authentication failed: %v", err)
+		}
+	}
+	if err := client.Mail(e.from); err != nil {
+		return fmt.Errorf("Email notification rejected sender %s: %v", e.from, err)
+	}
+	for _, rcpt := range e.to {
+		if err := client.Rcpt(rcpt); err != nil {
+			return fmt.Errorf("Email notification rejected recipient %s: %v", rcpt, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("Email notification failed to start message data: %v", err)
+	}
+	if _, err := w.Write(body); err != nil {
+		w.Close()
+		return fmt.Errorf("Email notification failed to write message: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("Email notification was not accepted: %v", err)
+	}
+	return client.Quit()
+}
+
+func (e *Email) address() string {
+	port := e.port
+	if port == 0 {
+		// fall back to the conventional port for the security mode
+		switch e.security {
+		case EmailSecurityTLS:
+			port = 465
+		case EmailSecurityNone:
+			port = 25
+		default:
+			port = 587
+		}
+	}
+	return net.JoinHostPort(e.host, strconv.Itoa(port))
+}
+
+func (e *Email) dial(ctx context.Context) (*smtp.Client, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         e.host,
+		InsecureSkipVerify: e.insecureSkipVerify,
+	}
+	dialer := &net.Dialer{Timeout: e.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", e.address())
+	if err != nil {
+		return nil, err
+	}
+	// the whole SMTP conversation has to finish within the timeout, and
+	// before ctx's deadline
+	var deadline time.Time
+	if e.timeout > 0 {
+		deadline = time.Now().Add(e.timeout)
+	}
+	if ctxDeadline, ok := ctx.Deadline(); ok && (deadline.IsZero() || ctxDeadline.Before(deadline)) {
+		deadline = ctxDeadline
+	}
+	conn.SetDeadline(deadline)
+	if e.security == EmailSecurityTLS {
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	client, err := smtp.NewClient(conn, e.host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		if err := client.Hello(hostname); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	switch e.security {
+	case EmailSecurityStartTLS:
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			client.Close()
+			return nil, fmt.Errorf("server does not support STARTTLS")
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			client.Close()
+			return nil, err
+		}
+	case EmailSecurityTLS, EmailSecurityNone:
+	default:
+		client.Close()
+		return nil, fmt.Errorf("unknown security mode %q", e.security)
+	}
+	return client, nil
+}
+
+func (e *Email) buildMessage(subject string, entries []emailEntry) ([]byte, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	headers := []string{
+		"From: " + e.from,
+		"To: " + strings.Join(e.to, ", "),
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/alternative; boundary=" + mw.Boundary(),
+	}
+	buf.WriteString(strings.Join(headers, "\r\n") + "\r\n\r\n")
+
+	var plain, rich bytes.Buffer
+	rich.WriteString("<html><body>\n")
+	if len(entries) > 1 {
+		rich.WriteString("<ul>\n")
+	}
+	for _, entry := range entries {
+		stamp := entry.time.Format("2006-01-02 15:04:05")
+		text := html.EscapeString(entry.event.Text)
+		if entry.event.BuildUrl != "" {
+			text += fmt.Sprintf(` (<a href="%s">build</a>)`, html.EscapeString(entry.event.BuildUrl))
+		}
+		if entry.event.MirrorUrl != "" {
+			text += fmt.Sprintf(` (<a href="%s">mirror</a>)`, html.EscapeString(entry.event.MirrorUrl))
+		}
+		if len(entries) > 1 {
+			fmt.Fprintf(&plain, "[%s] %s\r\n", stamp, entry.event.Text)
+			fmt.Fprintf(&rich, "<li><code>%s</code> %s</li>\n", stamp, text)
+		} else {
+			fmt.Fprintf(&plain, "%s\r\n", entry.event.Text)
+			fmt.Fprintf(&rich, "<p>%s</p>\n", text)
+		}
+	}
+	if len(entries) > 1 {
+		rich.WriteString("</ul>\n")
+	}
+	rich.WriteString("</body></html>\n")
+
+	parts := []struct {
+		contentType string
+		body        []byte
+	}{
+		{"text/plain; charset=utf-8", plain.Bytes()},
+		{"text/html; charset=utf-8", rich.Bytes()},
+	}
+	for _, part := range parts {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"8bit"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(part.body); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
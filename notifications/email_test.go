@@ -0,0 +1,297 @@
+package notifications
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpMessage is a message received by the fake SMTP server.
+type smtpMessage struct {
+	from string
+	to   []string
+	data string
+}
+
+// fakeSMTP is a minimal SMTP server which accepts every message it's sent.
+type fakeSMTP struct {
+	listener net.Listener
+	messages chan *smtpMessage
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSMTP{listener: listener, messages: make(chan *smtpMessage, 10)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 fake SMTP")
+	message := &smtpMessage{}
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			text.PrintfLine("250 fake")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			message.from = strings.Trim(line[len("MAIL FROM:"):], "<>")
+			text.PrintfLine("250 ok")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			message.to = append(message.to, strings.Trim(line[len("RCPT TO:"):], "<>"))
+			text.PrintfLine("250 ok")
+		case command == "DATA":
+			text.PrintfLine("354 go ahead")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			message.data = string(data)
+			s.messages <- message
+			message = &smtpMessage{}
+			text.PrintfLine("250 ok")
+		case command == "QUIT":
+			text.PrintfLine("221 bye")
+			return
+		default:
+			text.PrintfLine("250 ok")
+		}
+	}
+}
+
+func (s *fakeSMTP) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeSMTP) Close() {
+	s.listener.Close()
+}
+
+func (s *fakeSMTP) receive(t *testing.T) *smtpMessage {
+	select {
+	case message := <-s.messages:
+		return message
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message was received")
+		return nil
+	}
+}
+
+func newTestEmail(port int) *Email {
+	return NewEmailNotifier("127.0.0.1", port, "jenkronize@example.org", []string{"ops@example.org", "dev@example.org"}).
+		SetSecurity(EmailSecurityNone).
+		SetSubject("mirror").
+		SetTimeout(5 * time.Second)
+}
+
+// headers returns the headers of the message data.
+func headers(t *testing.T, data string) textproto.MIMEHeader {
+	header, err := textproto.NewReader(bufio.NewReader(strings.NewReader(data))).ReadMIMEHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return header
+}
+
+// unusedPort returns a port which nothing is listening on.
+func unusedPort(t *testing.T) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+	return port
+}
+
+func TestEmailPost(t *testing.T) {
+	server := newFakeSMTP(t)
+	defer server.Close()
+	email := newTestEmail(server.port())
+	err := email.Post(&Event{
+		Type:     EventSyncComplete,
+		Text:     "installer - completed downloading artifacts for build number 42",
+		BuildUrl: "https://jenkins.example.org/job/installer/42/",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := server.receive(t)
+	if message.from != "jenkronize@example.org" {
+		t.Errorf("sender is %q", message.from)
+	}
+	if strings.Join(message.to, ",") != "ops@example.org,dev@example.org" {
+		t.Errorf("recipients are %v", message.to)
+	}
+	header := headers(t, message.data)
+	expected := map[string]string{
+		"From":         "jenkronize@example.org",
+		"To":           "ops@example.org, dev@example.org",
+		"Subject":      "mirror",
+		"Mime-Version": "1.0",
+	}
+	for name, value := range expected {
+		if header.Get(name) != value {
+			t.Errorf("%s header is %q, expected %q", name, header.Get(name), value)
+		}
+	}
+	if !strings.HasPrefix(header.Get("Content-Type"), "multipart/alternative") {
+		t.Errorf("Content-Type header is %q", header.Get("Content-Type"))
+	}
+	if !strings.Contains(message.data, "completed downloading artifacts for build number 42") {
+		t.Errorf("the message doesn't contain the notification:\n%s", message.data)
+	}
+	if !strings.Contains(message.data, `<a href="https://jenkins.example.org/job/installer/42/">build</a>`) {
+		t.Errorf("the message doesn't link to the build:\n%s", message.data)
+	}
+}
+
+func TestEmailPostContext(t *testing.T) {
+	// a server which accepts connections but never greets the client
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	email := newTestEmail(listener.Addr().(*net.TCPAddr).Port)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := email.PostContext(ctx, &Event{Text: "hello"}); err == nil {
+		t.Fatal("posting to a silent server succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("the post gave up after %s instead of at the context's deadline", elapsed)
+	}
+}
+
+func TestEmailDigest(t *testing.T) {
+	server := newFakeSMTP(t)
+	defer server.Close()
+	email := newTestEmail(server.port()).SetDigestWindow(time.Hour)
+	for i := 1; i <= 3; i++ {
+		if err := email.Post(&Event{Text: fmt.Sprintf("notification %d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case <-server.messages:
+		t.Fatal("a message was sent before the digest window closed")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if err := email.Close(); err != nil {
+		t.Fatal(err)
+	}
+	message := server.receive(t)
+	if subject := headers(t, message.data).Get("Subject"); subject != "mirror - digest of 3 notifications" {
+		t.Errorf("subject is %q", subject)
+	}
+	last := -1
+	for i := 1; i <= 3; i++ {
+		at := strings.Index(message.data, fmt.Sprintf("] notification %d\n", i))
+		if at < 0 {
+			t.Fatalf("notification %d isn't in the digest:\n%s", i, message.data)
+		}
+		if at < last {
+			t.Errorf("notification %d is out of order", i)
+		}
+		last = at
+	}
+	if strings.Count(message.data, "<li>") != 3 {
+		t.Errorf("the HTML part doesn't list the 3 notifications:\n%s", message.data)
+	}
+}
+
+func TestEmailDigestSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jenkronize-email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	spool := filepath.Join(dir, "digest.spool")
+
+	// the digest can't be sent, so it's kept in the spool
+	email := newTestEmail(unusedPort(t)).SetDigestWindow(time.Hour).SetDigestSpool(spool)
+	if err := email.Post(&Event{Text: "first"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := email.Post(&Event{Text: "second"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := email.Close(); err == nil {
+		t.Fatal("sending the digest to a closed port succeeded")
+	}
+	if _, err := os.Stat(spool); err != nil {
+		t.Fatalf("the digest wasn't spooled: %v", err)
+	}
+
+	// and sent by the next run
+	server := newFakeSMTP(t)
+	defer server.Close()
+	email = newTestEmail(server.port()).SetDigestWindow(time.Hour).SetDigestSpool(spool)
+	if err := email.Close(); err != nil {
+		t.Fatal(err)
+	}
+	message := server.receive(t)
+	if !strings.Contains(message.data, "] first\n") || !strings.Contains(message.data, "] second\n") {
+		t.Errorf("the spooled notifications weren't sent:\n%s", message.data)
+	}
+	if _, err := os.Stat(spool); !os.IsNotExist(err) {
+		t.Errorf("the spool wasn't removed once the digest was sent: %v", err)
+	}
+}
+
+func TestEmailAddress(t *testing.T) {
+	tests := []struct {
+		security string
+		port     int
+		expected int
+	}{
+		{EmailSecurityStartTLS, 0, 587},
+		{EmailSecurityTLS, 0, 465},
+		{EmailSecurityNone, 0, 25},
+		{EmailSecurityNone, 2525, 2525},
+	}
+	for _, test := range tests {
+		email := NewEmailNotifier("smtp.example.org", test.port, "a@example.org", []string{"b@example.org"}).SetSecurity(test.security)
+		expected := "smtp.example.org:" + strconv.Itoa(test.expected)
+		if address := email.address(); address != expected {
+			t.Errorf("address with %s on port %d is %s, expected %s", test.security, test.port, address, expected)
+		}
+	}
+}
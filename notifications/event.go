@@ -0,0 +1,135 @@
+package notifications
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+type Severity int
+
+const (
+	SeverityInfo Severity = iota
+	SeveritySuccess
+	SeverityWarning
+	SeverityError
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeveritySuccess:
+		return "success"
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	default:
+		return "info"
+	}
+}
+
+// ParseSeverity converts a severity name as used in configuration back into
+// a Severity.
+func ParseSeverity(name string) (Severity, error) {
+	for _, s := range []Severity{SeverityInfo, SeveritySuccess, SeverityWarning, SeverityError} {
+		if strings.EqualFold(name, s.String()) {
+			return s, nil
+		}
+	}
+	return SeverityInfo, fmt.Errorf("unknown severity %q; expected one of info, success, warning or error", name)
+}
+
+type EventType string
+
+const (
+	EventNewBuild       EventType = "new_build"
+	EventSyncComplete   EventType = "sync_complete"
+	EventSyncFailed     EventType = "sync_failed"
+	EventDownloadFailed EventType = "download_failed"
+	EventDiskFull       EventType = "disk_full"
+	EventDnsFailure     EventType = "dns_failure"
+	EventHtmlResponse   EventType = "html_response"
+	EventApiError       EventType = "api_error"
+	EventResolved       EventType = "resolved"
+	EventSuppressed     EventType = "suppressed"
+	EventJobDiscovered  EventType = "job_discovered"
+	// EventUnverified is raised when a build's fingerprints can't be fetched,
+	// so its downloads can't be checked against them
+	EventUnverified EventType = "unverified_downloads"
+)
+
+var EventTypes = []EventType{
+	EventNewBuild,
+	EventSyncComplete,
+	EventSyncFailed,
+	EventDownloadFailed,
+	EventDiskFull,
+	EventDnsFailure,
+	EventHtmlResponse,
+	EventApiError,
+	EventResolved,
+	EventSuppressed,
+	EventJobDiscovered,
+	EventUnverified,
+}
+
+// ParseEventType validates an event type name as used in configuration.
+func ParseEventType(name string) (EventType, error) {
+	for _, t := range EventTypes {
+		if strings.EqualFold(name, string(t)) {
+			return t, nil
+		}
+	}
+	return "", fmt.Errorf("unknown event type %q", name)
+}
+
+// Event is a single notification from the tracker. Text is always populated
+// and is what plain-text notifiers send; the remaining fields are optional
+// context that richer notifiers can use.
+type Event struct {
+	Type          EventType
+	Severity      Severity
+	Text          string
+	Job           string
+	JobPath       string
+	BuildNumber   int32
+	BuildUrl      string
+	ArtifactCount int
+	ArtifactBytes int64
+	// SavedBytes is the size of the artifacts reused from earlier builds
+	// rather than downloaded
+	SavedBytes int64
+	Duration   time.Duration
+	MirrorUrl  string
+	// Commit and Branch are what the build was built from, if it was built
+	// from git, and StartedBy is the user or upstream build which started it.
+	Commit    string
+	Branch    string
+	StartedBy string
+	// Thread groups related events (eg. the detection and completion of one
+	// build) so notifiers that support it can present them together.
+	Thread string
+	// Summary describes the events a suppressed event summarizes.
+	Summary *Summary `json:"-"`
+	// Resolves is the event which raised the problem a resolved event clears,
+	// so the resolution can be routed wherever the problem was.
+	Resolves *Event `json:"-"`
+	// Condition identifies an ongoing problem. Repeats of an active condition
+	// for the same job are suppressed until an EventResolved event with the
+	// same condition clears it.
+	Condition string
+}
+
+// FormatBytes renders a byte count in human-readable binary units.
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
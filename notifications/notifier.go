@@ -1,5 +1,21 @@
 package notifications
 
+import "context"
+
 type Notifier interface {
-	Post(string) error
+	Post(*Event) error
+}
+
+// ContextNotifier is a Notifier which can give up on a delivery once a context
+// is done, instead of having to be abandoned while it's still running.
+type ContextNotifier interface {
+	Notifier
+	PostContext(context.Context, *Event) error
+}
+
+// NotifierFunc adapts an ordinary function to the Notifier interface.
+type NotifierFunc func(*Event) error
+
+func (f NotifierFunc) Post(event *Event) error {
+	return f(event)
 }
@@ -0,0 +1,297 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pakohler/jenkronize/logging"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type queuedEvent struct {
+	event     *Event
+	spoolFile string
+}
+
+// Queue delivers events to another Notifier asynchronously from a bounded
+// buffer, so a slow or unreachable notification service can't hold up the
+// tracker. Each delivery attempt is subject to a timeout and failed deliveries
+// are retried with a growing delay. If a spool directory is set, every event
+// is written to disk until it has been delivered, and anything left over is
+// re-queued by Start, so undelivered notifications survive restarts.
+type Queue struct {
+	name         string
+	next         Notifier
+	events       chan *queuedEvent
+	timeout      time.Duration
+	flushTimeout time.Duration
+	retries      int
+	backoff      time.Duration
+	spoolDir     string
+	seq          uint64
+	closed       bool
+	ctx          context.Context
+	cancel       context.CancelFunc
+	mux          sync.RWMutex
+	wg           sync.WaitGroup
+	log          *logging.Logger
+}
+
+func NewQueue(name string, next Notifier, size int) *Queue {
+	if size < 1 {
+		size = 1
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Queue{
+		name:         name,
+		next:         next,
+		events:       make(chan *queuedEvent, size),
+		timeout:      30 * time.Second,
+		flushTimeout: 30 * time.Second,
+		retries:      3,
+		backoff:      5 * time.Second,
+		ctx:          ctx,
+		cancel:       cancel,
+		log:          logging.GetPackageLogger("notifications"),
+	}
+}
+
+// SetTimeout limits how long a single delivery attempt may take. Only a
+// ContextNotifier can be stopped once the timeout has passed; any other
+// Notifier has to time out by itself.
+func (q *Queue) SetTimeout(timeout time.Duration) *Queue {
+	q.timeout = timeout
+	return q
+}
+
+// SetFlushTimeout limits how long Close spends delivering the events which are
+// still queued. A timeout of zero or less waits for all of them.
+func (q *Queue) SetFlushTimeout(timeout time.Duration) *Queue {
+	q.flushTimeout = timeout
+	return q
+}
+
+// SetRetries sets how many times a failed delivery is retried, and the delay
+// before the first retry; the delay doubles with each further attempt.
+func (q *Queue) SetRetries(retries int, backoff time.Duration) *Queue {
+	q.retries = retries
+	q.backoff = backoff
+	return q
+}
+
+// SetSpoolDir enables spooling of undelivered events to the given directory.
+func (q *Queue) SetSpoolDir(dir string) *Queue {
+	q.spoolDir = dir
+	return q
+}
+
+// Start re-queues any events spooled by a previous run and starts the worker
+// delivering events.
+func (q *Queue) Start() *Queue {
+	if q.spoolDir != "" {
+		if err := os.MkdirAll(q.spoolDir, 0700); err != nil {
+			q.log.Error.Printf("unable to create notification spool dir %s: %v", q.spoolDir, err)
+			q.spoolDir = ""
+		} else {
+			q.loadSpool()
+		}
+	}
+	q.wg.Add(1)
+	go q.work()
+	return q
+}
+
+// Close stops accepting events and waits for the queued ones to be delivered.
+// Once the flush timeout has passed, the delivery in progress is stopped and
+// whatever is left stays in the spool for the next run.
+func (q *Queue) Close() error {
+	q.mux.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.events)
+	}
+	q.mux.Unlock()
+	ctx := context.Background()
+	if q.flushTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, q.flushTimeout)
+		defer cancel()
+	}
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		q.cancel()
+		<-done
+	}
+	q.cancel()
+	switch closer := q.next.(type) {
+	case interface {
+		CloseContext(context.Context) error
+	}:
+		return closer.CloseContext(ctx)
+	case interface{ Close() error }:
+		return closer.Close()
+	}
+	return nil
+}
+
+func (q *Queue) Post(event *Event) error {
+	// satisfy the Notifier interface; the actual delivery happens in the worker
+	q.mux.RLock()
+	defer q.mux.RUnlock()
+	if q.closed {
+		return fmt.Errorf("%s notification queue is closed; notification dropped: %s", q.name, event.Text)
+	}
+	qe := &queuedEvent{event: event}
+	if q.spoolDir != "" {
+		file, err := q.spool(event)
+		if err != nil {
+			q.log.Error.Printf("unable to spool %s notification: %v", q.name, err)
+		}
+		qe.spoolFile = file
+	}
+	select {
+	case q.events <- qe:
+		return nil
+	default:
+		if qe.spoolFile != "" {
+			return fmt.Errorf("%s notification queue is full; notification was spooled and will be sent after a restart", q.name)
+		}
+		return fmt.Errorf("%s notification queue is full; notification dropped: %s", q.name, event.Text)
+	}
+}
+
+func (q *Queue) work() {
+	defer q.wg.Done()
+	left := 0
+	defer func() {
+		if left == 0 {
+			return
+		}
+		if q.spoolDir != "" {
+			q.log.Warn.Printf("%d %s notifications weren't delivered before the flush timeout; they will be sent after a restart", left, q.name)
+		} else {
+			q.log.Error.Printf("%d %s notifications weren't delivered before the flush timeout and were dropped", left, q.name)
+		}
+	}()
+	for qe := range q.events {
+		if q.ctx.Err() != nil {
+			left++
+			continue
+		}
+		err := q.deliver(qe.event)
+		if err != nil && q.ctx.Err() != nil {
+			left++
+			continue
+		}
+		if err != nil {
+			q.log.Error.Printf("giving up on %s notification after %d attempts: %v", q.name, q.retries+1, err)
+			// leave the spool file in place so it's retried after a restart
+			continue
+		}
+		if qe.spoolFile != "" {
+			if err := os.Remove(qe.spoolFile); err != nil {
+				q.log.Error.Printf("unable to remove spooled notification %s: %v", qe.spoolFile, err)
+			}
+		}
+	}
+}
+
+func (q *Queue) deliver(event *Event) error {
+	var err error
+	delay := q.backoff
+	for attempt := 0; attempt <= q.retries; attempt++ {
+		if attempt > 0 {
+			q.log.Warn.Printf("%s notification failed (%v); retrying in %s", q.name, err, delay)
+			select {
+			case <-time.After(delay):
+			case <-q.ctx.Done():
+				return err
+			}
+			delay *= 2
+		}
+		err = q.attempt(event)
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (q *Queue) attempt(event *Event) error {
+	next, ok := q.next.(ContextNotifier)
+	if !ok {
+		return q.next.Post(event)
+	}
+	ctx := q.ctx
+	if q.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, q.timeout)
+		defer cancel()
+	}
+	return next.PostContext(ctx, event)
+}
+
+func (q *Queue) spool(event *Event) (string, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return "", err
+	}
+	// name files so that sorting them restores the order they were queued in
+	name := fmt.Sprintf("%020d-%06d.json", time.Now().UnixNano(), atomic.AddUint64(&q.seq, 1)%1000000)
+	file := filepath.Join(q.spoolDir, name)
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		return "", err
+	}
+	return file, nil
+}
+
+func (q *Queue) loadSpool() {
+	items, err := ioutil.ReadDir(q.spoolDir)
+	if err != nil {
+		q.log.Error.Printf("unable to read notification spool dir %s: %v", q.spoolDir, err)
+		return
+	}
+	names := []string{}
+	for _, item := range items {
+		if !item.IsDir() && strings.HasSuffix(item.Name(), ".json") {
+			names = append(names, item.Name())
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		file := filepath.Join(q.spoolDir, name)
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			q.log.Error.Printf("unable to read spooled notification %s: %v", file, err)
+			continue
+		}
+		event := &Event{}
+		if err := json.Unmarshal(data, event); err != nil {
+			q.log.Error.Printf("discarding unreadable spooled notification %s: %v", file, err)
+			os.Remove(file)
+			continue
+		}
+		select {
+		case q.events <- &queuedEvent{event: event, spoolFile: file}:
+		default:
+			q.log.Warn.Printf("%s notification queue is full; the remaining spooled notifications will be sent after the next restart", q.name)
+			return
+		}
+	}
+	if len(names) > 0 {
+		q.log.Info.Printf("re-queued %d undelivered %s notifications", len(names), q.name)
+	}
+}
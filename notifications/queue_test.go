@@ -0,0 +1,183 @@
+package notifications
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// recorder is a Notifier which records the events it's posted. The given
+// number of posts fail before any succeed.
+type recorder struct {
+	failures int
+	events   []*Event
+	mux      sync.Mutex
+}
+
+func (r *recorder) Post(event *Event) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.failures > 0 {
+		r.failures--
+		return fmt.Errorf("unavailable")
+	}
+	r.events = append(r.events, event)
+	return nil
+}
+
+func (r *recorder) texts() []string {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	texts := []string{}
+	for _, event := range r.events {
+		texts = append(texts, event.Text)
+	}
+	return texts
+}
+
+// hanging is a ContextNotifier which never delivers anything; each post waits
+// until its context is done.
+type hanging struct {
+	posts  int32
+	active int32
+}
+
+func (h *hanging) Post(event *Event) error {
+	return h.PostContext(context.Background(), event)
+}
+
+func (h *hanging) PostContext(ctx context.Context, event *Event) error {
+	atomic.AddInt32(&h.posts, 1)
+	atomic.AddInt32(&h.active, 1)
+	defer atomic.AddInt32(&h.active, -1)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "jenkronize-notifications")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func spooled(t *testing.T, dir string) int {
+	items, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(items)
+}
+
+func TestQueueRetries(t *testing.T) {
+	next := &recorder{failures: 2}
+	queue := NewQueue("test", next, 10).SetRetries(2, time.Millisecond).Start()
+	if err := queue.Post(&Event{Text: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := queue.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if texts := next.texts(); len(texts) != 1 || texts[0] != "hello" {
+		t.Errorf("delivered %v", texts)
+	}
+}
+
+func TestQueueSpool(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	// undeliverable events stay spooled
+	failing := &recorder{failures: 100}
+	queue := NewQueue("test", failing, 10).SetRetries(0, 0).SetSpoolDir(dir).Start()
+	for _, text := range []string{"first", "second", "third"} {
+		if err := queue.Post(&Event{Text: text, BuildNumber: 42}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := queue.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := spooled(t, dir); n != 3 {
+		t.Fatalf("%d events are spooled, expected 3", n)
+	}
+
+	// and are delivered in order by the next run
+	next := &recorder{}
+	queue = NewQueue("test", next, 10).SetSpoolDir(dir).Start()
+	if err := queue.Close(); err != nil {
+		t.Fatal(err)
+	}
+	texts := next.texts()
+	if fmt.Sprint(texts) != "[first second third]" {
+		t.Errorf("delivered %v", texts)
+	}
+	if len(next.events) > 0 && next.events[0].BuildNumber != 42 {
+		t.Errorf("the spooled event lost its build number")
+	}
+	if n := spooled(t, dir); n != 0 {
+		t.Errorf("%d events are still spooled after being delivered", n)
+	}
+}
+
+func TestQueueClosed(t *testing.T) {
+	queue := NewQueue("test", &recorder{}, 1).Start()
+	queue.Close()
+	if err := queue.Post(&Event{Text: "late"}); err == nil {
+		t.Error("posting to a closed queue succeeded")
+	}
+}
+
+func TestQueueTimeout(t *testing.T) {
+	next := &hanging{}
+	queue := NewQueue("test", next, 10).SetTimeout(10*time.Millisecond).SetRetries(1, time.Millisecond).Start()
+	if err := queue.Post(&Event{Text: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := queue.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// each timed out attempt was stopped before the next one started
+	if posts := atomic.LoadInt32(&next.posts); posts != 2 {
+		t.Errorf("the notifier was posted to %d times, expected 2", posts)
+	}
+	if active := atomic.LoadInt32(&next.active); active != 0 {
+		t.Errorf("%d posts are still running", active)
+	}
+}
+
+func TestQueueFlushTimeout(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	next := &hanging{}
+	queue := NewQueue("test", next, 10).
+		SetTimeout(0).
+		SetFlushTimeout(20 * time.Millisecond).
+		SetSpoolDir(dir).
+		Start()
+	for _, text := range []string{"first", "second", "third"} {
+		if err := queue.Post(&Event{Text: text}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Now()
+	if err := queue.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Close took %s", elapsed)
+	}
+	if active := atomic.LoadInt32(&next.active); active != 0 {
+		t.Errorf("%d posts are still running", active)
+	}
+	// everything that wasn't delivered is left for the next run
+	if n := spooled(t, dir); n != 3 {
+		t.Errorf("%d events are spooled, expected 3", n)
+	}
+}
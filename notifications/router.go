@@ -0,0 +1,171 @@
+package notifications
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Route sends matching events to the named notifiers. Empty criteria match
+// everything, so a route with only Notifiers set receives every event.
+type Route struct {
+	Notifiers   []string
+	Jobs        []string
+	Events      []EventType
+	Severities  []Severity
+	MinSeverity Severity
+}
+
+// Matches reports whether the event should be sent along the route. A resolved
+// event is also sent along any route the problem it resolves was, whatever
+// its own severity.
+func (r *Route) Matches(event *Event) bool {
+	if event.Type == EventResolved && event.Resolves != nil && r.Matches(event.Resolves) {
+		return true
+	}
+	if len(r.Jobs) > 0 {
+		found := false
+		for _, job := range r.Jobs {
+			if job == event.Job || sameJobPath(job, event.JobPath) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(r.Events) > 0 {
+		found := false
+		for _, t := range r.Events {
+			if t == event.Type {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(r.Severities) > 0 {
+		found := false
+		for _, s := range r.Severities {
+			if s == event.Severity {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return event.Severity >= r.MinSeverity
+}
+
+// sameJobPath reports whether two job paths name the same job; Jenkins job
+// paths aren't case sensitive, and may or may not end in a slash.
+func sameJobPath(a string, b string) bool {
+	return strings.EqualFold(strings.TrimRight(a, "/"), strings.TrimRight(b, "/"))
+}
+
+// Router is a Notifier which dispatches each event to the named notifiers
+// selected by its routes. Without any routes, every event goes to every
+// notifier.
+type Router struct {
+	notifiers map[string]Notifier
+	names     []string
+	routes    []*Route
+}
+
+func NewRouter() *Router {
+	return &Router{
+		notifiers: map[string]Notifier{},
+	}
+}
+
+func (r *Router) AddNotifier(name string, n Notifier) error {
+	if _, ok := r.notifiers[name]; ok {
+		return fmt.Errorf("a notifier named %q is already defined", name)
+	}
+	r.notifiers[name] = n
+	r.names = append(r.names, name)
+	return nil
+}
+
+func (r *Router) AddRoute(route *Route) error {
+	if len(route.Notifiers) == 0 {
+		return fmt.Errorf("route does not specify any notifiers")
+	}
+	for _, name := range route.Notifiers {
+		if _, ok := r.notifiers[name]; !ok {
+			return fmt.Errorf("route refers to unknown notifier %q", name)
+		}
+	}
+	r.routes = append(r.routes, route)
+	return nil
+}
+
+// Targets returns the names of the notifiers an event would be sent to.
+func (r *Router) Targets(event *Event) []string {
+	if len(r.routes) == 0 {
+		return r.names
+	}
+	selected := map[string]bool{}
+	for _, route := range r.routes {
+		if route.Matches(event) {
+			for _, name := range route.Notifiers {
+				selected[name] = true
+			}
+		}
+	}
+	targets := []string{}
+	// keep the order the notifiers were defined in
+	for _, name := range r.names {
+		if selected[name] {
+			targets = append(targets, name)
+		}
+	}
+	return targets
+}
+
+func (r *Router) Post(event *Event) error {
+	// satisfy the Notifier interface and fan out to the selected notifiers
+	failures := []string{}
+	for _, name := range r.Targets(event) {
+		if err := r.notifiers[name].Post(event); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("notification failed for %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// Close closes every notifier which needs it, eg. to flush queued or digested
+// notifications before exiting. The notifiers are closed at the same time, so
+// their flush timeouts don't add up.
+func (r *Router) Close() error {
+	errs := make([]error, len(r.names))
+	var wg sync.WaitGroup
+	for i, name := range r.names {
+		if closer, ok := r.notifiers[name].(interface{ Close() error }); ok {
+			wg.Add(1)
+			go func(i int, closer interface{ Close() error }) {
+				defer wg.Done()
+				errs[i] = closer.Close()
+			}(i, closer)
+		}
+	}
+	wg.Wait()
+	failures := []string{}
+	for i, err := range errs {
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", r.names[i], err))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("closing notifiers failed for %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
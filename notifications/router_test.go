@@ -0,0 +1,78 @@
+package notifications
+
+import (
+	"fmt"
+	"testing"
+)
+
+func newTestRouter(t *testing.T, routes ...*Route) *Router {
+	router := NewRouter()
+	for _, name := range []string{"ops", "installer"} {
+		if err := router.AddNotifier(name, &recorder{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, route := range routes {
+		if err := router.AddRoute(route); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return router
+}
+
+func TestRouterTargets(t *testing.T) {
+	router := newTestRouter(t,
+		&Route{Notifiers: []string{"ops"}, MinSeverity: SeverityError},
+		&Route{Notifiers: []string{"installer"}, Jobs: []string{"installer"}, Severities: []Severity{SeveritySuccess}},
+	)
+	tests := []struct {
+		event    *Event
+		expected string
+	}{
+		{&Event{Type: EventSyncComplete, Severity: SeveritySuccess, Job: "installer", JobPath: "/job/installer"}, "[installer]"},
+		{&Event{Type: EventSyncComplete, Severity: SeveritySuccess, Job: "ui", JobPath: "/job/ui"}, "[]"},
+		{&Event{Type: EventSyncFailed, Severity: SeverityError, Job: "installer", JobPath: "/job/installer"}, "[ops]"},
+		{&Event{Type: EventNewBuild, Severity: SeverityInfo, Job: "installer", JobPath: "/job/installer"}, "[]"},
+	}
+	for _, test := range tests {
+		if targets := fmt.Sprint(router.Targets(test.event)); targets != test.expected {
+			t.Errorf("%s %s of %s went to %s, expected %s", test.event.Severity, test.event.Type, test.event.Job, targets, test.expected)
+		}
+	}
+}
+
+func TestRouteJobPaths(t *testing.T) {
+	route := &Route{Notifiers: []string{"ops"}, Jobs: []string{"/job/Team/job/Installer/"}}
+	for _, jobPath := range []string{"/job/Team/job/Installer", "/job/team/job/installer", "/job/Team/job/Installer/"} {
+		if !route.Matches(&Event{Job: "installer", JobPath: jobPath}) {
+			t.Errorf("the route doesn't match %s", jobPath)
+		}
+	}
+	if route.Matches(&Event{Job: "other", JobPath: "/job/Team/job/Other"}) {
+		t.Error("the route matches another job")
+	}
+}
+
+func TestRouterResolved(t *testing.T) {
+	router := newTestRouter(t, &Route{Notifiers: []string{"ops"}, MinSeverity: SeverityError})
+	alerts := NewAlerts(router)
+	failure := &Event{Type: EventApiError, Severity: SeverityError, JobPath: "/job/installer", Condition: string(EventApiError)}
+	resolved := &Event{Type: EventResolved, Severity: SeveritySuccess, JobPath: "/job/installer", Condition: string(EventApiError)}
+	if err := alerts.Post(failure); err != nil {
+		t.Fatal(err)
+	}
+	if err := alerts.Post(resolved); err != nil {
+		t.Fatal(err)
+	}
+	events := router.notifiers["ops"].(*recorder).events
+	if len(events) != 2 || events[1] != resolved {
+		t.Errorf("ops received %d events; the resolution of its error should have been sent to it", len(events))
+	}
+	// a resolution of something which wasn't sent along the route isn't either
+	warning := &Event{Type: EventHtmlResponse, Severity: SeverityWarning, JobPath: "/job/installer", Condition: string(EventHtmlResponse)}
+	alerts.Post(warning)
+	alerts.Post(&Event{Type: EventResolved, Severity: SeveritySuccess, JobPath: "/job/installer", Condition: string(EventHtmlResponse)})
+	if n := len(router.notifiers["ops"].(*recorder).events); n != 2 {
+		t.Errorf("ops received %d events, expected 2", n)
+	}
+}
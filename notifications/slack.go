@@ -2,53 +2,219 @@ package notifications
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 )
 
+const slackPostMessageUrl = "https://slack.com/api/chat.postMessage"
+
+// slackThreadTTL is how long a detection message is kept to thread under. The
+// completion message usually removes it sooner, but may never be posted, eg.
+// if it's suppressed by the rate limit.
+const slackThreadTTL = 24 * time.Hour
+
+// slackEscaper escapes the characters Slack treats as markup in mrkdwn text,
+// so they're shown as they are rather than as links or mentions.
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+var slackColors = map[Severity]string{
+	SeverityInfo:    "#439fe0",
+	SeveritySuccess: "#2eb886",
+	SeverityWarning: "#daa038",
+	SeverityError:   "#a30200",
+}
+
+// slackThread is the detection message events for the same build are threaded
+// under.
+type slackThread struct {
+	ts      string
+	started time.Time
+}
+
 type Slack struct {
 	client  *http.Client
 	webhook string
+	token   string
 	channel string
+	apiUrl  string
+	threads map[string]slackThread
+	mux     sync.Mutex
 }
 
 func NewSlackNotifier(webHook string) *Slack {
 	s := &Slack{
-		client:  &http.Client{},
+		client:  &http.Client{Timeout: 30 * time.Second},
 		webhook: webHook,
+		apiUrl:  slackPostMessageUrl,
+		threads: map[string]slackThread{},
 	}
 	return s
 }
 
+func (s *Slack) SetTimeout(timeout time.Duration) *Slack {
+	s.client.Timeout = timeout
+	return s
+}
+
 func (s *Slack) SetWebhook(newHook string) *Slack {
 	s.webhook = newHook
 	return s
 }
 
+// SetToken configures a bot token. When set, messages are sent through the
+// chat.postMessage API instead of the webhook, which allows completion
+// messages to be threaded under the matching detection message.
+func (s *Slack) SetToken(newToken string) *Slack {
+	s.token = newToken
+	return s
+}
+
 func (s *Slack) SetChannel(newChannel string) *Slack {
 	s.channel = newChannel
 	return s
 }
 
-func (s *Slack) Post(msg string) error {
+func (s *Slack) Post(event *Event) error {
 	// satisfy the Notifier interface and post to Slack
-	if s.webhook == "" {
-		return fmt.Errorf("Slack notification impossible; no webhook specified")
+	return s.PostContext(context.Background(), event)
+}
+
+// PostContext posts to Slack, giving up on the request once ctx is done.
+func (s *Slack) PostContext(ctx context.Context, event *Event) error {
+	if s.webhook == "" && s.token == "" {
+		return fmt.Errorf("Slack notification impossible; no webhook or token specified")
+	}
+	if s.token != "" && s.channel == "" {
+		return fmt.Errorf("Slack notification impossible; a channel is required when using a token")
+	}
+	jsonMap := s.buildMessage(event)
+	if s.token == "" {
+		return s.postWebhook(ctx, jsonMap)
+	}
+	s.mux.Lock()
+	s.expireThreads()
+	parent, threaded := s.threads[event.Thread]
+	s.mux.Unlock()
+	if event.Thread != "" && threaded {
+		jsonMap["thread_ts"] = parent.ts
+	}
+	ts, err := s.postApi(ctx, jsonMap)
+	if err != nil {
+		return err
+	}
+	if event.Thread != "" {
+		s.mux.Lock()
+		if event.Type == EventSyncComplete || event.Type == EventSyncFailed {
+			// the build has reached a final state; nothing else will be threaded under it
+			delete(s.threads, event.Thread)
+		} else if !threaded && event.Type == EventNewBuild {
+			s.threads[event.Thread] = slackThread{ts: ts, started: time.Now()}
+		}
+		s.mux.Unlock()
+	}
+	return nil
+}
+
+// expireThreads forgets the detection messages which are too old to thread
+// under; it must be called with the mutex held.
+func (s *Slack) expireThreads() {
+	for thread, parent := range s.threads {
+		if time.Since(parent.started) > slackThreadTTL {
+			delete(s.threads, thread)
+		}
+	}
+}
+
+func (s *Slack) buildMessage(event *Event) map[string]interface{} {
+	// the text, including any error in it, and the job's alias are free
+	// text, so they're escaped in case they contain anything Slack would
+	// read as markup
+	text := slackEscaper.Replace(event.Text)
+	headline := text
+	if event.BuildUrl != "" && event.BuildNumber > 0 {
+		headline = fmt.Sprintf("*<%s|%s #%d>*\n%s", event.BuildUrl, slackEscaper.Replace(event.Job), event.BuildNumber, text)
+	}
+	blocks := []map[string]interface{}{
+		{
+			"type": "section",
+			"text": map[string]string{"type": "mrkdwn", "text": headline},
+		},
+	}
+	fields := []map[string]string{}
+	if event.ArtifactCount > 0 {
+		text := fmt.Sprintf("*Artifacts*\n%d (%s)", event.ArtifactCount, FormatBytes(event.ArtifactBytes))
+		if event.SavedBytes > 0 {
+			text += fmt.Sprintf(", %s reused", FormatBytes(event.SavedBytes))
+		}
+		fields = append(fields, map[string]string{
+			"type": "mrkdwn",
+			"text": text,
+		})
+	}
+	if event.Duration > 0 {
+		fields = append(fields, map[string]string{
+			"type": "mrkdwn",
+			"text": fmt.Sprintf("*Sync duration*\n%s", event.Duration.Round(time.Second)),
+		})
+	}
+	if event.Commit != "" {
+		text := fmt.Sprintf("*Commit*\n`%.7s`", event.Commit)
+		if event.Branch != "" {
+			text += " on " + slackEscaper.Replace(event.Branch)
+		}
+		fields = append(fields, map[string]string{"type": "mrkdwn", "text": text})
+	}
+	if event.StartedBy != "" {
+		fields = append(fields, map[string]string{
+			"type": "mrkdwn",
+			"text": fmt.Sprintf("*Started by*\n%s", slackEscaper.Replace(event.StartedBy)),
+		})
+	}
+	if event.MirrorUrl != "" {
+		fields = append(fields, map[string]string{
+			"type": "mrkdwn",
+			"text": fmt.Sprintf("*Mirror*\n<%s|%s>", event.MirrorUrl, event.MirrorUrl),
+		})
+	}
+	if len(fields) > 0 {
+		blocks = append(blocks, map[string]interface{}{
+			"type":   "section",
+			"fields": fields,
+		})
 	}
 	jsonMap := map[string]interface{}{
-		"text": msg,
+		// `text` is the fallback used for push notifications and old clients
+		"text": text,
+		"attachments": []map[string]interface{}{
+			{
+				"color":  slackColors[event.Severity],
+				"blocks": blocks,
+			},
+		},
 	}
 	if s.channel != "" {
 		jsonMap["channel"] = s.channel
 	}
+	return jsonMap
+}
+
+func (s *Slack) postWebhook(ctx context.Context, jsonMap map[string]interface{}) error {
 	data, err := json.Marshal(jsonMap)
 	if err != nil {
 		return err
 	}
-	reader := bytes.NewReader(data)
-	resp, err := s.client.Post(s.webhook, "application/json", reader)
+	req, err := http.NewRequest("POST", s.webhook, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req.WithContext(ctx))
 	if err != nil {
 		return err
 	}
@@ -64,3 +230,41 @@ func (s *Slack) Post(msg string) error {
 	}
 	return nil
 }
+
+func (s *Slack) postApi(ctx context.Context, jsonMap map[string]interface{}) (string, error) {
+	data, err := json.Marshal(jsonMap)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest("POST", s.apiUrl, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Authorization", "Bearer "+s.token)
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	response, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode >= 400 {
+		return "", fmt.Errorf(
+			"Slack notification POST reseponse status was %s; response text was: %s",
+			resp.Status,
+			string(response),
+		)
+	}
+	var result struct {
+		Ok    bool   `json:"ok"`
+		Error string `json:"error"`
+		Ts    string `json:"ts"`
+	}
+	if err := json.Unmarshal(response, &result); err != nil {
+		return "", fmt.Errorf("Slack notification response could not be parsed: %v", err)
+	}
+	if !result.Ok {
+		return "", fmt.Errorf("Slack notification was rejected: %s", result.Error)
+	}
+	return result.Ts, nil
+}
@@ -0,0 +1,187 @@
+package notifications
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSlack is a stand-in for the Slack API which records the messages posted
+// to it, and gives each one a timestamp.
+type fakeSlack struct {
+	server   *httptest.Server
+	messages []map[string]interface{}
+	mux      sync.Mutex
+}
+
+func newFakeSlack(t *testing.T) *fakeSlack {
+	s := &fakeSlack{}
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		message := map[string]interface{}{}
+		if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+			t.Errorf("the message isn't JSON: %v", err)
+		}
+		s.mux.Lock()
+		s.messages = append(s.messages, message)
+		ts := fmt.Sprintf("%d.000100", len(s.messages))
+		s.mux.Unlock()
+		fmt.Fprintf(w, `{"ok": true, "ts": %q}`, ts)
+	}))
+	return s
+}
+
+func (s *fakeSlack) message(t *testing.T, i int) map[string]interface{} {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if i >= len(s.messages) {
+		t.Fatalf("only %d messages were posted", len(s.messages))
+	}
+	return s.messages[i]
+}
+
+func TestSlackBuildMessage(t *testing.T) {
+	slack := NewSlackNotifier("https://hooks.slack.example.org/x").SetChannel("#builds")
+	message := slack.buildMessage(&Event{
+		Type:          EventSyncComplete,
+		Severity:      SeveritySuccess,
+		Text:          "download of <build> failed: a & b > c",
+		Job:           "foo <bar>",
+		BuildNumber:   42,
+		BuildUrl:      "https://jenkins.example.org/job/foo/42/",
+		ArtifactCount: 3,
+		ArtifactBytes: 2048,
+		SavedBytes:    1024,
+		Duration:      90 * time.Second,
+		Commit:        "0123456789abcdef",
+		Branch:        "feature/<x>",
+		StartedBy:     "Jane & John",
+		MirrorUrl:     "https://mirror.example.org/foo/42/",
+	})
+	data, err := json.Marshal(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payload struct {
+		Text        string
+		Channel     string
+		Attachments []struct {
+			Color  string
+			Blocks []struct {
+				Type   string
+				Text   struct{ Type, Text string }
+				Fields []struct{ Type, Text string }
+			}
+		}
+	}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Text != "download of &lt;build&gt; failed: a &amp; b &gt; c" {
+		t.Errorf("the fallback text is %q", payload.Text)
+	}
+	if payload.Channel != "#builds" {
+		t.Errorf("the channel is %q", payload.Channel)
+	}
+	if len(payload.Attachments) != 1 || len(payload.Attachments[0].Blocks) != 2 {
+		t.Fatalf("the message is laid out as %s", data)
+	}
+	attachment := payload.Attachments[0]
+	if attachment.Color != slackColors[SeveritySuccess] {
+		t.Errorf("the color is %q", attachment.Color)
+	}
+	headline := attachment.Blocks[0].Text
+	expected := "*<https://jenkins.example.org/job/foo/42/|foo &lt;bar&gt; #42>*\n" + payload.Text
+	if headline.Type != "mrkdwn" || headline.Text != expected {
+		t.Errorf("the headline is %q", headline.Text)
+	}
+	fields := []string{}
+	for _, field := range attachment.Blocks[1].Fields {
+		fields = append(fields, field.Text)
+	}
+	expectedFields := []string{
+		"*Artifacts*\n3 (2.0 KiB), 1.0 KiB reused",
+		"*Sync duration*\n1m30s",
+		"*Commit*\n`0123456` on feature/&lt;x&gt;",
+		"*Started by*\nJane &amp; John",
+		"*Mirror*\n<https://mirror.example.org/foo/42/|https://mirror.example.org/foo/42/>",
+	}
+	if strings.Join(fields, "|") != strings.Join(expectedFields, "|") {
+		t.Errorf("the fields are %q", fields)
+	}
+
+	// without any details, there's just the text
+	message = slack.buildMessage(&Event{Text: "hello"})
+	blocks := message["attachments"].([]map[string]interface{})[0]["blocks"].([]map[string]interface{})
+	if len(blocks) != 1 {
+		t.Errorf("a plain message has %d blocks", len(blocks))
+	}
+}
+
+func TestSlackThreading(t *testing.T) {
+	api := newFakeSlack(t)
+	defer api.server.Close()
+	slack := NewSlackNotifier("").SetToken("xoxb-test").SetChannel("#builds")
+	slack.apiUrl = api.server.URL
+	post := func(eventType EventType, thread string) {
+		if err := slack.Post(&Event{Type: eventType, Text: string(eventType), Thread: thread}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the completion of a build is threaded under its detection
+	post(EventNewBuild, "foo#1")
+	post(EventSyncComplete, "foo#1")
+	if _, ok := api.message(t, 0)["thread_ts"]; ok {
+		t.Error("the detection message was threaded")
+	}
+	if ts := api.message(t, 1)["thread_ts"]; ts != "1.000100" {
+		t.Errorf("the completion message was threaded under %v", ts)
+	}
+	if len(slack.threads) != 0 {
+		t.Errorf("the thread of a completed build is still kept: %v", slack.threads)
+	}
+
+	// a failure is a final state too, even without a detection message
+	post(EventSyncFailed, "foo#2")
+	if len(slack.threads) != 0 {
+		t.Errorf("a failed build left a thread behind: %v", slack.threads)
+	}
+
+	// a build which never completes, eg. because its final message was
+	// rate limited, is forgotten once its thread expires
+	post(EventNewBuild, "foo#3")
+	if _, ok := slack.threads["foo#3"]; !ok {
+		t.Fatal("the detection message wasn't kept to thread under")
+	}
+	thread := slack.threads["foo#3"]
+	thread.started = time.Now().Add(-slackThreadTTL - time.Minute)
+	slack.threads["foo#3"] = thread
+	post(EventNewBuild, "foo#4")
+	if _, ok := slack.threads["foo#3"]; ok {
+		t.Error("the expired thread is still kept")
+	}
+	if _, ok := slack.threads["foo#4"]; !ok {
+		t.Error("the new thread wasn't kept")
+	}
+}
+
+func TestSlackWebhook(t *testing.T) {
+	api := newFakeSlack(t)
+	defer api.server.Close()
+	slack := NewSlackNotifier(api.server.URL)
+	if err := slack.Post(&Event{Type: EventNewBuild, Text: "hello", Thread: "foo#1"}); err != nil {
+		t.Fatal(err)
+	}
+	if text := api.message(t, 0)["text"]; text != "hello" {
+		t.Errorf("the webhook was posted %v", text)
+	}
+	// webhooks can't thread, so nothing is kept for it
+	if len(slack.threads) != 0 {
+		t.Errorf("a webhook kept threads: %v", slack.threads)
+	}
+}
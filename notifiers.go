@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"github.com/pakohler/jenkronize/common"
+	"github.com/pakohler/jenkronize/config"
+	"github.com/pakohler/jenkronize/logging"
+	"github.com/pakohler/jenkronize/notifications"
+	"path/filepath"
+	"time"
+)
+
+func newSlackNotifier(conf *config.SlackConfig) *notifications.Slack {
+	slack := notifications.NewSlackNotifier(conf.Webhook)
+	if conf.Token != "" {
+		slack.SetToken(conf.Token)
+	}
+	if conf.Channel != "" {
+		slack.SetChannel(conf.Channel)
+	}
+	return slack
+}
+
+func newEmailNotifier(conf *config.EmailConfig) *notifications.Email {
+	email := notifications.NewEmailNotifier(
+		conf.Host,
+		conf.Port,
+		conf.From,
+		conf.To,
+	)
+	if conf.Username != "" {
+		email.SetAuth(conf.Username, conf.Password)
+	}
+	if conf.Subject != "" {
+		email.SetSubject(conf.Subject)
+	}
+	if conf.Security != "" {
+		email.SetSecurity(conf.Security)
+	}
+	email.
+		SetInsecureSkipVerify(conf.InsecureSkipVerify).
+		SetDigestWindow(conf.Digest)
+	return email
+}
+
+func newRoute(conf *config.RouteConfig) (*notifications.Route, error) {
+	route := &notifications.Route{
+		Notifiers: conf.Notifiers,
+		Jobs:      conf.Jobs,
+	}
+	for _, name := range conf.Events {
+		eventType, err := notifications.ParseEventType(name)
+		if err != nil {
+			return nil, err
+		}
+		route.Events = append(route.Events, eventType)
+	}
+	for _, name := range conf.Severities {
+		severity, err := notifications.ParseSeverity(name)
+		if err != nil {
+			return nil, err
+		}
+		route.Severities = append(route.Severities, severity)
+	}
+	if conf.MinSeverity != "" {
+		severity, err := notifications.ParseSeverity(conf.MinSeverity)
+		if err != nil {
+			return nil, err
+		}
+		route.MinSeverity = severity
+	}
+	return route, nil
+}
+
+// emailDigestSpool is the file in an email notifier's spool dir which keeps
+// the messages collected for its next digest. It isn't a .json file, so the
+// queue doesn't mistake it for one of its own.
+const emailDigestSpool = "digest.spool"
+
+func newQueue(conf *config.QueueConfig, name string, notifier notifications.Notifier) *notifications.Queue {
+	size := conf.Size
+	if size == 0 {
+		size = 100
+	}
+	queue := notifications.NewQueue(name, notifier, size)
+	if conf.Timeout != 0 {
+		queue.SetTimeout(conf.Timeout)
+	}
+	if conf.FlushTimeout != 0 {
+		queue.SetFlushTimeout(conf.FlushTimeout)
+	}
+	retries := conf.Retries
+	if retries == 0 {
+		retries = 3
+	} else if retries < 0 {
+		retries = 0
+	}
+	backoff := conf.Backoff
+	if backoff == 0 {
+		backoff = 5 * time.Second
+	}
+	queue.SetRetries(retries, backoff)
+	spoolDir := conf.SpoolDir
+	if spoolDir == "" {
+		dir, err := common.GetExeDir()
+		if err != nil {
+			logging.GetLogger().Fatal.Fatal(err)
+		}
+		spoolDir = filepath.Join(dir, "spool")
+	}
+	if spoolDir != "-" {
+		queue.SetSpoolDir(filepath.Join(spoolDir, name))
+		if email, ok := notifier.(*notifications.Email); ok {
+			// an email digest is delivered later than the queue thinks, so
+			// the messages collected for it are spooled by the notifier
+			email.SetDigestSpool(filepath.Join(spoolDir, name, emailDigestSpool))
+		}
+	}
+	return queue.Start()
+}
+
+// newRouter sets up the notifiers and routes from the config. Each notifier is
+// passed through wrap before being added, which is how they get queued. The
+// whole config is checked before any notifier is wrapped, since a queue which
+// is started would be left running if a later notifier or route was invalid.
+func newRouter(conf *config.Config, wrap func(name string, n notifications.Notifier) notifications.Notifier) (*notifications.Router, error) {
+	type namedNotifier struct {
+		name     string
+		notifier notifications.Notifier
+	}
+	notifiers := []namedNotifier{}
+	// the top-level slack and email sections predate named notifiers; they
+	// are still supported and are named after their section.
+	if conf.Slack.Webhook != "" || conf.Slack.Token != "" {
+		notifiers = append(notifiers, namedNotifier{"slack", newSlackNotifier(&conf.Slack)})
+	}
+	if conf.Email.Host != "" {
+		notifiers = append(notifiers, namedNotifier{"email", newEmailNotifier(&conf.Email)})
+	}
+	for i, n := range conf.Notifiers {
+		var notifier notifications.Notifier
+		switch {
+		case n.Name == "":
+			return nil, fmt.Errorf("notifier %d has no name", i+1)
+		case n.Slack != nil && n.Email != nil:
+			return nil, fmt.Errorf("notifier %q must only define one of slack or email", n.Name)
+		case n.Slack != nil:
+			notifier = newSlackNotifier(n.Slack)
+		case n.Email != nil:
+			notifier = newEmailNotifier(n.Email)
+		default:
+			return nil, fmt.Errorf("notifier %q must define either slack or email", n.Name)
+		}
+		notifiers = append(notifiers, namedNotifier{n.Name, notifier})
+	}
+	// the unwrapped notifiers check the names and routes
+	unwrapped := notifications.NewRouter()
+	for _, n := range notifiers {
+		if err := unwrapped.AddNotifier(n.name, n.notifier); err != nil {
+			return nil, err
+		}
+	}
+	routes := []*notifications.Route{}
+	for i := range conf.Routes {
+		route, err := newRoute(&conf.Routes[i])
+		if err != nil {
+			return nil, fmt.Errorf("route %d: %v", i+1, err)
+		}
+		if err := unwrapped.AddRoute(route); err != nil {
+			return nil, fmt.Errorf("route %d: %v", i+1, err)
+		}
+		routes = append(routes, route)
+	}
+
+	// none of which can fail now
+	router := notifications.NewRouter()
+	for _, n := range notifiers {
+		router.AddNotifier(n.name, wrap(n.name, n.notifier))
+	}
+	for _, route := range routes {
+		router.AddRoute(route)
+	}
+	return router, nil
+}
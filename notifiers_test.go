@@ -0,0 +1,56 @@
+package main
+
+import (
+	"github.com/pakohler/jenkronize/config"
+	"github.com/pakohler/jenkronize/notifications"
+	"strings"
+	"testing"
+)
+
+func TestNewRouter(t *testing.T) {
+	slack := &config.SlackConfig{Webhook: "https://hooks.slack.example.org/x"}
+	email := &config.EmailConfig{Host: "smtp.example.org", From: "jenkronize@example.org", To: []string{"ops@example.org"}}
+	tests := []struct {
+		name      string
+		notifiers []config.NotifierConfig
+		routes    []config.RouteConfig
+		err       string
+	}{
+		{"valid", []config.NotifierConfig{{Name: "ops", Slack: slack}, {Name: "dev", Email: email}}, []config.RouteConfig{{Notifiers: []string{"dev"}}}, ""},
+		{"no name", []config.NotifierConfig{{Name: "ops", Slack: slack}, {Email: email}}, nil, "notifier 2 has no name"},
+		{"both", []config.NotifierConfig{{Name: "ops", Slack: slack}, {Name: "dev", Slack: slack, Email: email}}, nil, "only define one"},
+		{"neither", []config.NotifierConfig{{Name: "ops", Slack: slack}, {Name: "dev"}}, nil, "either slack or email"},
+		{"duplicate", []config.NotifierConfig{{Name: "ops", Slack: slack}, {Name: "ops", Email: email}}, nil, "already defined"},
+		{"unknown route", []config.NotifierConfig{{Name: "ops", Slack: slack}}, []config.RouteConfig{{Notifiers: []string{"dev"}}}, "route 1: "},
+		{"bad route", []config.NotifierConfig{{Name: "ops", Slack: slack}}, []config.RouteConfig{{Notifiers: []string{"ops"}, Events: []string{"nonsense"}}}, "route 1: "},
+	}
+	for _, test := range tests {
+		conf := &config.Config{Notifiers: test.notifiers, Routes: test.routes}
+		wrapped := []string{}
+		router, err := newRouter(conf, func(name string, n notifications.Notifier) notifications.Notifier {
+			wrapped = append(wrapped, name)
+			return n
+		})
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("%s: %v", test.name, err)
+				continue
+			}
+			if strings.Join(wrapped, ",") != "ops,dev" {
+				t.Errorf("%s: the wrapped notifiers are %q", test.name, wrapped)
+			}
+			if targets := router.Targets(&notifications.Event{Type: notifications.EventNewBuild}); strings.Join(targets, ",") != "dev" {
+				t.Errorf("%s: the event was routed to %q", test.name, targets)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: returned %v, expected %q", test.name, err, test.err)
+		}
+		// an invalid config doesn't start the queues of the valid notifiers
+		// before it
+		if len(wrapped) > 0 {
+			t.Errorf("%s: wrapped %q before the config was found invalid", test.name, wrapped)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package tracking
+
+import (
+	"fmt"
+	"github.com/pakohler/jenkronize/logging"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jobBlobStoreDir returns the dir of a job's own blob store. It's beside the
+// job's sync dir rather than in it, so it isn't published with the mirror,
+// but still on the same filesystem so builds can be linked to it.
+func jobBlobStoreDir(syncDir string) string {
+	syncDir = filepath.Clean(syncDir)
+	return filepath.Join(filepath.Dir(syncDir), "."+filepath.Base(syncDir)+".blobs")
+}
+
+// BlobStore keeps a single copy of each distinct artifact, named by its
+// SHA-256, which the builds with that artifact are hardlinked to. Each blob is
+// also linked under its MD5, so an artifact Jenkins has fingerprinted can be
+// found without downloading it. Since the builds have links of their own,
+// removing a blob from the store never removes it from a build.
+type BlobStore struct {
+	dir string
+	// pending are the SHA-256s and MD5s of the blobs added for builds whose
+	// manifests may not have been written yet, and when they were added
+	pending map[string]time.Time
+	mux     sync.Mutex
+}
+
+// pendingBlobTTL is how long a blob is kept without a manifest referring to
+// it, eg. because the build it was added for failed to sync.
+const pendingBlobTTL = 24 * time.Hour
+
+func NewBlobStore(dir string) *BlobStore {
+	return &BlobStore{dir: dir, pending: map[string]time.Time{}}
+}
+
+func (s *BlobStore) sha256Path(sum string) string {
+	return filepath.Join(s.dir, "sha256", sum[:2], sum)
+}
+
+func (s *BlobStore) md5Path(sum string) string {
+	return filepath.Join(s.dir, "md5", sum[:2], sum)
+}
+
+// findMD5 returns the path of the blob with the MD5, or an empty string if
+// there isn't one.
+func (s *BlobStore) findMD5(md5 string) string {
+	md5 = strings.ToLower(md5)
+	if len(md5) < 2 {
+		return ""
+	}
+	blob := s.md5Path(md5)
+	if _, err := os.Stat(blob); err != nil {
+		return ""
+	}
+	return blob
+}
+
+// add puts a downloaded file into the store. If an identical blob is already
+// stored, the file is replaced with a link to it, so only one copy is kept;
+// the returned bool reports whether it was.
+func (s *BlobStore) add(filePath string, sha256 string, md5 string) (bool, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	// the build's manifest isn't written until all of its artifacts are, so
+	// keep the blob until collect sees it referenced
+	now := time.Now()
+	s.pending[sha256] = now
+	s.pending[strings.ToLower(md5)] = now
+	blob := s.sha256Path(sha256)
+	if info, err := os.Stat(blob); err == nil {
+		if current, err := os.Stat(filePath); err == nil && os.SameFile(info, current) {
+			return false, nil
+		}
+		if err := linkFile(blob, filePath); err != nil {
+			return false, err
+		}
+		return true, s.linkMD5(blob, md5)
+	}
+	if err := os.MkdirAll(filepath.Dir(blob), 0700); err != nil {
+		return false, err
+	}
+	if err := os.Link(filePath, blob); err != nil && !os.IsExist(err) {
+		// eg. the store is on another filesystem
+		return false, err
+	}
+	return false, s.linkMD5(blob, md5)
+}
+
+func (s *BlobStore) linkMD5(blob string, md5 string) error {
+	md5Path := s.md5Path(strings.ToLower(md5))
+	if err := os.MkdirAll(filepath.Dir(md5Path), 0700); err != nil {
+		return err
+	}
+	return linkFile(blob, md5Path)
+}
+
+// collect removes the blobs which no build refers to any more, given the
+// SHA-256 and MD5 of every artifact of the builds which use the store. Blobs
+// added for builds which are still being synced are kept. It returns the
+// number of blobs and bytes freed.
+func (s *BlobStore) collect(sha256s map[string]bool, md5s map[string]bool) (int, int64, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	now := time.Now()
+	for sum, added := range s.pending {
+		if sha256s[sum] || md5s[sum] || now.Sub(added) > pendingBlobTTL {
+			delete(s.pending, sum)
+		}
+	}
+	removed := 0
+	var freed int64
+	walk := func(kind string, referenced map[string]bool) error {
+		return filepath.Walk(filepath.Join(s.dir, kind), func(blob string, info os.FileInfo, err error) error {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			if err != nil || info.IsDir() || referenced[info.Name()] {
+				return err
+			}
+			if _, ok := s.pending[info.Name()]; ok {
+				return nil
+			}
+			if err := os.Remove(blob); err != nil {
+				return err
+			}
+			if kind == "sha256" {
+				removed++
+				freed += info.Size()
+			}
+			return nil
+		})
+	}
+	if err := walk("sha256", sha256s); err != nil {
+		return removed, freed, err
+	}
+	return removed, freed, walk("md5", md5s)
+}
+
+// linkFile hardlinks the blob to filePath, replacing any file there, or copies
+// it if it can't be linked, eg. because they're on different filesystems.
+func linkFile(blob string, filePath string) error {
+	tmp := fmt.Sprintf("%s.%d.tmp", filePath, time.Now().UnixNano())
+	if err := os.Link(blob, tmp); err != nil {
+		if err := copyFile(blob, tmp); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+	if err := os.Rename(tmp, filePath); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func copyFile(src string, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// blobStore returns the blob store the job's artifacts are kept in, or nil if
+// there isn't one.
+func (h *Tracker) blobStore(job *TrackedJob) *BlobStore {
+	if !h.blobStoreEnabled {
+		return nil
+	}
+	if h.globalBlobStore != nil {
+		return h.globalBlobStore
+	}
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	dir := jobBlobStoreDir(job.SyncDir)
+	store, ok := h.blobStores[dir]
+	if !ok {
+		store = NewBlobStore(dir)
+		h.blobStores[dir] = store
+	}
+	return store
+}
+
+// storeArtifact adds a downloaded or reused artifact to the blob store. A
+// failure only means the artifact isn't shared, so it's logged rather than
+// returned.
+func (h *Tracker) storeArtifact(log *logging.Entry, store *BlobStore, download downloadResult) {
+	deduplicated, err := store.add(download.path, download.sha256, download.md5)
+	if err != nil {
+		log.With(logging.Fields{"error": err}).Warn("failed to add artifact to the blob store")
+	} else if deduplicated {
+		log.Debug("replaced artifact with a link to the identical blob")
+	}
+}
+
+// collectBlobs removes the blobs of the store which none of the cached builds
+// of the jobs using it have any more.
+func (h *Tracker) collectBlobs(store *BlobStore) {
+	sha256s := map[string]bool{}
+	md5s := map[string]bool{}
+	for _, job := range h.Jobs() {
+		if h.blobStore(job) != store {
+			continue
+		}
+		builds, err := h.CachedBuilds(job)
+		if err != nil {
+			h.jobLog(job).With(logging.Fields{"error": err}).Error("failed to list builds; skipping blob garbage collection")
+			return
+		}
+		for _, build := range builds {
+			manifest, err := ReadManifest(filepath.Join(job.SyncDir, fmt.Sprintf("%d", build)))
+			if err != nil {
+				h.buildLog(job, int32(build)).With(logging.Fields{"error": err}).Error("failed to read manifest; skipping blob garbage collection")
+				return
+			}
+			if manifest == nil {
+				continue
+			}
+			for _, artifact := range manifest.Artifacts {
+				sha256s[artifact.SHA256] = true
+				md5s[artifact.MD5] = true
+			}
+		}
+	}
+	removed, freed, err := store.collect(sha256s, md5s)
+	log := h.log.With(logging.Fields{"path": store.dir, "blobs": removed, "bytes": freed})
+	if err != nil {
+		log.With(logging.Fields{"error": err}).Error("failed to remove unused blobs")
+	} else if removed > 0 {
+		log.Info("removed unused blobs")
+	}
+}
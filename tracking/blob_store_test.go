@@ -0,0 +1,158 @@
+package tracking
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// storeFile writes a file into dir and adds it to the store.
+func storeFile(t *testing.T, store *BlobStore, dir string, name string, content string) (string, string, bool) {
+	filePath := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(filePath), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filePath, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	sha256, md5, err := fileChecksums(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deduplicated, err := store.add(filePath, sha256, md5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sha256, md5, deduplicated
+}
+
+func sameFile(t *testing.T, a string, b string) bool {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return os.SameFile(infoA, infoB)
+}
+
+func TestBlobStoreAdd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jenkronize-blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store := NewBlobStore(filepath.Join(dir, "blobs"))
+
+	sha256, md5, deduplicated := storeFile(t, store, dir, "1/a.txt", "hello")
+	if deduplicated {
+		t.Error("the first copy of the artifact was deduplicated")
+	}
+	_, _, deduplicated = storeFile(t, store, dir, "2/a.txt", "hello")
+	if !deduplicated {
+		t.Error("the second copy of the artifact wasn't deduplicated")
+	}
+	if !sameFile(t, filepath.Join(dir, "1/a.txt"), filepath.Join(dir, "2/a.txt")) {
+		t.Error("the copies of the artifact aren't linked")
+	}
+	if !sameFile(t, store.sha256Path(sha256), filepath.Join(dir, "2/a.txt")) {
+		t.Error("the artifact isn't linked to its blob")
+	}
+	// adding it again changes nothing
+	if deduplicated, err := store.add(filepath.Join(dir, "2/a.txt"), sha256, md5); err != nil || deduplicated {
+		t.Errorf("adding a linked artifact again returned %v, %v", deduplicated, err)
+	}
+	_, _, deduplicated = storeFile(t, store, dir, "2/b.txt", "changed")
+	if deduplicated {
+		t.Error("a different artifact was deduplicated")
+	}
+
+	blob := store.findMD5(md5)
+	if blob == "" || !sameFile(t, blob, store.sha256Path(sha256)) {
+		t.Errorf("the blob found by MD5 is %q", blob)
+	}
+	if blob := store.findMD5("0123456789abcdef0123456789abcdef"); blob != "" {
+		t.Errorf("found %q for an MD5 which isn't stored", blob)
+	}
+	if blob := store.findMD5(""); blob != "" {
+		t.Errorf("found %q for an empty MD5", blob)
+	}
+}
+
+func TestBlobStoreCollect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jenkronize-blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store := NewBlobStore(filepath.Join(dir, "blobs"))
+	keptSHA256, keptMD5, _ := storeFile(t, store, dir, "1/a.txt", "hello")
+	goneSHA256, goneMD5, _ := storeFile(t, store, dir, "1/b.txt", "goodbye")
+
+	// blobs added since they were last referenced may be for a build which
+	// hasn't written its manifest yet
+	removed, _, err := store.collect(map[string]bool{}, map[string]bool{})
+	if err != nil || removed != 0 {
+		t.Fatalf("collecting blobs which are still being added returned %d, %v", removed, err)
+	}
+	if _, _, err := store.collect(map[string]bool{keptSHA256: true, goneSHA256: true}, map[string]bool{keptMD5: true, goneMD5: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// but once a build has referred to them, they're collected as soon as
+	// none does
+	removed, freed, err := store.collect(map[string]bool{keptSHA256: true}, map[string]bool{keptMD5: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 || freed != int64(len("goodbye")) {
+		t.Errorf("removed %d blobs and freed %d bytes", removed, freed)
+	}
+	if _, err := os.Stat(store.sha256Path(keptSHA256)); err != nil {
+		t.Errorf("a referenced blob was removed: %v", err)
+	}
+	if store.findMD5(keptMD5) == "" {
+		t.Error("the MD5 link of a referenced blob was removed")
+	}
+	if _, err := os.Stat(store.sha256Path(goneSHA256)); !os.IsNotExist(err) {
+		t.Errorf("an unreferenced blob wasn't removed: %v", err)
+	}
+	if store.findMD5(goneMD5) != "" {
+		t.Error("the MD5 link of an unreferenced blob wasn't removed")
+	}
+	// the builds keep their own links
+	if content, err := ioutil.ReadFile(filepath.Join(dir, "1/b.txt")); err != nil || string(content) != "goodbye" {
+		t.Errorf("removing a blob changed a build: %q, %v", content, err)
+	}
+
+	// a blob which never gets referenced, eg. because its build failed, is
+	// only kept for a while
+	staleSHA256, _, _ := storeFile(t, store, dir, "2/c.txt", "failed")
+	store.pending[staleSHA256] = time.Now().Add(-pendingBlobTTL - time.Minute)
+	if _, _, err := store.collect(map[string]bool{keptSHA256: true}, map[string]bool{keptMD5: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(store.sha256Path(staleSHA256)); !os.IsNotExist(err) {
+		t.Errorf("a blob which was never referenced wasn't removed: %v", err)
+	}
+
+	// an empty store has nothing to collect
+	removed, _, err = NewBlobStore(filepath.Join(dir, "empty")).collect(map[string]bool{}, map[string]bool{})
+	if err != nil || removed != 0 {
+		t.Errorf("collecting an empty store returned %d, %v", removed, err)
+	}
+}
+
+func TestJobBlobStoreDir(t *testing.T) {
+	syncDir := filepath.Join("opt", "sync", "foo")
+	expected := filepath.Join("opt", "sync", ".foo.blobs")
+	for _, dir := range []string{syncDir, syncDir + string(filepath.Separator)} {
+		if blobs := jobBlobStoreDir(dir); blobs != expected {
+			t.Errorf("the blob store of %s is %s, expected %s", dir, blobs, expected)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package tracking
+
+import (
+	"fmt"
+	"github.com/pakohler/jenkronize/jenkins"
+	"regexp"
+	"strings"
+)
+
+// BuildSelection is how the build of a job to sync is chosen.
+type BuildSelection string
+
+const (
+	// SelectLastSuccessful picks the last successful build, which Jenkins
+	// counts unstable builds as
+	SelectLastSuccessful BuildSelection = "last_successful"
+	// SelectLastStable picks the last build which succeeded without any test
+	// failures
+	SelectLastStable BuildSelection = "last_stable"
+	// SelectLastCompleted picks the last finished build with one of the
+	// allowed results, or with any result if none are given
+	SelectLastCompleted BuildSelection = "last_completed"
+	// SelectPromoted picks the last build with the promotion, or with any
+	// promotion if none is given
+	SelectPromoted BuildSelection = "promoted"
+	// SelectKeepForever picks the last build marked to be kept forever
+	SelectKeepForever BuildSelection = "keep_forever"
+)
+
+var BuildSelections = []BuildSelection{
+	SelectLastSuccessful,
+	SelectLastStable,
+	SelectLastCompleted,
+	SelectPromoted,
+	SelectKeepForever,
+}
+
+// SyncMode is which builds of a job are synced.
+type SyncMode string
+
+const (
+	// ModeLatest only syncs the selected build, skipping any in between
+	ModeLatest SyncMode = "latest"
+	// ModeAllBuilds syncs every qualifying build since the last synced one
+	ModeAllBuilds SyncMode = "all_builds"
+)
+
+var SyncModes = []SyncMode{ModeLatest, ModeAllBuilds}
+
+// ParseSyncMode validates a sync mode as used in configuration. An empty name
+// is the default, latest.
+func ParseSyncMode(name string) (SyncMode, error) {
+	if name == "" {
+		return ModeLatest, nil
+	}
+	for _, m := range SyncModes {
+		if strings.EqualFold(name, string(m)) {
+			return m, nil
+		}
+	}
+	return "", fmt.Errorf("unknown mode %q; it should be %s or %s", name, ModeLatest, ModeAllBuilds)
+}
+
+// BuildResults are the results a Jenkins build can finish with.
+var BuildResults = []string{"SUCCESS", "UNSTABLE", "FAILURE", "NOT_BUILT", "ABORTED"}
+
+// ParseBuildSelection validates a build selection as used in configuration.
+// An empty name is the default, last_successful.
+func ParseBuildSelection(name string) (BuildSelection, error) {
+	if name == "" {
+		return SelectLastSuccessful, nil
+	}
+	for _, s := range BuildSelections {
+		if strings.EqualFold(name, string(s)) {
+			return s, nil
+		}
+	}
+	names := make([]string, len(BuildSelections))
+	for i, s := range BuildSelections {
+		names[i] = string(s)
+	}
+	return "", fmt.Errorf("unknown build selection %q; it should be one of %s", name, strings.Join(names, ", "))
+}
+
+// ParseBuildResult validates the result of a build as used in configuration.
+func ParseBuildResult(name string) (string, error) {
+	for _, r := range BuildResults {
+		if strings.EqualFold(name, r) {
+			return r, nil
+		}
+	}
+	return "", fmt.Errorf("unknown build result %q; it should be one of %s", name, strings.Join(BuildResults, ", "))
+}
+
+// LatestBuild returns the build of the job that should be synced, according to
+// its build selection, or nil if no build qualifies yet.
+func (h *Tracker) LatestBuild(job *TrackedJob) (*jenkins.Build, error) {
+	if job.PinBuild > 0 {
+		return h.pinnedBuild(job)
+	}
+	selection, err := ParseBuildSelection(string(job.BuildSelection))
+	if err != nil {
+		return nil, err
+	}
+	if job.HasMatchers() {
+		// only the builds themselves have what's matched
+		return h.findBuild(job, selection)
+	}
+	switch selection {
+	case SelectLastStable:
+		j, err := h.client.GetJob(job.GetName())
+		if err != nil {
+			return nil, err
+		}
+		return j.LastStableBuild, nil
+	case SelectLastCompleted:
+		if len(job.AllowedResults) == 0 {
+			j, err := h.client.GetJob(job.GetName())
+			if err != nil {
+				return nil, err
+			}
+			return j.LastCompleteBuild, nil
+		}
+		return h.findBuild(job, selection)
+	case SelectPromoted, SelectKeepForever:
+		return h.findBuild(job, selection)
+	}
+	return h.client.GetLastSuccessfulBuildForJob(job.GetName())
+}
+
+// pinnedBuild returns the build the job is pinned to, or nil if it hasn't
+// finished yet.
+func (h *Tracker) pinnedBuild(job *TrackedJob) (*jenkins.Build, error) {
+	b, err := h.client.GetBuild(job.GetName(), job.PinBuild)
+	if err != nil {
+		return nil, err
+	}
+	if b.Building {
+		return nil, nil
+	}
+	return &jenkins.Build{Class: b.Class, Number: b.Number, Url: b.Url}, nil
+}
+
+// findBuild returns the newest build of the job that qualifies for the
+// selection, or nil if none of the builds Jenkins still has do.
+func (h *Tracker) findBuild(job *TrackedJob, selection BuildSelection) (*jenkins.Build, error) {
+	var found *jenkins.Build
+	err := h.eachBuild(job, func(b *jenkins.JobBuild) bool {
+		if qualifies(job, selection, b) {
+			found = &jenkins.Build{Class: b.Class, Number: b.Number, Url: b.Url}
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+// buildsPerPage is how many builds are listed at a time when searching through
+// the builds of a job.
+const buildsPerPage = 100
+
+// eachBuild calls f with each build of the job Jenkins still has, newest
+// first, until it returns false. The builds are listed a page at a time, so
+// only as many are listed as are looked at.
+func (h *Tracker) eachBuild(job *TrackedJob, f func(b *jenkins.JobBuild) bool) error {
+	last := int32(-1)
+	for start := 0; ; start += buildsPerPage {
+		builds, err := h.client.GetBuilds(job.GetName(), start, start+buildsPerPage)
+		if err != nil {
+			return err
+		}
+		for _, b := range builds {
+			// a build started since the last page pushes its last build
+			// onto this one
+			if last >= 0 && b.Number >= last {
+				continue
+			}
+			last = b.Number
+			if !f(b) {
+				return nil
+			}
+		}
+		if len(builds) < buildsPerPage {
+			return nil
+		}
+	}
+}
+
+// qualifies reports whether the build could be chosen by the selection, were
+// it the newest build.
+func qualifies(job *TrackedJob, selection BuildSelection, b *jenkins.JobBuild) bool {
+	if b.Building || !matches(job, b) {
+		return false
+	}
+	switch selection {
+	case SelectLastStable:
+		return b.Result == "SUCCESS"
+	case SelectLastCompleted:
+		if len(job.AllowedResults) == 0 {
+			return true
+		}
+		for _, result := range job.AllowedResults {
+			if strings.EqualFold(b.Result, result) {
+				return true
+			}
+		}
+		return false
+	case SelectPromoted:
+		for _, name := range b.Promotions() {
+			if job.Promotion == "" || name == job.Promotion {
+				return true
+			}
+		}
+		return false
+	case SelectKeepForever:
+		return b.KeepLog
+	}
+	return b.Result == "SUCCESS" || b.Result == "UNSTABLE"
+}
+
+// matches reports whether the build matches all of the job's parameter,
+// description and display name patterns.
+func matches(job *TrackedJob, b *jenkins.JobBuild) bool {
+	if job.MatchDescription != "" && !matchWildcard(job.MatchDescription, b.Description) {
+		return false
+	}
+	if job.MatchDisplayName != "" && !matchWildcard(job.MatchDisplayName, b.DisplayName) {
+		return false
+	}
+	if len(job.MatchParameters) == 0 {
+		return true
+	}
+	params := b.Parameters()
+	for name, pattern := range job.MatchParameters {
+		value, ok := parameter(params, name)
+		if !ok || !matchWildcard(pattern, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// parameter looks up a build parameter by name, ignoring case if there's no
+// exact match; parameter names set from the environment are lower-cased.
+func parameter(params map[string]string, name string) (string, bool) {
+	if value, ok := params[name]; ok {
+		return value, true
+	}
+	for n, value := range params {
+		if strings.EqualFold(n, name) {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// matchWildcard reports whether the whole of s matches the pattern, in which
+// * matches any number of characters, including none, and ? matches any one
+// character; eg. 5.2.* matches 5.2.0 and 5.2.10.
+func matchWildcard(pattern string, s string) bool {
+	var re strings.Builder
+	re.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			re.WriteString(".*")
+		case '?':
+			re.WriteString(".")
+		default:
+			re.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	re.WriteString("$")
+	return regexp.MustCompile(re.String()).MatchString(s)
+}
+
+// pendingBuilds returns the builds to sync to bring the job up to the current
+// build, oldest first. That's only the current build, unless the job's mode is
+// all_builds; then it's every qualifying build since the one the job is synced
+// to, up to as many as are cached. A job which hasn't been synced yet is
+// backfilled by its initial_backfill instead.
+func (h *Tracker) pendingBuilds(job *TrackedJob, current *jenkins.Build) ([]*jenkins.Build, error) {
+	mode, err := ParseSyncMode(string(job.Mode))
+	if err != nil {
+		return nil, err
+	}
+	if mode != ModeAllBuilds {
+		return []*jenkins.Build{current}, nil
+	}
+	selection, err := ParseBuildSelection(string(job.BuildSelection))
+	if err != nil {
+		return nil, err
+	}
+	// any more than are cached would be removed as soon as they're synced,
+	// and a job which hasn't been synced yet only syncs its backfill
+	limit := -1
+	if job.BuildsToCache >= 0 {
+		limit = job.BuildsToCache + 1
+	}
+	if job.BuildNumber() == 0 {
+		backfill := job.InitialBackfill
+		if backfill < 1 {
+			backfill = 1
+		}
+		if limit < 0 || backfill < limit {
+			limit = backfill
+		}
+	}
+	pending := []*jenkins.Build{current}
+	if limit == 1 {
+		return pending, nil
+	}
+	err = h.eachBuild(job, func(b *jenkins.JobBuild) bool {
+		if limit >= 0 && len(pending) >= limit {
+			return false
+		}
+		if b.Number >= current.Number {
+			return true
+		}
+		if b.Number <= job.BuildNumber() {
+			return false
+		}
+		if qualifies(job, selection, b) {
+			pending = append(pending, &jenkins.Build{Class: b.Class, Number: b.Number, Url: b.Url})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(pending)-1; i < j; i, j = i+1, j-1 {
+		pending[i], pending[j] = pending[j], pending[i]
+	}
+	if len(pending) > 1 {
+		h.jobLog(job).Infof("backfilling %d builds", len(pending))
+	}
+	return pending, nil
+}
@@ -0,0 +1,227 @@
+package tracking
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pakohler/jenkronize/jenkins"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestMatchWildcard(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		match   bool
+	}{
+		{"5.2.*", "5.2.0", true},
+		{"5.2.*", "5.2.10", true},
+		{"5.2.*", "5.2", false},
+		{"5.?.0", "5.3.0", true},
+		{"5.?.0", "5.10.0", false},
+		{"*", "", true},
+		{"release (rc)", "release (rc)", true},
+		{"a+b", "aab", false},
+	}
+	for _, test := range tests {
+		if match := matchWildcard(test.pattern, test.s); match != test.match {
+			t.Errorf("matchWildcard(%q, %q) is %v", test.pattern, test.s, match)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	build := &jenkins.JobBuild{
+		Description: "release candidate",
+		DisplayName: "#42",
+		Actions: []*jenkins.Action{
+			nil,
+			{Parameters: []*jenkins.Parameter{
+				{Name: "RELEASE_VERSION", Value: "5.2.1"},
+				{Name: "DEPLOY", Value: true},
+			}},
+		},
+	}
+	tests := []struct {
+		job   *TrackedJob
+		match bool
+	}{
+		{&TrackedJob{}, true},
+		{&TrackedJob{MatchParameters: map[string]string{"RELEASE_VERSION": "5.2.*"}}, true},
+		{&TrackedJob{MatchParameters: map[string]string{"RELEASE_VERSION": "5.3.*"}}, false},
+		// as set from JENKRONIZE_..._MATCH_PARAMETERS_RELEASE_VERSION
+		{&TrackedJob{MatchParameters: map[string]string{"release_version": "5.2.*"}}, true},
+		{&TrackedJob{MatchParameters: map[string]string{"RELEASE_VERSION": "5.2.*", "DEPLOY": "true"}}, true},
+		{&TrackedJob{MatchParameters: map[string]string{"MISSING": "*"}}, false},
+		{&TrackedJob{MatchDescription: "release*"}, true},
+		{&TrackedJob{MatchDescription: "nightly*"}, false},
+		{&TrackedJob{MatchDisplayName: "#4?"}, true},
+	}
+	for _, test := range tests {
+		if match := matches(test.job, build); match != test.match {
+			t.Errorf("a job with parameters %v, description %q and display name %q matches: %v", test.job.MatchParameters, test.job.MatchDescription, test.job.MatchDisplayName, match)
+		}
+	}
+}
+
+// fakeBuilds is a Jenkins job with builds 1 to newest, of which only
+// released has the description "release". It serves allBuilds ranges, and
+// records the ranges asked for.
+type fakeBuilds struct {
+	server   *httptest.Server
+	newest   int
+	released int
+	ranges   []string
+	mux      sync.Mutex
+}
+
+var buildRange = regexp.MustCompile(`\{(\d+),(\d*)\}$`)
+
+func newFakeBuilds(t *testing.T, newest int, released int) *fakeBuilds {
+	f := &fakeBuilds{newest: newest, released: released}
+	f.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m := buildRange.FindStringSubmatch(r.URL.Query().Get("tree"))
+		if r.URL.Path != "/job/foo/api/json" || m == nil {
+			t.Errorf("unexpected request for %s", r.URL)
+			http.NotFound(w, r)
+			return
+		}
+		f.mux.Lock()
+		defer f.mux.Unlock()
+		f.ranges = append(f.ranges, m[0])
+		start, _ := strconv.Atoi(m[1])
+		end, err := strconv.Atoi(m[2])
+		if err != nil || end > f.newest {
+			end = f.newest
+		}
+		builds := []map[string]interface{}{}
+		for i := start; i < end; i++ {
+			number := f.newest - i
+			build := map[string]interface{}{"number": number, "result": "SUCCESS", "url": fmt.Sprintf("%s/job/foo/%d/", f.server.URL, number)}
+			if number == f.released {
+				build["description"] = "release"
+			}
+			builds = append(builds, build)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"allBuilds": builds})
+	}))
+	return f
+}
+
+func (f *fakeBuilds) tracker() *Tracker {
+	return (&Tracker{}).Init().SetClient(jenkins.New().SetBaseUrl(f.server.URL))
+}
+
+func TestFindBuildPastNewest100(t *testing.T) {
+	f := newFakeBuilds(t, 250, 30)
+	defer f.server.Close()
+	job := NewTrackedJob("/job/foo", "foo", "")
+	job.MatchDescription = "release"
+	build, err := f.tracker().findBuild(job, SelectLastSuccessful)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if build == nil || build.Number != 30 {
+		t.Fatalf("found %+v, expected build 30", build)
+	}
+	if fmt.Sprint(f.ranges) != "[{0,100} {100,200} {200,300}]" {
+		t.Errorf("the builds were listed in the ranges %v", f.ranges)
+	}
+
+	// once the builds run out, there's nothing to find
+	job.MatchDescription = "nightly"
+	f.ranges = nil
+	if build, err := f.tracker().findBuild(job, SelectLastSuccessful); err != nil || build != nil {
+		t.Errorf("found %+v, %v", build, err)
+	}
+	if len(f.ranges) != 3 {
+		t.Errorf("the builds were listed in the ranges %v", f.ranges)
+	}
+}
+
+func TestEachBuildWhileBuilding(t *testing.T) {
+	f := newFakeBuilds(t, 150, 0)
+	defer f.server.Close()
+	job := NewTrackedJob("/job/foo", "foo", "")
+	numbers := []int32{}
+	err := f.tracker().eachBuild(job, func(b *jenkins.JobBuild) bool {
+		if len(numbers) == 0 {
+			// a new build starts while the first page is looked at,
+			// which pushes build 51 onto the next page
+			f.mux.Lock()
+			f.newest++
+			f.mux.Unlock()
+		}
+		numbers = append(numbers, b.Number)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(numbers) != 150 {
+		t.Fatalf("%d builds were listed, expected 150", len(numbers))
+	}
+	for i, number := range numbers {
+		if number != int32(150-i) {
+			t.Fatalf("build %d listed is %d, expected %d", i, number, 150-i)
+		}
+	}
+}
+
+func TestPendingBuildsListsOnlyWhatsNeeded(t *testing.T) {
+	f := newFakeBuilds(t, 250, 0)
+	defer f.server.Close()
+	h := f.tracker()
+	current := &jenkins.Build{Number: 250}
+	tests := []struct {
+		synced        int32
+		buildsToCache int
+		backfill      int
+		pending       string
+		ranges        string
+	}{
+		// every build since the synced one, which is on the first page
+		{240, -1, 0, "[241 242 243 244 245 246 247 248 249 250]", "[{0,100}]"},
+		// every build since the synced one, 200 builds back
+		{50, -1, 0, "200 builds", "[{0,100} {100,200} {200,300}]"},
+		// only as many as are cached
+		{50, 2, 0, "[248 249 250]", "[{0,100}]"},
+		// a job which hasn't been synced yet only syncs its backfill, and
+		// doesn't need to list any builds for the latest alone
+		{0, -1, 3, "[248 249 250]", "[{0,100}]"},
+		{0, -1, 0, "[250]", "[]"},
+	}
+	for _, test := range tests {
+		job := NewTrackedJob("/job/foo", "foo", "")
+		job.Mode = ModeAllBuilds
+		job.BuildsToCache = test.buildsToCache
+		job.InitialBackfill = test.backfill
+		job.SetBuild(&jenkins.Build{Number: test.synced})
+		f.ranges = nil
+		pending, err := h.pendingBuilds(job, current)
+		if err != nil {
+			t.Fatal(err)
+		}
+		numbers := []int32{}
+		for _, b := range pending {
+			numbers = append(numbers, b.Number)
+		}
+		listed := fmt.Sprint(numbers)
+		if len(numbers) > 20 {
+			listed = fmt.Sprintf("%d builds", len(numbers))
+			if numbers[0] != test.synced+1 || numbers[len(numbers)-1] != current.Number {
+				t.Errorf("synced to %d, the pending builds run from %d to %d", test.synced, numbers[0], numbers[len(numbers)-1])
+			}
+		}
+		if listed != test.pending {
+			t.Errorf("synced to %d with builds_to_cache %d and initial_backfill %d, the pending builds are %s, expected %s", test.synced, test.buildsToCache, test.backfill, listed, test.pending)
+		}
+		if ranges := fmt.Sprint(f.ranges); ranges != test.ranges {
+			t.Errorf("synced to %d with builds_to_cache %d and initial_backfill %d, the builds were listed in the ranges %s, expected %s", test.synced, test.buildsToCache, test.backfill, ranges, test.ranges)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package tracking
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/pakohler/jenkronize/logging"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// downloadAttempts is how many times an artifact is downloaded before a
+// mismatch with its fingerprint is given up on.
+const downloadAttempts = 3
+
+// checksumError is a downloaded file which doesn't match its fingerprint.
+type checksumError struct {
+	url      string
+	expected string
+	actual   string
+}
+
+func (e *checksumError) Error() string {
+	return fmt.Sprintf("checksum mismatch for %s: Jenkins fingerprinted it as MD5 %s, but the download is %s", e.url, e.expected, e.actual)
+}
+
+// downloadArtifact downloads the artifact into dir and checksums it. If the
+// artifact has a fingerprint, the download is checked against it, and is
+// removed and downloaded again if it doesn't match. A fingerprinted artifact
+// is reused from the previous build's file, if given, or the blob store
+// instead of being downloaded at all.
+func (h *Tracker) downloadArtifact(job *TrackedJob, build int32, download downloadResult, dir string, fingerprint string, previous string) downloadResult {
+	log := h.buildLog(job, build).With(logging.Fields{"artifact_url": download.url})
+	store := h.blobStore(job)
+	if fingerprint != "" {
+		if previous != "" {
+			if reused, ok := h.reuseArtifact(log, previous, download, dir, fingerprint); ok {
+				if store != nil {
+					h.storeArtifact(log, store, reused)
+				}
+				return reused
+			}
+		}
+		if store != nil {
+			if blob := store.findMD5(fingerprint); blob != "" {
+				if linked, ok := h.reuseArtifact(log, blob, download, dir, fingerprint); ok {
+					// so the blob isn't collected before the build's manifest
+					// refers to it
+					h.storeArtifact(log, store, linked)
+					return linked
+				}
+			}
+		}
+	}
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		download.path, download.err = h.client.DownloadFile(download.url, dir)
+		download.finished = time.Now()
+		download.took = time.Since(start)
+		if download.err != nil {
+			return download
+		}
+		download.sha256, download.md5, download.err = fileChecksums(download.path)
+		if download.err != nil {
+			return download
+		}
+		if fingerprint == "" || strings.EqualFold(download.md5, fingerprint) {
+			download.verified = fingerprint != ""
+			if download.verified {
+				log.Debug("download matches its fingerprint")
+			}
+			if store != nil {
+				h.storeArtifact(log, store, download)
+			}
+			return download
+		}
+		download.err = &checksumError{url: download.url, expected: fingerprint, actual: download.md5}
+		// remove the bad download, or it would be resumed rather than replaced
+		if err := os.Remove(download.path); err != nil {
+			log.With(logging.Fields{"error": err}).Error("failed to remove corrupt download")
+			return download
+		}
+		if attempt == downloadAttempts {
+			return download
+		}
+		log.With(logging.Fields{"error": download.err, "attempt": attempt}).Warn("download doesn't match its fingerprint; retrying")
+	}
+}
+
+// fileChecksums returns the hex encoded SHA-256 and MD5 of the file.
+func fileChecksums(filePath string) (string, string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", "", err
+	}
+	defer file.Close()
+	sha := sha256.New()
+	md := md5.New()
+	if _, err := io.Copy(io.MultiWriter(sha, md), file); err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(sha.Sum(nil)), hex.EncodeToString(md.Sum(nil)), nil
+}
@@ -0,0 +1,139 @@
+package tracking
+
+import (
+	"fmt"
+	"github.com/pakohler/jenkronize/jenkins"
+	"github.com/pakohler/jenkronize/notifications"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+// md5 of "hello"
+const helloMD5 = "5d41402abc4b2a76b9719d911017c592"
+
+func TestDownloadArtifact(t *testing.T) {
+	tests := []struct {
+		name        string
+		bodies      []string
+		fingerprint string
+		gets        int32
+		verified    bool
+		mismatch    bool
+	}{
+		{"matching", []string{"hello"}, helloMD5, 1, true, false},
+		{"corrupt then matching", []string{"corrupt", "hello"}, helloMD5, 2, true, false},
+		{"always corrupt", []string{"corrupt"}, helloMD5, downloadAttempts, false, true},
+		{"unfingerprinted", []string{"corrupt"}, "", 1, false, false},
+	}
+	for _, test := range tests {
+		var gets int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "GET" {
+				return
+			}
+			// serve each body in turn, repeating the last
+			n := int(atomic.AddInt32(&gets, 1))
+			if n > len(test.bodies) {
+				n = len(test.bodies)
+			}
+			w.Write([]byte(test.bodies[n-1]))
+		}))
+		dir, err := ioutil.TempDir("", "jenkronize-download")
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := (&Tracker{}).Init().SetClient(jenkins.New().SetBaseUrl(server.URL))
+		job := NewTrackedJob("/job/foo", "foo", dir)
+		buildDir := filepath.Join(dir, "2")
+		download := h.downloadArtifact(job, 2, downloadResult{url: server.URL + "/job/foo/2/artifact/a.txt"}, buildDir, test.fingerprint, "")
+		server.Close()
+
+		if gets != test.gets {
+			t.Errorf("%s: downloaded %d times, expected %d", test.name, gets, test.gets)
+		}
+		if download.verified != test.verified {
+			t.Errorf("%s: verified is %v", test.name, download.verified)
+		}
+		if _, ok := download.err.(*checksumError); ok != test.mismatch {
+			t.Errorf("%s: the download failed with %v", test.name, download.err)
+		}
+		_, err = os.Stat(filepath.Join(buildDir, "a.txt"))
+		if test.mismatch && !os.IsNotExist(err) {
+			t.Errorf("%s: the corrupt download was left behind: %v", test.name, err)
+		} else if !test.mismatch && err != nil {
+			t.Errorf("%s: the download is missing: %v", test.name, err)
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestHandleNewBuildUnverified(t *testing.T) {
+	var fingerprinted int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/job/foo/2/api/json":
+			if atomic.LoadInt32(&fingerprinted) == 0 {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte("<html>oops</html>"))
+				return
+			}
+			fmt.Fprintf(w, `{"fingerprint": [{"fileName": "a.txt", "hash": "%s"}]}`, helloMD5)
+		case "/job/foo/2/artifact/a.txt":
+			w.Write([]byte("hello"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "jenkronize-download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	events := []*notifications.Event{}
+	h := (&Tracker{}).Init().
+		SetClient(jenkins.New().SetBaseUrl(server.URL)).
+		AddNotifier(notifications.NotifierFunc(func(event *notifications.Event) error {
+			events = append(events, event)
+			return nil
+		}))
+	job := NewTrackedJob("/job/foo", "foo", dir)
+	build := &jenkins.Build{Number: 2, Url: server.URL + "/job/foo/2/"}
+	details := &jenkins.JobBuild{
+		Number:    2,
+		Url:       build.Url,
+		Artifacts: []*jenkins.Artifact{{FileName: "a.txt", RelativePath: "a.txt"}},
+	}
+
+	// the artifacts are still synced without their fingerprints, but not
+	// silently
+	result, err := h.handleNewBuild(job, build, details)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.downloads) != 1 || result.downloads[0].verified {
+		t.Errorf("the downloads were %+v", result.downloads)
+	}
+	if len(events) != 1 || events[0].Type != notifications.EventUnverified || events[0].Severity != notifications.SeverityWarning {
+		t.Fatalf("the notifications were %+v", events)
+	}
+
+	// and once the fingerprints are back, that's notified too
+	atomic.StoreInt32(&fingerprinted, 1)
+	os.RemoveAll(filepath.Join(dir, "2"))
+	result, err = h.handleNewBuild(job, build, details)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.downloads) != 1 || !result.downloads[0].verified {
+		t.Errorf("the downloads were %+v", result.downloads)
+	}
+	if len(events) != 2 || events[1].Type != notifications.EventResolved || events[1].Condition != string(notifications.EventUnverified) {
+		t.Errorf("the notifications were %+v", events)
+	}
+}
@@ -0,0 +1,69 @@
+package tracking
+
+import (
+	"fmt"
+	"github.com/pakohler/jenkronize/logging"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// previousArtifacts maps the MD5 of each artifact of the build the job is
+// synced to onto its file, so that artifacts of a new build which haven't
+// changed since can be reused rather than downloaded. Files whose size no
+// longer matches the manifest are left out.
+func (h *Tracker) previousArtifacts(job *TrackedJob, build int32) map[string]string {
+	previous := map[string]string{}
+	synced := job.BuildNumber()
+	if synced <= 0 || synced == build {
+		return previous
+	}
+	buildDir := filepath.Join(job.SyncDir, fmt.Sprintf("%d", synced))
+	manifest, err := ReadManifest(buildDir)
+	if err != nil {
+		h.buildLog(job, synced).With(logging.Fields{"error": err}).Warn("unable to read manifest; artifacts won't be reused")
+		return previous
+	}
+	if manifest == nil {
+		return previous
+	}
+	for _, artifact := range manifest.Artifacts {
+		filePath := filepath.Join(buildDir, artifact.Path)
+		if info, err := os.Stat(filePath); err != nil || info.Size() != artifact.Size {
+			continue
+		}
+		previous[strings.ToLower(artifact.MD5)] = filePath
+	}
+	return previous
+}
+
+// reuseArtifact links or copies a local file with the artifact's fingerprint
+// into dir instead of downloading the artifact. The file is checked against
+// the fingerprint first, in case it's changed since it was synced.
+func (h *Tracker) reuseArtifact(log *logging.Entry, source string, download downloadResult, dir string, fingerprint string) (downloadResult, bool) {
+	log = log.With(logging.Fields{"source": source})
+	start := time.Now()
+	download.path = h.client.DownloadPath(download.url, dir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		log.With(logging.Fields{"error": err}).Warn("failed to reuse artifact; downloading it")
+		return download, false
+	}
+	if err := linkFile(source, download.path); err != nil {
+		log.With(logging.Fields{"error": err}).Warn("failed to reuse artifact; downloading it")
+		return download, false
+	}
+	var err error
+	download.sha256, download.md5, err = fileChecksums(download.path)
+	if err != nil || !strings.EqualFold(download.md5, fingerprint) {
+		log.Warn("local copy doesn't match the artifact's fingerprint; downloading it")
+		os.Remove(download.path)
+		return download, false
+	}
+	download.verified = true
+	download.linked = true
+	download.finished = time.Now()
+	download.took = time.Since(start)
+	log.Info("reused identical artifact instead of downloading it")
+	return download, true
+}
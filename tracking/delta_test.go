@@ -0,0 +1,93 @@
+package tracking
+
+import (
+	"github.com/pakohler/jenkronize/jenkins"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// syncedJob returns a job synced to build 1, which has a.txt and b.txt. b.txt
+// has changed size since its manifest was written.
+func syncedJob(t *testing.T) *TrackedJob {
+	syncDir, err := ioutil.TempDir("", "jenkronize-sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := NewTrackedJob("/job/foo", "foo", syncDir)
+	job.SetBuild(&jenkins.Build{Number: 1})
+	buildDir := filepath.Join(syncDir, "1")
+	if err := os.MkdirAll(buildDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	manifest := &BuildManifest{Build: 1}
+	for name, content := range map[string]string{"a.txt": "hello", "b.txt": "goodbye"} {
+		filePath := filepath.Join(buildDir, name)
+		if err := ioutil.WriteFile(filePath, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		sha256, md5, err := fileChecksums(filePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		manifest.Artifacts = append(manifest.Artifacts, &ArtifactManifest{
+			Path:   name,
+			Size:   int64(len(content)),
+			SHA256: sha256,
+			MD5:    md5,
+		})
+	}
+	if err := writeJsonFile(filepath.Join(buildDir, ManifestFile), manifest); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(buildDir, "b.txt"), []byte("changed since"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func TestPreviousArtifacts(t *testing.T) {
+	job := syncedJob(t)
+	defer os.RemoveAll(job.SyncDir)
+	h := (&Tracker{}).Init()
+
+	previous := h.previousArtifacts(job, 2)
+	if file := previous[helloMD5]; file != filepath.Join(job.SyncDir, "1", "a.txt") {
+		t.Errorf("the unchanged artifact is %q", file)
+	}
+	if len(previous) != 1 {
+		t.Errorf("the previous artifacts are %v; b.txt has changed size since it was synced", previous)
+	}
+	if previous := h.previousArtifacts(job, 1); len(previous) != 0 {
+		t.Error("the build the job is synced to reuses its own artifacts")
+	}
+}
+
+func TestReuseArtifact(t *testing.T) {
+	job := syncedJob(t)
+	defer os.RemoveAll(job.SyncDir)
+	h := (&Tracker{}).Init().SetClient(jenkins.New().SetBaseUrl("https://jenkins.example.org"))
+	log := h.buildLog(job, 2)
+	dir := filepath.Join(job.SyncDir, "2")
+	source := filepath.Join(job.SyncDir, "1", "a.txt")
+	url := "https://jenkins.example.org/job/foo/2/artifact/a.txt"
+
+	download, ok := h.reuseArtifact(log, source, downloadResult{url: url}, dir, "5D41402ABC4B2A76B9719D911017C592")
+	if !ok {
+		t.Fatal("the artifact matching its fingerprint wasn't reused")
+	}
+	if !download.linked || !download.verified {
+		t.Errorf("the reused artifact is linked %v and verified %v", download.linked, download.verified)
+	}
+	if !sameFile(t, download.path, source) {
+		t.Error("the reused artifact isn't linked to the previous build's file")
+	}
+
+	if _, ok := h.reuseArtifact(log, source, downloadResult{url: url}, dir, "0123456789abcdef0123456789abcdef"); ok {
+		t.Error("an artifact which doesn't match its fingerprint was reused")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a.txt")); !os.IsNotExist(err) {
+		t.Errorf("the mismatched copy was left in the build: %v", err)
+	}
+}
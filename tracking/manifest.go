@@ -0,0 +1,223 @@
+package tracking
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pakohler/jenkronize/jenkins"
+	"github.com/pakohler/jenkronize/logging"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// ManifestFile is the name of the manifest written into each build dir
+	ManifestFile = "manifest.json"
+	// IndexFile is the name of the index written into each job's sync dir
+	IndexFile = "index.json"
+)
+
+// BuildManifest describes where a mirrored build came from and what was
+// downloaded for it. It's written into the build's dir as manifest.json.
+type BuildManifest struct {
+	Job     string `json:"job"`
+	JobPath string `json:"job_path"`
+	Build   int32  `json:"build"`
+	Url     string `json:"url"`
+	Result  string `json:"result"`
+	// Timestamp is when the build started, and DurationMs how long it took
+	Timestamp  time.Time `json:"timestamp"`
+	DurationMs int64     `json:"duration_ms"`
+	// Commit and Branch are only set for builds from git
+	Commit     string              `json:"commit,omitempty"`
+	Branch     string              `json:"branch,omitempty"`
+	Parameters map[string]string   `json:"parameters,omitempty"`
+	Artifacts  []*ArtifactManifest `json:"artifacts"`
+	SyncedAt   time.Time           `json:"synced_at"`
+}
+
+// ArtifactManifest describes a downloaded artifact.
+type ArtifactManifest struct {
+	// Path is where the artifact was saved, relative to the build dir, and
+	// RelativePath where Jenkins archived it, relative to the workspace
+	Path         string `json:"path"`
+	RelativePath string `json:"relative_path"`
+	Size         int64  `json:"size"`
+	SHA256       string `json:"sha256"`
+	MD5          string `json:"md5"`
+	// Verified is whether the MD5 matched the fingerprint Jenkins recorded
+	// for the artifact; it's false for artifacts which weren't fingerprinted
+	Verified     bool      `json:"verified"`
+	DownloadedAt time.Time `json:"downloaded_at"`
+	DownloadMs   int64     `json:"download_ms"`
+}
+
+// JobIndex lists the cached builds of a job. It's written into the job's sync
+// dir as index.json whenever a build is added or removed.
+type JobIndex struct {
+	Job     string           `json:"job"`
+	JobPath string           `json:"job_path"`
+	Updated time.Time        `json:"updated"`
+	Builds  []*JobIndexEntry `json:"builds"`
+}
+
+// JobIndexEntry summarizes a cached build from its manifest. Builds synced
+// before manifests were written only have their number.
+type JobIndexEntry struct {
+	Build     int32      `json:"build"`
+	Url       string     `json:"url,omitempty"`
+	Result    string     `json:"result,omitempty"`
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+	Commit    string     `json:"commit,omitempty"`
+	Artifacts int        `json:"artifacts"`
+	Size      int64      `json:"size"`
+	Manifest  string     `json:"manifest,omitempty"`
+}
+
+// newBuildManifest describes the build from its details and what was
+// downloaded for it.
+func newBuildManifest(job *TrackedJob, details *jenkins.JobBuild, downloads []downloadResult) (*BuildManifest, error) {
+	manifest := &BuildManifest{
+		Job:        job.GetAlias(),
+		JobPath:    job.GetName(),
+		Build:      details.Number,
+		Url:        details.Url,
+		Result:     details.Result,
+		Timestamp:  time.Unix(0, details.Timestamp*int64(time.Millisecond)).UTC(),
+		DurationMs: details.Duration,
+		Artifacts:  []*ArtifactManifest{},
+		SyncedAt:   time.Now().UTC(),
+	}
+	if revision := details.Revision(); revision != nil {
+		manifest.Commit = revision.SHA1
+		manifest.Branch = revision.BranchName()
+	}
+	if params := details.Parameters(); len(params) > 0 {
+		manifest.Parameters = params
+	}
+	for _, download := range downloads {
+		info, err := os.Stat(download.path)
+		if err != nil {
+			return nil, err
+		}
+		manifest.Artifacts = append(manifest.Artifacts, &ArtifactManifest{
+			Path:         filepath.Base(download.path),
+			RelativePath: download.artifact.RelativePath,
+			Size:         info.Size(),
+			SHA256:       download.sha256,
+			MD5:          download.md5,
+			Verified:     download.verified,
+			DownloadedAt: download.finished.UTC(),
+			DownloadMs:   int64(download.took / time.Millisecond),
+		})
+	}
+	sort.Slice(manifest.Artifacts, func(i, j int) bool {
+		return manifest.Artifacts[i].Path < manifest.Artifacts[j].Path
+	})
+	return manifest, nil
+}
+
+// ReadManifest reads the manifest of a build dir. It returns nil without an
+// error if the build doesn't have one.
+func ReadManifest(buildDir string) (*BuildManifest, error) {
+	manifestBytes, err := ioutil.ReadFile(filepath.Join(buildDir, ManifestFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var manifest BuildManifest
+	if err := json.Unmarshal(manifestBytes, &manifest); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %v", filepath.Join(buildDir, ManifestFile), err)
+	}
+	return &manifest, nil
+}
+
+// writeManifest writes the manifest into its build's dir.
+func (h *Tracker) writeManifest(job *TrackedJob, manifest *BuildManifest) error {
+	buildDir := filepath.Join(job.SyncDir, fmt.Sprintf("%d", manifest.Build))
+	for _, artifact := range manifest.Artifacts {
+		if artifact.Path == ManifestFile {
+			// the manifest isn't worth losing an artifact over
+			return fmt.Errorf("an artifact of build %d is named %s, so the build has no manifest", manifest.Build, ManifestFile)
+		}
+	}
+	return writeJsonFile(filepath.Join(buildDir, ManifestFile), manifest)
+}
+
+// writeIndex rebuilds the index of the job's cached builds from their
+// manifests.
+func (h *Tracker) writeIndex(job *TrackedJob) error {
+	builds, err := h.CachedBuilds(job)
+	if err != nil {
+		return err
+	}
+	index := &JobIndex{
+		Job:     job.GetAlias(),
+		JobPath: job.GetName(),
+		Updated: time.Now().UTC(),
+		Builds:  []*JobIndexEntry{},
+	}
+	for _, build := range builds {
+		entry := &JobIndexEntry{Build: int32(build)}
+		index.Builds = append(index.Builds, entry)
+		manifest, err := ReadManifest(filepath.Join(job.SyncDir, strconv.Itoa(build)))
+		if err != nil {
+			h.buildLog(job, int32(build)).With(logging.Fields{"error": err}).Warn("leaving build out of the index")
+			continue
+		}
+		if manifest == nil {
+			continue
+		}
+		entry.Url = manifest.Url
+		entry.Result = manifest.Result
+		entry.Timestamp = &manifest.Timestamp
+		entry.Commit = manifest.Commit
+		entry.Artifacts = len(manifest.Artifacts)
+		for _, artifact := range manifest.Artifacts {
+			entry.Size += artifact.Size
+		}
+		entry.Manifest = strconv.Itoa(build) + "/" + ManifestFile
+	}
+	if err := os.MkdirAll(job.SyncDir, 0700); err != nil {
+		return err
+	}
+	return writeJsonFile(filepath.Join(job.SyncDir, IndexFile), index)
+}
+
+// writeJsonFile writes v as indented JSON.
+func writeJsonFile(filePath string, v interface{}) error {
+	jsonBytes, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(filePath, append(jsonBytes, '\n'))
+}
+
+// writeFile writes data via a temporary file, so readers of the mirror never
+// see a partially written file.
+func writeFile(filePath string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(filePath), "."+filepath.Base(filePath))
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	// the temporary file is only readable by us
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filePath)
+}
@@ -0,0 +1,238 @@
+package tracking
+
+import (
+	"encoding/json"
+	"github.com/pakohler/jenkronize/jenkins"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// manifestBuildJson is a build of a git job with a parameter.
+const manifestBuildJson = `{
+  "actions": [
+    {
+      "_class": "hudson.model.ParametersAction",
+      "parameters": [{"_class": "hudson.model.StringParameterValue", "name": "TARGET", "value": "linux"}]
+    },
+    {
+      "_class": "hudson.plugins.git.util.BuildData",
+      "lastBuiltRevision": {
+        "SHA1": "0123456789abcdef0123456789abcdef01234567",
+        "branch": [{"SHA1": "0123456789abcdef0123456789abcdef01234567", "name": "refs/remotes/origin/master"}]
+      }
+    }
+  ],
+  "duration": 90000,
+  "number": 3,
+  "result": "SUCCESS",
+  "timestamp": 1500000000000,
+  "url": "https://jenkins.example.org/job/foo/3/"
+}`
+
+func TestNewBuildManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jenkronize-sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	job := NewTrackedJob("/job/folder/job/foo", "foo", dir)
+	var details jenkins.JobBuild
+	if err := json.Unmarshal([]byte(manifestBuildJson), &details); err != nil {
+		t.Fatal(err)
+	}
+	finished := time.Now()
+	downloads := []downloadResult{}
+	for _, name := range []string{"b.txt", "a.txt"} {
+		filePath := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(filePath, []byte("hello"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		downloads = append(downloads, downloadResult{
+			artifact: &jenkins.Artifact{FileName: name, RelativePath: "dist/" + name},
+			path:     filePath,
+			finished: finished,
+			took:     1500 * time.Millisecond,
+			sha256:   helloSHA256,
+			md5:      helloMD5,
+			verified: name == "a.txt",
+		})
+	}
+
+	manifest, err := newBuildManifest(job, &details, downloads)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Job != "foo" || manifest.JobPath != "/job/folder/job/foo" || manifest.Build != 3 {
+		t.Errorf("the manifest is for %s (%s) #%d", manifest.Job, manifest.JobPath, manifest.Build)
+	}
+	if manifest.Url != details.Url || manifest.Result != "SUCCESS" || manifest.DurationMs != 90000 {
+		t.Errorf("the manifest doesn't describe the build: %+v", manifest)
+	}
+	if !manifest.Timestamp.Equal(time.Unix(1500000000, 0)) || manifest.Timestamp.Location() != time.UTC {
+		t.Errorf("the build started at %v", manifest.Timestamp)
+	}
+	if manifest.Commit != "0123456789abcdef0123456789abcdef01234567" || manifest.Branch != "origin/master" {
+		t.Errorf("the build is of %s on %s", manifest.Commit, manifest.Branch)
+	}
+	if len(manifest.Parameters) != 1 || manifest.Parameters["TARGET"] != "linux" {
+		t.Errorf("the parameters are %v", manifest.Parameters)
+	}
+	if len(manifest.Artifacts) != 2 {
+		t.Fatalf("the manifest has %d artifacts", len(manifest.Artifacts))
+	}
+	// the artifacts are sorted by their path
+	a, b := manifest.Artifacts[0], manifest.Artifacts[1]
+	if a.Path != "a.txt" || b.Path != "b.txt" {
+		t.Errorf("the artifacts are %s and %s", a.Path, b.Path)
+	}
+	if a.RelativePath != "dist/a.txt" || a.Size != 5 || a.SHA256 != helloSHA256 || a.MD5 != helloMD5 {
+		t.Errorf("the artifact is described as %+v", a)
+	}
+	if !a.Verified || b.Verified {
+		t.Errorf("the artifacts are verified %v and %v", a.Verified, b.Verified)
+	}
+	if a.DownloadMs != 1500 || !a.DownloadedAt.Equal(finished) {
+		t.Errorf("the artifact was downloaded at %v in %dms", a.DownloadedAt, a.DownloadMs)
+	}
+
+	// a build without git or parameters leaves them out, and the artifacts
+	// are an empty list rather than null
+	manifest, err = newBuildManifest(job, &jenkins.JobBuild{Number: 4}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"commit", "branch", "parameters"} {
+		if _, ok := fields[field]; ok {
+			t.Errorf("the manifest has a %s: %s", field, data)
+		}
+	}
+	if artifacts, ok := fields["artifacts"].([]interface{}); !ok || len(artifacts) != 0 {
+		t.Errorf("the artifacts are %v", fields["artifacts"])
+	}
+
+	// a download which has gone missing fails the manifest
+	downloads[0].path = filepath.Join(dir, "missing.txt")
+	if _, err := newBuildManifest(job, &details, downloads); err == nil {
+		t.Error("described a build with a missing artifact")
+	}
+}
+
+func TestWriteIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jenkronize-sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	job := NewTrackedJob("/job/foo", "foo", filepath.Join(dir, "foo"))
+	h := (&Tracker{}).Init()
+
+	// an index is written even before anything is synced
+	if err := h.writeIndex(job); err != nil {
+		t.Fatal(err)
+	}
+	index := readIndex(t, job)
+	if index.Job != "foo" || index.JobPath != "/job/foo" || len(index.Builds) != 0 {
+		t.Errorf("the empty index is %+v", index)
+	}
+
+	started := time.Date(2019, 7, 1, 12, 0, 0, 0, time.UTC)
+	manifest := &BuildManifest{
+		Build:     10,
+		Url:       "https://jenkins.example.org/job/foo/10/",
+		Result:    "SUCCESS",
+		Timestamp: started,
+		Commit:    "0123456",
+		Artifacts: []*ArtifactManifest{{Path: "a.txt", Size: 5}, {Path: "b.txt", Size: 7}},
+	}
+	for _, build := range []string{"9", "10", "11", "not-a-build"} {
+		if err := os.MkdirAll(filepath.Join(job.SyncDir, build), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := h.writeManifest(job, manifest); err != nil {
+		t.Fatal(err)
+	}
+	// build 11's manifest is unreadable, so it's listed by its number alone
+	if err := ioutil.WriteFile(filepath.Join(job.SyncDir, "11", ManifestFile), []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.writeIndex(job); err != nil {
+		t.Fatal(err)
+	}
+
+	index = readIndex(t, job)
+	if len(index.Builds) != 3 {
+		t.Fatalf("the index has %d builds", len(index.Builds))
+	}
+	// builds are sorted numerically, and those without a manifest only have
+	// their number
+	for i, build := range []int32{9, 10, 11} {
+		if index.Builds[i].Build != build {
+			t.Errorf("build %d of the index is %d", i, index.Builds[i].Build)
+		}
+	}
+	for _, entry := range []*JobIndexEntry{index.Builds[0], index.Builds[2]} {
+		if entry.Manifest != "" || entry.Timestamp != nil || entry.Artifacts != 0 {
+			t.Errorf("build %d without a manifest is indexed as %+v", entry.Build, entry)
+		}
+	}
+	entry := index.Builds[1]
+	if entry.Url != manifest.Url || entry.Result != "SUCCESS" || entry.Commit != "0123456" {
+		t.Errorf("build 10 is indexed as %+v", entry)
+	}
+	if entry.Timestamp == nil || !entry.Timestamp.Equal(started) {
+		t.Errorf("build 10 started at %v", entry.Timestamp)
+	}
+	if entry.Artifacts != 2 || entry.Size != 12 {
+		t.Errorf("build 10 has %d artifacts of %d bytes", entry.Artifacts, entry.Size)
+	}
+	if entry.Manifest != "10/"+ManifestFile {
+		t.Errorf("the manifest of build 10 is at %q", entry.Manifest)
+	}
+}
+
+func TestWriteManifestNamedLikeArtifact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jenkronize-sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	job := NewTrackedJob("/job/foo", "foo", dir)
+	buildDir := filepath.Join(dir, "1")
+	if err := os.MkdirAll(buildDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(buildDir, ManifestFile), []byte("an artifact"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	manifest := &BuildManifest{Build: 1, Artifacts: []*ArtifactManifest{{Path: ManifestFile}}}
+	if err := (&Tracker{}).Init().writeManifest(job, manifest); err == nil {
+		t.Error("wrote a manifest over an artifact")
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(buildDir, ManifestFile)); string(data) != "an artifact" {
+		t.Errorf("the artifact was overwritten with %q", data)
+	}
+}
+
+func readIndex(t *testing.T, job *TrackedJob) *JobIndex {
+	data, err := ioutil.ReadFile(filepath.Join(job.SyncDir, IndexFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var index JobIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		t.Fatal(err)
+	}
+	return &index
+}
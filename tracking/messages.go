@@ -0,0 +1,230 @@
+package tracking
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/pakohler/jenkronize/notifications"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// MessageData holds the fields available to every message template. Fields
+// which don't apply to a message are left at their zero value; eg. `Error` is
+// only set for failures.
+type MessageData struct {
+	// Job is the alias of the tracked job.
+	Job string
+	// JobPath is the path of the job on the Jenkins server, eg. /job/foo/job/bar.
+	JobPath string
+	// JenkinsUrl is the base URL of the Jenkins server.
+	JenkinsUrl string
+	// BuildNumber is the number of the build the message is about.
+	BuildNumber int32
+	// LastBuildNumber is the number of the last build synced for the job.
+	LastBuildNumber int32
+	// BuildUrl is the URL of the build on the Jenkins server.
+	BuildUrl string
+	// MirrorUrl is the public URL of the mirrored build, if `public_url` is set.
+	MirrorUrl string
+	// ArtifactCount is the number of artifacts synced.
+	ArtifactCount int
+	// ArtifactBytes is the total size of the synced artifacts in bytes.
+	ArtifactBytes int64
+	// ArtifactSize is ArtifactBytes in human-readable form, eg. 1.2 GiB.
+	ArtifactSize string
+	// ReusedCount is the number of artifacts reused from earlier builds rather
+	// than downloaded, and SavedBytes their total size in bytes.
+	ReusedCount int
+	SavedBytes  int64
+	// SavedSize is SavedBytes in human-readable form; it's empty if nothing
+	// was reused.
+	SavedSize string
+	// Duration is how long the sync took.
+	Duration time.Duration
+	// Result is the result of the build, eg. SUCCESS or UNSTABLE.
+	Result string
+	// Commit is the git commit the build was built from, and ShortCommit its
+	// first 7 characters; both are empty if it wasn't built from git.
+	Commit      string
+	ShortCommit string
+	// Branch is the branch the commit was built from, eg. origin/master.
+	Branch string
+	// StartedBy is the user who started the build, or the upstream build which
+	// triggered it, eg. `alice` or `upstream-job #12`.
+	StartedBy string
+	// Cause is Jenkins' description of why the build was started, eg.
+	// "Started by an SCM change".
+	Cause string
+	// ChangeCount is the number of commits since the previous build.
+	ChangeCount int
+	// Authors are the authors of those commits, eg. `alice, bob`.
+	Authors string
+	// Tests summarizes the tests run by the build, eg. "120 tests, 2 failed",
+	// and TestsFailed is the number which failed.
+	Tests       string
+	TestsFailed int
+	// Error is the text of the error that caused the message.
+	Error string
+	// SuppressedCount is the number of notifications held back by the rate
+	// limit during the last Period, for `suppressed` messages. SuppressedTypes
+	// counts them by message, eg. `2 sync_failed, 1 api_error`, and
+	// SuppressedJobs lists the jobs they were about.
+	SuppressedCount int
+	Period          time.Duration
+	SuppressedTypes string
+	SuppressedJobs  string
+	// Condition is the problem which has cleared, for `resolved` messages; it is
+	// the name of the message which reported the problem, eg. dns_failure.
+	Condition string
+}
+
+var defaultMessages = map[notifications.EventType]string{
+	notifications.EventNewBuild: `{{.Job}} - new build number {{.BuildNumber}} detected
+{{- with .ShortCommit}}, built from commit {{.}}{{end}}
+{{- with or .StartedBy .Authors}} by {{.}}{{end}} - last tracked was {{.LastBuildNumber}}. Downloading artifacts...`,
+
+	notifications.EventSyncComplete: `{{.Job}} - completed downloading artifacts for build number {{.BuildNumber}}
+{{- with .ShortCommit}} (commit {{.}}){{end}}.{{with .Tests}} Tests: {{.}}.{{end}}
+{{- with .SavedSize}} Reused {{$.ReusedCount}} unchanged artifacts ({{.}}) instead of downloading them.{{end}}`,
+
+	notifications.EventSyncFailed: `{{.Job}} - artifact download for build number {{.BuildNumber}} failed on one or more artifacts; will retry after wait interval.`,
+
+	notifications.EventDownloadFailed: `{{.Error}}`,
+
+	notifications.EventUnverified: `{{.Job}} - unable to get the fingerprints of build number {{.BuildNumber}}, so its artifacts won't be verified against them: {{.Error}}`,
+
+	notifications.EventDiskFull: `{{.Job}} - downloads failed due to disk being full; please clean up disk space and reduce builds_to_cache for job`,
+
+	notifications.EventDnsFailure: `DNS lookup failed for Jenkins server {{.JenkinsUrl}} - check your VPN, DNS, or network connectivity`,
+
+	notifications.EventHtmlResponse: `{{.Job}} - received HTML instead of JSON when attempting to check for latest build via Jenkins API. This is usually an intermittent issue which should resolve itself. Will try again after interval.`,
+
+	notifications.EventApiError: `{{.Error}}`,
+
+	notifications.EventJobDiscovered: `{{.Job}} - discovered new job {{.JobPath}}; it will be tracked from now on.`,
+
+	notifications.EventSuppressed: `{{.SuppressedCount}} notifications were held back by the rate limit during the last {{.Period}}: {{.SuppressedTypes}}
+{{- with .SuppressedJobs}} (jobs: {{.}}){{end}}`,
+
+	notifications.EventResolved: `{{if eq .Condition "dns_failure" -}}
+DNS lookup for Jenkins server {{.JenkinsUrl}} is working again
+{{- else if eq .Condition "html_response" -}}
+{{.Job}} - the Jenkins API is returning JSON again
+{{- else if eq .Condition "api_error" -}}
+{{.Job}} - the Jenkins API is reachable again
+{{- else if eq .Condition "disk_full" -}}
+{{.Job}} - disk space is available again
+{{- else if eq .Condition "download_failed" -}}
+{{.Job}} - artifact downloads are succeeding again
+{{- else if eq .Condition "unverified_downloads" -}}
+{{.Job}} - build fingerprints are available again, so downloads are verified
+{{- else -}}
+{{.Job}} - artifacts synced successfully after earlier failures
+{{- end}}`,
+}
+
+// sampleMessageData is used to check that templates can be executed at startup.
+var sampleMessageData = &MessageData{
+	Job:             "example",
+	JobPath:         "/job/example",
+	JenkinsUrl:      "https://jenkins.example.org",
+	BuildNumber:     42,
+	LastBuildNumber: 41,
+	BuildUrl:        "https://jenkins.example.org/job/example/42/",
+	MirrorUrl:       "https://mirror.example.org/example/42/",
+	ArtifactCount:   3,
+	ArtifactBytes:   1 << 20,
+	ArtifactSize:    notifications.FormatBytes(1 << 20),
+	ReusedCount:     1,
+	SavedBytes:      1 << 19,
+	SavedSize:       notifications.FormatBytes(1 << 19),
+	Duration:        time.Minute,
+	Result:          "SUCCESS",
+	Commit:          "0123456789abcdef0123456789abcdef01234567",
+	ShortCommit:     "0123456",
+	Branch:          "origin/master",
+	StartedBy:       "alice",
+	Cause:           "Started by user alice",
+	ChangeCount:     2,
+	Authors:         "alice, bob",
+	Tests:           "120 tests, 2 failed",
+	TestsFailed:     2,
+	SuppressedCount: 3,
+	Period:          time.Hour,
+	SuppressedTypes: "2 sync_failed, 1 api_error",
+	SuppressedJobs:  "example",
+	Error:           "example error",
+	Condition:       string(notifications.EventDnsFailure),
+}
+
+var messageFuncs = template.FuncMap{
+	"bytes": notifications.FormatBytes,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+}
+
+// Messages renders the text of every notification the tracker sends.
+type Messages struct {
+	templates map[notifications.EventType]*template.Template
+}
+
+// NewMessages parses the default message templates along with any overrides,
+// which are keyed by message name. Every template is executed against sample
+// data, so mistakes are reported at startup rather than when a notification is
+// sent.
+func NewMessages(overrides map[string]string) (*Messages, error) {
+	m := &Messages{
+		templates: map[notifications.EventType]*template.Template{},
+	}
+	for eventType, text := range defaultMessages {
+		tmpl, err := parseMessage(string(eventType), text)
+		if err != nil {
+			return nil, err
+		}
+		m.templates[eventType] = tmpl
+	}
+	names := []string{}
+	for name := range overrides {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, ok := defaultMessages[notifications.EventType(name)]; !ok {
+			return nil, fmt.Errorf("unknown message %q", name)
+		}
+		tmpl, err := parseMessage(name, overrides[name])
+		if err != nil {
+			return nil, err
+		}
+		m.templates[notifications.EventType(name)] = tmpl
+	}
+	return m, nil
+}
+
+func parseMessage(name string, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(messageFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("message %q: %v", name, err)
+	}
+	if err := tmpl.Execute(&bytes.Buffer{}, sampleMessageData); err != nil {
+		return nil, fmt.Errorf("message %q: %v", name, err)
+	}
+	return tmpl, nil
+}
+
+// Render returns the text of the message for the event type. Should a custom
+// template fail, the error is included in the returned text so the message
+// isn't lost entirely.
+func (m *Messages) Render(eventType notifications.EventType, data *MessageData) string {
+	tmpl, ok := m.templates[eventType]
+	if !ok {
+		return fmt.Sprintf("%s - %s", data.Job, eventType)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return fmt.Sprintf("%s - %s (message template failed: %v)", data.Job, eventType, err)
+	}
+	return buf.String()
+}
@@ -0,0 +1,146 @@
+package tracking
+
+import (
+	"github.com/pakohler/jenkronize/notifications"
+	"testing"
+	"time"
+)
+
+func TestSuppressedMessage(t *testing.T) {
+	messages, err := NewMessages(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := &MessageData{
+		SuppressedCount: 3,
+		Period:          time.Hour,
+		SuppressedTypes: "2 sync_failed, 1 api_error",
+		SuppressedJobs:  "installer",
+	}
+	expected := "3 notifications were held back by the rate limit during the last 1h0m0s: 2 sync_failed, 1 api_error (jobs: installer)"
+	if text := messages.Render(notifications.EventSuppressed, data); text != expected {
+		t.Errorf("rendered %q, expected %q", text, expected)
+	}
+
+	messages, err = NewMessages(map[string]string{"suppressed": "{{.SuppressedCount}} held back"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := messages.Render(notifications.EventSuppressed, data); text != "3 held back" {
+		t.Errorf("the overridden message rendered %q", text)
+	}
+}
+
+func TestUnknownMessage(t *testing.T) {
+	if _, err := NewMessages(map[string]string{"nonsense": "text"}); err == nil {
+		t.Error("an override of an unknown message was accepted")
+	}
+	if _, err := NewMessages(map[string]string{"sync_failed": "{{.NoSuchField}}"}); err == nil {
+		t.Error("an override using an unknown field was accepted")
+	}
+}
+
+func TestDefaultMessages(t *testing.T) {
+	messages, err := NewMessages(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// each message rendered with every field set, and with none of them set
+	tests := []struct {
+		eventType notifications.EventType
+		full      string
+		empty     string
+	}{
+		{
+			notifications.EventNewBuild,
+			"example - new build number 42 detected, built from commit 0123456 by alice - last tracked was 41. Downloading artifacts...",
+			" - new build number 0 detected - last tracked was 0. Downloading artifacts...",
+		},
+		{
+			notifications.EventSyncComplete,
+			"example - completed downloading artifacts for build number 42 (commit 0123456). Tests: 120 tests, 2 failed. Reused 1 unchanged artifacts (512.0 KiB) instead of downloading them.",
+			" - completed downloading artifacts for build number 0.",
+		},
+		{
+			notifications.EventSyncFailed,
+			"example - artifact download for build number 42 failed on one or more artifacts; will retry after wait interval.",
+			" - artifact download for build number 0 failed on one or more artifacts; will retry after wait interval.",
+		},
+		{notifications.EventDownloadFailed, "example error", ""},
+		{
+			notifications.EventUnverified,
+			"example - unable to get the fingerprints of build number 42, so its artifacts won't be verified against them: example error",
+			" - unable to get the fingerprints of build number 0, so its artifacts won't be verified against them: ",
+		},
+		{
+			notifications.EventDiskFull,
+			"example - downloads failed due to disk being full; please clean up disk space and reduce builds_to_cache for job",
+			" - downloads failed due to disk being full; please clean up disk space and reduce builds_to_cache for job",
+		},
+		{
+			notifications.EventDnsFailure,
+			"DNS lookup failed for Jenkins server https://jenkins.example.org - check your VPN, DNS, or network connectivity",
+			"DNS lookup failed for Jenkins server  - check your VPN, DNS, or network connectivity",
+		},
+		{
+			notifications.EventHtmlResponse,
+			"example - received HTML instead of JSON when attempting to check for latest build via Jenkins API. This is usually an intermittent issue which should resolve itself. Will try again after interval.",
+			" - received HTML instead of JSON when attempting to check for latest build via Jenkins API. This is usually an intermittent issue which should resolve itself. Will try again after interval.",
+		},
+		{notifications.EventApiError, "example error", ""},
+		{
+			notifications.EventJobDiscovered,
+			"example - discovered new job /job/example; it will be tracked from now on.",
+			" - discovered new job ; it will be tracked from now on.",
+		},
+		{
+			notifications.EventSuppressed,
+			"3 notifications were held back by the rate limit during the last 1h0m0s: 2 sync_failed, 1 api_error (jobs: example)",
+			"0 notifications were held back by the rate limit during the last 0s: ",
+		},
+		{
+			notifications.EventResolved,
+			"DNS lookup for Jenkins server https://jenkins.example.org is working again",
+			" - artifacts synced successfully after earlier failures",
+		},
+	}
+	tested := map[notifications.EventType]bool{}
+	for _, test := range tests {
+		tested[test.eventType] = true
+		if text := messages.Render(test.eventType, sampleMessageData); text != test.full {
+			t.Errorf("%s rendered %q, expected %q", test.eventType, text, test.full)
+		}
+		if text := messages.Render(test.eventType, &MessageData{}); text != test.empty {
+			t.Errorf("%s rendered %q without data, expected %q", test.eventType, text, test.empty)
+		}
+	}
+	for eventType := range defaultMessages {
+		if !tested[eventType] {
+			t.Errorf("the %s message isn't tested", eventType)
+		}
+	}
+}
+
+func TestResolvedMessages(t *testing.T) {
+	messages, err := NewMessages(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		condition notifications.EventType
+		text      string
+	}{
+		{notifications.EventHtmlResponse, "foo - the Jenkins API is returning JSON again"},
+		{notifications.EventApiError, "foo - the Jenkins API is reachable again"},
+		{notifications.EventDiskFull, "foo - disk space is available again"},
+		{notifications.EventDownloadFailed, "foo - artifact downloads are succeeding again"},
+		{notifications.EventUnverified, "foo - build fingerprints are available again, so downloads are verified"},
+		{notifications.EventSyncFailed, "foo - artifacts synced successfully after earlier failures"},
+	}
+	for _, test := range tests {
+		data := &MessageData{Job: "foo", Condition: string(test.condition)}
+		if text := messages.Render(notifications.EventResolved, data); text != test.text {
+			t.Errorf("the resolution of %s rendered %q, expected %q", test.condition, text, test.text)
+		}
+	}
+}
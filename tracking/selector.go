@@ -0,0 +1,373 @@
+package tracking
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/pakohler/jenkronize/jenkins"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// SelectorData is what the alias, sync_dir and public_url templates of a
+// selector are executed against for each job it matches.
+type SelectorData struct {
+	// Name is the path of the matched job, eg. /job/foo/job/release-1
+	Name string
+	// Names are the names of the job and the folders it's in, eg. foo and
+	// release-1
+	Names []string
+	// Matches are the names matched by the wildcards of a name_pattern, or
+	// the groups captured by a name_regex, which is matched against the
+	// escaped path
+	Matches []string
+	// Groups are the named groups captured by a name_regex
+	Groups map[string]string
+}
+
+// JobSelector expands a tracked job with a name_pattern, a name_regex or
+// multibranch into the jobs it matches on the Jenkins server.
+type JobSelector struct {
+	job       *TrackedJob
+	segments  []string
+	regex     *regexp.Regexp
+	project   string
+	alias     *template.Template
+	syncDir   *template.Template
+	publicUrl *template.Template
+}
+
+// NewJobSelector checks the pattern and templates of a selector job.
+func NewJobSelector(job *TrackedJob) (*JobSelector, error) {
+	s := &JobSelector{job: job}
+	switch {
+	case job.Multibranch && (job.NamePattern != "" || job.NameRegex != ""):
+		return nil, fmt.Errorf("multibranch can't be combined with a name_pattern or name_regex")
+	case job.Multibranch:
+		// the branches are synced into dirs named after them, so nothing is
+		// templated
+		if job.Name == "" {
+			return nil, fmt.Errorf("name: the path of the multibranch project or organization folder is required, eg. /job/foo")
+		}
+		s.project = strings.TrimRight(job.Name, "/")
+		return s, nil
+	case job.NamePattern != "" && job.NameRegex != "":
+		return nil, fmt.Errorf("only one of name_pattern or name_regex may be set")
+	case job.NamePattern != "":
+		s.segments = jobNames(job.NamePattern)
+		if len(s.segments) == 0 {
+			return nil, fmt.Errorf("name_pattern %q doesn't select any jobs, eg. /job/*/job/release-*", job.NamePattern)
+		}
+		for _, segment := range s.segments {
+			if _, err := path.Match(segment, ""); err != nil {
+				return nil, fmt.Errorf("name_pattern: %v in %q", err, job.NamePattern)
+			}
+		}
+	case job.NameRegex != "":
+		if _, err := regexp.Compile(job.NameRegex); err != nil {
+			return nil, fmt.Errorf("name_regex: %v", err)
+		}
+		// it has to match the whole path
+		s.regex = regexp.MustCompile("^(?:" + job.NameRegex + ")$")
+	default:
+		return nil, fmt.Errorf("one of name_pattern or name_regex must be set")
+	}
+	if !strings.Contains(job.SyncDir, "{{") {
+		return nil, fmt.Errorf("sync_dir must be a template, eg. /opt/jenkins-sync/{{index .Matches 0}}, so each job it matches has its own dir")
+	}
+	// the templates are tried against a match of the right shape, so mistakes
+	// such as indexing past the matches are caught up front
+	sample := s.sample()
+	var err error
+	if s.alias, err = parseSelectorTemplate("alias", job.Alias, sample); err != nil {
+		return nil, err
+	}
+	if s.syncDir, err = parseSelectorTemplate("sync_dir", job.SyncDir, sample); err != nil {
+		return nil, err
+	}
+	if s.publicUrl, err = parseSelectorTemplate("public_url", job.PublicUrl, sample); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// sample returns template data shaped like that of a match.
+func (s *JobSelector) sample() *SelectorData {
+	data := &SelectorData{Names: []string{}, Matches: []string{}, Groups: map[string]string{}}
+	if s.regex != nil {
+		data.Matches = make([]string, s.regex.NumSubexp())
+		for _, name := range s.regex.SubexpNames() {
+			if name != "" {
+				data.Groups[name] = ""
+			}
+		}
+		return data
+	}
+	data.Names = make([]string, len(s.segments))
+	for _, segment := range s.segments {
+		if strings.ContainsAny(segment, "*?[") {
+			data.Matches = append(data.Matches, "")
+		}
+	}
+	return data
+}
+
+func parseSelectorTemplate(name string, text string, sample *SelectorData) (*template.Template, error) {
+	t, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	if err := t.Execute(&bytes.Buffer{}, sample); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return t, nil
+}
+
+// Pattern returns the name_pattern or name_regex of the selector, or the
+// path of its multibranch project.
+func (s *JobSelector) Pattern() string {
+	switch {
+	case s.project != "":
+		return s.project
+	case s.regex != nil:
+		return s.job.NameRegex
+	}
+	return s.job.NamePattern
+}
+
+// IsMultibranch reports whether the selector is for the branches of a
+// multibranch project or organization folder.
+func (s *JobSelector) IsMultibranch() bool {
+	return s.project != ""
+}
+
+// BranchRetention is how long the builds of a deleted branch are kept for; zero
+// keeps them forever.
+func (s *JobSelector) BranchRetention() time.Duration {
+	return s.job.BranchRetention
+}
+
+// isPullRequest reports whether the branch job is for a pull or merge request,
+// going by the names given to them by the GitHub, Bitbucket and GitLab branch
+// sources.
+func isPullRequest(name string) bool {
+	return strings.HasPrefix(name, "PR-") || strings.HasPrefix(name, "MR-")
+}
+
+// match returns what the templates are executed against if the selector
+// matches the job path.
+func (s *JobSelector) match(jobPath string) (*SelectorData, bool) {
+	names := jobNames(jobPath)
+	data := &SelectorData{Name: jobPath, Names: names, Matches: []string{}, Groups: map[string]string{}}
+	if s.project != "" {
+		// a branch of the project, or of a repo in the organization folder
+		if !strings.HasPrefix(jobPath, s.project+"/job/") {
+			return nil, false
+		}
+		data.Matches = names[len(jobNames(s.project)):]
+		if len(data.Matches) > 2 || (!s.job.PullRequests && isPullRequest(data.Matches[len(data.Matches)-1])) {
+			return nil, false
+		}
+		return data, true
+	}
+	if s.regex != nil {
+		m := s.regex.FindStringSubmatch(jobPath)
+		if m == nil {
+			return nil, false
+		}
+		// the path is escaped, but the names in it are unescaped for templates
+		for i := range m {
+			if name, err := url.PathUnescape(m[i]); err == nil {
+				m[i] = name
+			}
+		}
+		data.Matches = m[1:]
+		for i, name := range s.regex.SubexpNames() {
+			if name != "" {
+				data.Groups[name] = m[i]
+			}
+		}
+		return data, true
+	}
+	if len(names) != len(s.segments) {
+		return nil, false
+	}
+	for i, segment := range s.segments {
+		if ok, _ := path.Match(segment, names[i]); !ok {
+			return nil, false
+		}
+		if strings.ContainsAny(segment, "*?[") {
+			data.Matches = append(data.Matches, names[i])
+		}
+	}
+	return data, true
+}
+
+// Expand returns a job to track for the job path, if the selector matches it.
+func (s *JobSelector) Expand(jobPath string) (*TrackedJob, bool, error) {
+	data, ok := s.match(jobPath)
+	if !ok {
+		return nil, false, nil
+	}
+	job := &TrackedJob{
+		Name:             jobPath,
+		BuildsToCache:    s.job.BuildsToCache,
+		BuildSelection:   s.job.BuildSelection,
+		AllowedResults:   s.job.AllowedResults,
+		Promotion:        s.job.Promotion,
+		Mode:             s.job.Mode,
+		InitialBackfill:  s.job.InitialBackfill,
+		PinBuild:         s.job.PinBuild,
+		MatchParameters:  s.job.MatchParameters,
+		MatchDescription: s.job.MatchDescription,
+		MatchDisplayName: s.job.MatchDisplayName,
+		selector:         s,
+	}
+	if s.project != "" {
+		s.expandBranch(job, data.Matches)
+		return job, true, nil
+	}
+	var err error
+	if job.Alias, err = executeSelectorTemplate(s.alias, data); err != nil {
+		return nil, true, err
+	}
+	if job.SyncDir, err = executeSelectorTemplate(s.syncDir, data); err != nil {
+		return nil, true, err
+	}
+	if job.PublicUrl, err = executeSelectorTemplate(s.publicUrl, data); err != nil {
+		return nil, true, err
+	}
+	job.Init()
+	return job, true, nil
+}
+
+// expandBranch names the job of a branch after its project, and syncs it into
+// a dir named after the branch, within the sync_dir of the project. A branch
+// of a repo in an organization folder is in a dir of the repo.
+func (s *JobSelector) expandBranch(job *TrackedJob, branch []string) {
+	alias := s.project
+	if s.job.Alias != "" {
+		alias = strings.TrimRight(s.job.Alias, "/")
+	}
+	job.Alias = strings.Join(append([]string{alias}, branch...), "::")
+	job.SyncDir = filepath.Join(append([]string{s.job.SyncDir}, branch...)...)
+	if s.job.PublicUrl != "" {
+		escaped := make([]string, len(branch))
+		for i, name := range branch {
+			escaped[i] = url.PathEscape(name)
+		}
+		job.PublicUrl = strings.TrimRight(s.job.PublicUrl, "/") + "/" + strings.Join(escaped, "/")
+	}
+	job.Init()
+}
+
+func executeSelectorTemplate(t *template.Template, data *SelectorData) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Discover walks the Jenkins folder tree for the jobs the selector matches.
+// Only the folders which can contain a match are walked for a name_pattern,
+// but the whole server is walked for a name_regex.
+func (s *JobSelector) Discover(client *jenkins.JenkinsAPIClient) ([]string, error) {
+	var jobPaths []string
+	var err error
+	switch {
+	case s.project != "":
+		jobPaths, err = s.walkProject(client)
+	case s.regex == nil:
+		return s.walk(client, "", s.segments)
+	default:
+		jobPaths, err = client.DiscoverJobs("")
+	}
+	if err != nil {
+		return nil, err
+	}
+	matched := []string{}
+	for _, jobPath := range jobPaths {
+		if _, ok := s.match(jobPath); ok {
+			matched = append(matched, jobPath)
+		}
+	}
+	return matched, nil
+}
+
+func (s *JobSelector) walk(client *jenkins.JenkinsAPIClient, folder string, segments []string) ([]string, error) {
+	jobs, err := client.GetJobs(folder)
+	if err != nil {
+		return nil, err
+	}
+	matched := []string{}
+	for _, job := range jobs {
+		if ok, _ := path.Match(segments[0], job.Name); !ok {
+			continue
+		}
+		jobPath := folder + "/job/" + url.PathEscape(job.Name)
+		if len(segments) == 1 {
+			// folders can't be synced themselves
+			if job.Jobs == nil {
+				matched = append(matched, jobPath)
+			}
+			continue
+		}
+		if job.Jobs == nil {
+			continue
+		}
+		subMatched, err := s.walk(client, jobPath, segments[1:])
+		if err != nil {
+			return nil, err
+		}
+		matched = append(matched, subMatched...)
+	}
+	return matched, nil
+}
+
+// walkProject lists the branches of a multibranch project, or of each repo in
+// an organization folder.
+func (s *JobSelector) walkProject(client *jenkins.JenkinsAPIClient) ([]string, error) {
+	jobs, err := client.GetJobs(s.project)
+	if err != nil {
+		return nil, err
+	}
+	branches := []string{}
+	for _, job := range jobs {
+		jobPath := s.project + "/job/" + url.PathEscape(job.Name)
+		if job.Jobs == nil {
+			branches = append(branches, jobPath)
+			continue
+		}
+		repo, err := client.GetJobs(jobPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, branch := range repo {
+			if branch.Jobs == nil {
+				branches = append(branches, jobPath+"/job/"+url.PathEscape(branch.Name))
+			}
+		}
+	}
+	return branches, nil
+}
+
+// jobNames returns the names of a job and the folders it's in, from its path.
+func jobNames(jobPath string) []string {
+	names := []string{}
+	for _, part := range strings.Split(jobPath, "/job/") {
+		part = strings.Trim(part, "/")
+		if part == "" {
+			continue
+		}
+		if name, err := url.PathUnescape(part); err == nil {
+			part = name
+		}
+		names = append(names, part)
+	}
+	return names
+}
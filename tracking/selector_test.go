@@ -0,0 +1,300 @@
+package tracking
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewJobSelector(t *testing.T) {
+	tests := []struct {
+		job   TrackedJob
+		valid bool
+	}{
+		{TrackedJob{NamePattern: "/job/*/job/release-*", SyncDir: "/opt/sync/{{index .Matches 0}}"}, true},
+		{TrackedJob{NameRegex: "/job/(?P<team>[^/]+)/job/.*", SyncDir: "/opt/sync/{{.Groups.team}}"}, true},
+		{TrackedJob{Name: "/job/foo", Multibranch: true, SyncDir: "/opt/sync/foo"}, true},
+		// neither or both patterns
+		{TrackedJob{SyncDir: "/opt/sync/{{.Name}}"}, false},
+		{TrackedJob{NamePattern: "/job/*", NameRegex: ".*", SyncDir: "/opt/sync/{{.Name}}"}, false},
+		{TrackedJob{Name: "/job/foo", Multibranch: true, NamePattern: "/job/*", SyncDir: "/opt/sync/foo"}, false},
+		{TrackedJob{Multibranch: true, SyncDir: "/opt/sync/foo"}, false},
+		// bad patterns
+		{TrackedJob{NamePattern: "/", SyncDir: "/opt/sync/{{.Name}}"}, false},
+		{TrackedJob{NamePattern: "/job/[", SyncDir: "/opt/sync/{{.Name}}"}, false},
+		{TrackedJob{NameRegex: "/job/(", SyncDir: "/opt/sync/{{.Name}}"}, false},
+		// every match would share the sync dir
+		{TrackedJob{NamePattern: "/job/*", SyncDir: "/opt/sync"}, false},
+		// indexing past the matches, or a group which isn't captured
+		{TrackedJob{NamePattern: "/job/*", SyncDir: "/opt/sync/{{index .Matches 1}}"}, false},
+		{TrackedJob{NameRegex: "/job/(.*)", SyncDir: "/opt/sync/{{.Groups.team}}"}, false},
+		{TrackedJob{NamePattern: "/job/*", SyncDir: "/opt/sync/{{.Name", Alias: "x"}, false},
+	}
+	for _, test := range tests {
+		job := test.job
+		if _, err := NewJobSelector(&job); (err == nil) != test.valid {
+			t.Errorf("NewJobSelector(%+v) returned %v", test.job, err)
+		}
+	}
+}
+
+func TestSelectorNamePattern(t *testing.T) {
+	selector, err := NewJobSelector(&TrackedJob{
+		NamePattern:   "/job/*/job/release-*",
+		Alias:         "{{index .Matches 0}} {{index .Matches 1}}",
+		SyncDir:       "/opt/sync/{{index .Names 0}}/{{index .Matches 1}}",
+		PublicUrl:     "https://mirror.example.org/{{index .Matches 0}}",
+		BuildsToCache: 3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		jobPath string
+		match   bool
+	}{
+		{"/job/installer/job/release-5.2", true},
+		{"/job/my%20team/job/release-1", true},
+		{"/job/installer/job/master", false},
+		{"/job/installer", false},
+		{"/job/a/job/b/job/release-1", false},
+	}
+	for _, test := range tests {
+		if _, ok := selector.match(test.jobPath); ok != test.match {
+			t.Errorf("match(%q) is %v", test.jobPath, ok)
+		}
+	}
+
+	job, ok, err := selector.Expand("/job/my%20team/job/release-1")
+	if err != nil || !ok {
+		t.Fatalf("Expand returned %v, %v", ok, err)
+	}
+	if job.Name != "/job/my%20team/job/release-1" {
+		t.Errorf("name is %q", job.Name)
+	}
+	if job.Alias != "my team release-1" {
+		t.Errorf("alias is %q", job.Alias)
+	}
+	if job.SyncDir != "/opt/sync/my team/release-1" {
+		t.Errorf("sync dir is %q", job.SyncDir)
+	}
+	if job.PublicUrl != "https://mirror.example.org/my team" {
+		t.Errorf("public url is %q", job.PublicUrl)
+	}
+	if job.BuildsToCache != 3 || job.Build == nil {
+		t.Errorf("the job wasn't set up like its selector: %+v", job)
+	}
+
+	if _, ok, _ := selector.Expand("/job/installer/job/master"); ok {
+		t.Error("expanded a job the selector doesn't match")
+	}
+}
+
+func TestSelectorNameRegex(t *testing.T) {
+	selector, err := NewJobSelector(&TrackedJob{
+		NameRegex: "/job/(?P<team>[^/]+)/job/(release-[^/]*)",
+		SyncDir:   "/opt/sync/{{.Groups.team}}/{{index .Matches 1}}",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the regex has to match the whole path
+	if _, ok := selector.match("/job/installer/job/release-1/job/extra"); ok {
+		t.Error("matched part of the path")
+	}
+	job, ok, err := selector.Expand("/job/my%20team/job/release-1")
+	if err != nil || !ok {
+		t.Fatalf("Expand returned %v, %v", ok, err)
+	}
+	if job.SyncDir != "/opt/sync/my team/release-1" {
+		t.Errorf("sync dir is %q", job.SyncDir)
+	}
+	if job.Alias != "/job/my%20team/job/release-1" {
+		t.Errorf("alias is %q, expected the job's name", job.Alias)
+	}
+}
+
+func TestJobNames(t *testing.T) {
+	names := jobNames("/job/my%20team/job/release-1/")
+	if len(names) != 2 || names[0] != "my team" || names[1] != "release-1" {
+		t.Errorf("names are %q", names)
+	}
+}
+
+func TestSelectorMultibranch(t *testing.T) {
+	selector, err := NewJobSelector(&TrackedJob{
+		Name:        "/job/org/",
+		Multibranch: true,
+		SyncDir:     "/opt/sync/org",
+		PublicUrl:   "https://mirror.example.org/org/",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		jobPath string
+		match   bool
+	}{
+		// a branch of a multibranch project, or of a repo in an organization folder
+		{"/job/org/job/master", true},
+		{"/job/org/job/repo/job/feature%2Fx", true},
+		{"/job/org/job/repo/job/PR-12", false},
+		{"/job/org/job/MR-3", false},
+		{"/job/org/job/a/job/b/job/c", false},
+		{"/job/org", false},
+		{"/job/organization/job/master", false},
+	}
+	for _, test := range tests {
+		if _, ok := selector.match(test.jobPath); ok != test.match {
+			t.Errorf("match(%q) is %v", test.jobPath, ok)
+		}
+	}
+
+	job, ok, err := selector.Expand("/job/org/job/repo/job/feature%2Fx")
+	if err != nil || !ok {
+		t.Fatalf("Expand returned %v, %v", ok, err)
+	}
+	if job.Alias != "/job/org::repo::feature/x" {
+		t.Errorf("alias is %q", job.Alias)
+	}
+	if job.SyncDir != filepath.Join("/opt/sync/org", "repo", "feature/x") {
+		t.Errorf("sync dir is %q", job.SyncDir)
+	}
+	if job.PublicUrl != "https://mirror.example.org/org/repo/feature%2Fx" {
+		t.Errorf("public url is %q", job.PublicUrl)
+	}
+}
+
+func TestSelectorPullRequests(t *testing.T) {
+	selector, err := NewJobSelector(&TrackedJob{
+		Name:         "/job/foo",
+		Alias:        "foo/",
+		Multibranch:  true,
+		PullRequests: true,
+		SyncDir:      "/opt/sync/foo",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, ok, err := selector.Expand("/job/foo/job/PR-12")
+	if err != nil || !ok {
+		t.Fatalf("Expand returned %v, %v", ok, err)
+	}
+	if job.Alias != "foo::PR-12" {
+		t.Errorf("alias is %q", job.Alias)
+	}
+	if job.PublicUrl != "" {
+		t.Errorf("public url is %q, expected none", job.PublicUrl)
+	}
+}
+
+func TestRetireBranchesWithoutBranches(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jenkronize-tracking")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	selector, err := NewJobSelector(&TrackedJob{
+		Name:            "/job/foo",
+		Multibranch:     true,
+		SyncDir:         filepath.Join(dir, "foo"),
+		BranchRetention: time.Nanosecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := (&Tracker{}).Init().SetStateFile(filepath.Join(dir, "state.json"))
+	branches := []string{"/job/foo/job/master", "/job/foo/job/develop"}
+	for _, branch := range branches {
+		job, _, err := selector.Expand(branch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Join(job.SyncDir, "1"), 0700); err != nil {
+			t.Fatal(err)
+		}
+		h.Track(job)
+	}
+
+	// an empty discovery pass doesn't retire anything
+	h.retireBranches(selector, []string{})
+	for _, branch := range branches {
+		job := h.trackedJobs[branch]
+		if job == nil || job.Gone != nil {
+			t.Fatalf("%s was retired by an empty discovery pass", branch)
+		}
+	}
+
+	// but a deleted branch is, and its builds are removed
+	h.retireBranches(selector, branches[:1])
+	time.Sleep(time.Millisecond)
+	h.retireBranches(selector, branches[:1])
+	if _, ok := h.trackedJobs[branches[1]]; ok {
+		t.Error("the deleted branch is still tracked")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "foo", "develop")); !os.IsNotExist(err) {
+		t.Errorf("the builds of the deleted branch weren't removed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "foo", "master", "1")); err != nil {
+		t.Errorf("the builds of the remaining branch were removed: %v", err)
+	}
+}
+
+func TestRetireBranchesOfSelectorOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jenkronize-tracking")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	selector, err := NewJobSelector(&TrackedJob{
+		Name:            "/job/foo",
+		Multibranch:     true,
+		SyncDir:         filepath.Join(dir, "foo"),
+		BranchRetention: time.Nanosecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewJobSelector(&TrackedJob{
+		NamePattern: "/job/foo/job/feature-*",
+		SyncDir:     filepath.Join(dir, "features", "{{index .Matches 0}}"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := (&Tracker{}).Init().SetStateFile(filepath.Join(dir, "state.json"))
+	explicit := NewTrackedJob("/job/foo/job/release", "release", filepath.Join(dir, "release"))
+	feature, _, err := other.Expand("/job/foo/job/feature-x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	branch, _, err := selector.Expand("/job/foo/job/develop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, job := range []*TrackedJob{explicit, feature, branch} {
+		if err := os.MkdirAll(filepath.Join(job.SyncDir, "1"), 0700); err != nil {
+			t.Fatal(err)
+		}
+		h.Track(job)
+	}
+
+	// none of them are in the selector's discovery pass, but only the
+	// selector's own branch is retired
+	h.retireBranches(selector, []string{"/job/foo/job/master"})
+	time.Sleep(time.Millisecond)
+	h.retireBranches(selector, []string{"/job/foo/job/master"})
+	if _, ok := h.trackedJobs[branch.GetName()]; ok {
+		t.Error("the deleted branch is still tracked")
+	}
+	for _, job := range []*TrackedJob{explicit, feature} {
+		if tracked := h.trackedJobs[job.GetName()]; tracked != job || job.Gone != nil {
+			t.Errorf("%s was retired by the multibranch selector", job.GetName())
+		}
+		if _, err := os.Stat(filepath.Join(job.SyncDir, "1")); err != nil {
+			t.Errorf("the builds of %s were removed: %v", job.GetName(), err)
+		}
+	}
+}
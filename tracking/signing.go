@@ -0,0 +1,223 @@
+package tracking
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/openpgp"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// SignEd25519 signs with an ed25519 key, writing the raw signature into a
+	// .sig file, as checked by `openssl pkeyutl -verify -rawin`
+	SignEd25519 = "ed25519"
+	// SignOpenPGP signs with an OpenPGP key, writing an armored detached
+	// signature into a .asc file, as checked by `gpg --verify`
+	SignOpenPGP = "openpgp"
+)
+
+var signatureExtensions = map[string]string{
+	SignEd25519: ".sig",
+	SignOpenPGP: ".asc",
+}
+
+// the DER encodings of ed25519 keys are a fixed prefix followed by the key, so
+// they're decoded by hand rather than relying on crypto/x509 supporting them
+var (
+	pkcs8Ed25519Prefix = []byte{0x30, 0x2e, 0x02, 0x01, 0x00, 0x30, 0x05, 0x06, 0x03, 0x2b, 0x65, 0x70, 0x04, 0x22, 0x04, 0x20}
+	pkixEd25519Prefix  = []byte{0x30, 0x2a, 0x30, 0x05, 0x06, 0x03, 0x2b, 0x65, 0x70, 0x03, 0x21, 0x00}
+)
+
+// Signer signs the checksum lists of builds, so consumers of the mirror can
+// check that they came from it.
+type Signer interface {
+	Sign(message []byte) ([]byte, error)
+	// Extension is added to the name of the signed file to name its signature
+	Extension() string
+}
+
+// NewSigner loads a private key of the type, ed25519 or openpgp, from the key
+// file. The passphrase is only needed for an encrypted OpenPGP key.
+func NewSigner(keyType string, keyFile string, passphrase string) (Signer, error) {
+	keyData, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(keyType) {
+	case SignEd25519:
+		key, err := parseEd25519PrivateKey(keyData)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", keyFile, err)
+		}
+		return &ed25519Signer{key: key}, nil
+	case SignOpenPGP:
+		entity, err := readOpenPGPSigningKey(keyData, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", keyFile, err)
+		}
+		return &openPGPSigner{entity: entity}, nil
+	}
+	return nil, fmt.Errorf("unknown signing key type %q; it should be %s or %s", keyType, SignEd25519, SignOpenPGP)
+}
+
+type ed25519Signer struct {
+	key ed25519.PrivateKey
+}
+
+func (s *ed25519Signer) Sign(message []byte) ([]byte, error) {
+	return ed25519.Sign(s.key, message), nil
+}
+
+func (s *ed25519Signer) Extension() string {
+	return signatureExtensions[SignEd25519]
+}
+
+type openPGPSigner struct {
+	entity *openpgp.Entity
+}
+
+func (s *openPGPSigner) Sign(message []byte) ([]byte, error) {
+	var signature bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&signature, s.entity, bytes.NewReader(message), nil); err != nil {
+		return nil, err
+	}
+	return signature.Bytes(), nil
+}
+
+func (s *openPGPSigner) Extension() string {
+	return signatureExtensions[SignOpenPGP]
+}
+
+// VerifySignature checks the signature of the signed file against the public
+// key in the key file. The signature is found by its extension, which also
+// decides the type of key expected; the name of the signature is returned
+// along with any error.
+func VerifySignature(signedPath string, message []byte, publicKeyFile string) (string, error) {
+	keyData, err := ioutil.ReadFile(publicKeyFile)
+	if err != nil {
+		return "", err
+	}
+	for _, keyType := range []string{SignEd25519, SignOpenPGP} {
+		signaturePath := signedPath + signatureExtensions[keyType]
+		name := filepath.Base(signaturePath)
+		signature, err := ioutil.ReadFile(signaturePath)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return name, err
+		}
+		if keyType == SignEd25519 {
+			key, err := parseEd25519PublicKey(keyData)
+			if err != nil {
+				return name, fmt.Errorf("%s: %v", publicKeyFile, err)
+			}
+			if !ed25519.Verify(key, message, signature) {
+				return name, fmt.Errorf("bad signature")
+			}
+			return name, nil
+		}
+		keyRing, err := readOpenPGPKeyRing(keyData)
+		if err != nil {
+			return name, fmt.Errorf("%s: %v", publicKeyFile, err)
+		}
+		if _, err := openpgp.CheckArmoredDetachedSignature(keyRing, bytes.NewReader(message), bytes.NewReader(signature)); err != nil {
+			return name, fmt.Errorf("bad signature: %v", err)
+		}
+		return name, nil
+	}
+	return filepath.Base(signedPath), fmt.Errorf("not signed")
+}
+
+// parseEd25519PrivateKey reads a PEM encoded PKCS #8 key, as written by
+// `openssl genpkey -algorithm ed25519`, or a base64 encoded seed or key.
+func parseEd25519PrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	der, err := decodeKey(data, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case len(der) == len(pkcs8Ed25519Prefix)+ed25519.SeedSize && bytes.HasPrefix(der, pkcs8Ed25519Prefix):
+		return ed25519.NewKeyFromSeed(der[len(pkcs8Ed25519Prefix):]), nil
+	case len(der) == ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(der), nil
+	case len(der) == ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(der), nil
+	}
+	return nil, fmt.Errorf("not an ed25519 private key")
+}
+
+// parseEd25519PublicKey reads a PEM encoded PKIX key, as written by `openssl
+// pkey -pubout`, or a base64 encoded key.
+func parseEd25519PublicKey(data []byte) (ed25519.PublicKey, error) {
+	der, err := decodeKey(data, "PUBLIC KEY")
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case len(der) == len(pkixEd25519Prefix)+ed25519.PublicKeySize && bytes.HasPrefix(der, pkixEd25519Prefix):
+		return ed25519.PublicKey(der[len(pkixEd25519Prefix):]), nil
+	case len(der) == ed25519.PublicKeySize:
+		return ed25519.PublicKey(der), nil
+	}
+	return nil, fmt.Errorf("not an ed25519 public key")
+}
+
+// decodeKey returns the DER of a PEM encoded key of the type, or the bytes of
+// a base64 encoded key.
+func decodeKey(data []byte, pemType string) ([]byte, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		if block.Type != pemType {
+			return nil, fmt.Errorf("expected a PEM encoded %s, not a %s", pemType, block.Type)
+		}
+		return block.Bytes, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("expected a PEM or base64 encoded key: %v", err)
+	}
+	return key, nil
+}
+
+// readOpenPGPKeyRing reads an armored or binary key ring, eg. from `gpg
+// --export --armor`.
+func readOpenPGPKeyRing(data []byte) (openpgp.EntityList, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN")) {
+		return openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	}
+	return openpgp.ReadKeyRing(bytes.NewReader(data))
+}
+
+// readOpenPGPSigningKey returns the first secret key in the key ring, eg. from
+// `gpg --export-secret-keys --armor`, decrypting it with the passphrase if
+// it's encrypted.
+func readOpenPGPSigningKey(data []byte, passphrase string) (*openpgp.Entity, error) {
+	keyRing, err := readOpenPGPKeyRing(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, entity := range keyRing {
+		if entity.PrivateKey == nil {
+			continue
+		}
+		if entity.PrivateKey.Encrypted {
+			if err := entity.PrivateKey.Decrypt([]byte(passphrase)); err != nil {
+				return nil, fmt.Errorf("unable to decrypt the key; check the passphrase: %v", err)
+			}
+		}
+		for _, subkey := range entity.Subkeys {
+			if subkey.PrivateKey != nil && subkey.PrivateKey.Encrypted {
+				if err := subkey.PrivateKey.Decrypt([]byte(passphrase)); err != nil {
+					return nil, fmt.Errorf("unable to decrypt a subkey; check the passphrase: %v", err)
+				}
+			}
+		}
+		return entity, nil
+	}
+	return nil, fmt.Errorf("no secret key found")
+}
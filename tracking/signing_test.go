@@ -0,0 +1,304 @@
+package tracking
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/pem"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeKey PEM encodes the key into a file in dir, and returns its path.
+func writeKey(t *testing.T, dir string, name string, pemType string, der []byte) string {
+	keyFile := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: pemType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return keyFile
+}
+
+// ed25519Keys writes a new ed25519 key pair into dir, in the formats written by
+// openssl, and returns the paths of the private and public keys.
+func ed25519Keys(t *testing.T, dir string, name string) (string, string) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateFile := writeKey(t, dir, name+".pem", "PRIVATE KEY", append(append([]byte{}, pkcs8Ed25519Prefix...), private.Seed()...))
+	publicFile := writeKey(t, dir, name+".pub", "PUBLIC KEY", append(append([]byte{}, pkixEd25519Prefix...), public...))
+	return privateFile, publicFile
+}
+
+// openPGPKeys writes a new armored OpenPGP key pair into dir, like gpg
+// --export-secret-keys and gpg --export, and returns their paths.
+func openPGPKeys(t *testing.T, dir string, name string) (string, string) {
+	entity, err := openpgp.NewEntity(name, "", name+"@example.org", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(file string, blockType string, serialize func(*bytes.Buffer) error) string {
+		var buf bytes.Buffer
+		w, err := armor.Encode(&buf, blockType, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var key bytes.Buffer
+		if err := serialize(&key); err != nil {
+			t.Fatal(err)
+		}
+		w.Write(key.Bytes())
+		w.Close()
+		keyFile := filepath.Join(dir, file)
+		if err := ioutil.WriteFile(keyFile, buf.Bytes(), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return keyFile
+	}
+	privateFile := write(name+".key", openpgp.PrivateKeyType, func(buf *bytes.Buffer) error {
+		return entity.SerializePrivate(buf, nil)
+	})
+	publicFile := write(name+".asc", openpgp.PublicKeyType, func(buf *bytes.Buffer) error {
+		return entity.Serialize(buf)
+	})
+	return privateFile, publicFile
+}
+
+func tempKeyDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "jenkronize-keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// testRoundTrip signs a message with the private key, and checks that the
+// signature only verifies against the matching public key and the message it
+// was made for.
+func testRoundTrip(t *testing.T, keyType string, privateFile string, publicFile string, otherPublicFile string) {
+	signer, err := NewSigner(keyType, privateFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signer.Extension() != signatureExtensions[strings.ToLower(keyType)] {
+		t.Errorf("the signature extension is %q", signer.Extension())
+	}
+	message := []byte(helloSHA256 + "  a.txt\n")
+	signature, err := signer.Sign(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedPath := filepath.Join(filepath.Dir(privateFile), "SHA256SUMS")
+	if err := ioutil.WriteFile(signedPath+signer.Extension(), signature, 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(signedPath + signer.Extension())
+
+	name, err := VerifySignature(signedPath, message, publicFile)
+	if err != nil {
+		t.Errorf("the signature didn't verify: %v", err)
+	}
+	if name != "SHA256SUMS"+signer.Extension() {
+		t.Errorf("the signature is named %q", name)
+	}
+	tampered := append([]byte(strings.Repeat("0", 64)+"  b.txt\n"), message...)
+	if _, err := VerifySignature(signedPath, tampered, publicFile); err == nil {
+		t.Error("the signature verified a tampered message")
+	}
+	if _, err := VerifySignature(signedPath, message, otherPublicFile); err == nil {
+		t.Error("the signature verified against another key")
+	}
+}
+
+func TestEd25519RoundTrip(t *testing.T) {
+	dir := tempKeyDir(t)
+	defer os.RemoveAll(dir)
+	privateFile, publicFile := ed25519Keys(t, dir, "signing")
+	_, otherPublicFile := ed25519Keys(t, dir, "other")
+	testRoundTrip(t, SignEd25519, privateFile, publicFile, otherPublicFile)
+}
+
+func TestOpenPGPRoundTrip(t *testing.T) {
+	dir := tempKeyDir(t)
+	defer os.RemoveAll(dir)
+	privateFile, publicFile := openPGPKeys(t, dir, "signing")
+	_, otherPublicFile := openPGPKeys(t, dir, "other")
+	// the key type isn't case sensitive
+	testRoundTrip(t, "OpenPGP", privateFile, publicFile, otherPublicFile)
+}
+
+func TestParseEd25519Keys(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemKey := func(pemType string, der []byte) []byte {
+		return pem.EncodeToMemory(&pem.Block{Type: pemType, Bytes: der})
+	}
+	b64 := func(key []byte) []byte {
+		return []byte(base64.StdEncoding.EncodeToString(key) + "\n")
+	}
+	pkcs8 := append(append([]byte{}, pkcs8Ed25519Prefix...), private.Seed()...)
+	pkix := append(append([]byte{}, pkixEd25519Prefix...), public...)
+
+	privateTests := []struct {
+		name  string
+		data  []byte
+		valid bool
+	}{
+		{"PKCS #8", pemKey("PRIVATE KEY", pkcs8), true},
+		{"base64 seed", b64(private.Seed()), true},
+		{"base64 key", b64(private), true},
+		{"wrong PEM type", pemKey("PUBLIC KEY", pkcs8), false},
+		{"public key", pemKey("PRIVATE KEY", pkix), false},
+		{"short key", b64(private[:16]), false},
+		{"not base64", []byte("not a key"), false},
+	}
+	for _, test := range privateTests {
+		key, err := parseEd25519PrivateKey(test.data)
+		if (err == nil) != test.valid {
+			t.Errorf("parsing a %s private key returned %v", test.name, err)
+			continue
+		}
+		if err == nil && !bytes.Equal(key, private) {
+			t.Errorf("parsing a %s private key returned the wrong key", test.name)
+		}
+	}
+
+	publicTests := []struct {
+		name  string
+		data  []byte
+		valid bool
+	}{
+		{"PKIX", pemKey("PUBLIC KEY", pkix), true},
+		{"base64", b64(public), true},
+		{"wrong PEM type", pemKey("PRIVATE KEY", pkix), false},
+		{"private key", pemKey("PUBLIC KEY", pkcs8), false},
+		{"not base64", []byte("not a key"), false},
+	}
+	for _, test := range publicTests {
+		key, err := parseEd25519PublicKey(test.data)
+		if (err == nil) != test.valid {
+			t.Errorf("parsing a %s public key returned %v", test.name, err)
+			continue
+		}
+		if err == nil && !bytes.Equal(key, public) {
+			t.Errorf("parsing a %s public key returned the wrong key", test.name)
+		}
+	}
+}
+
+func TestNewSignerErrors(t *testing.T) {
+	dir := tempKeyDir(t)
+	defer os.RemoveAll(dir)
+	ed25519Private, ed25519Public := ed25519Keys(t, dir, "ed25519")
+	openPGPPrivate, openPGPPublic := openPGPKeys(t, dir, "openpgp")
+	tests := []struct {
+		keyType string
+		keyFile string
+	}{
+		{"rsa", ed25519Private},
+		{SignEd25519, filepath.Join(dir, "missing.pem")},
+		{SignEd25519, ed25519Public},
+		{SignEd25519, openPGPPrivate},
+		{SignOpenPGP, ed25519Private},
+		// a public key can't sign
+		{SignOpenPGP, openPGPPublic},
+	}
+	for _, test := range tests {
+		if _, err := NewSigner(test.keyType, test.keyFile, ""); err == nil {
+			t.Errorf("NewSigner(%q, %s) succeeded", test.keyType, filepath.Base(test.keyFile))
+		}
+	}
+}
+
+func TestVerifySignatureErrors(t *testing.T) {
+	dir := tempKeyDir(t)
+	defer os.RemoveAll(dir)
+	privateFile, publicFile := ed25519Keys(t, dir, "signing")
+	signedPath := filepath.Join(dir, "SHA256SUMS")
+	message := []byte(helloSHA256 + "  a.txt\n")
+
+	if _, err := VerifySignature(signedPath, message, publicFile); err == nil || err.Error() != "not signed" {
+		t.Errorf("verifying an unsigned file returned %v", err)
+	}
+	signer, err := NewSigner(SignEd25519, privateFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, _ := signer.Sign(message)
+	if err := ioutil.WriteFile(signedPath+".sig", signature, 0600); err != nil {
+		t.Fatal(err)
+	}
+	// the signature's extension decides the type of key expected
+	if _, err := VerifySignature(signedPath, message, privateFile); err == nil {
+		t.Error("verified a signature against a private key")
+	}
+	if _, err := VerifySignature(signedPath, message, filepath.Join(dir, "missing.pub")); err == nil {
+		t.Error("verified a signature against a missing key")
+	}
+}
+
+func TestWriteChecksumsSigned(t *testing.T) {
+	dir := tempKeyDir(t)
+	defer os.RemoveAll(dir)
+	privateFile, publicFile := ed25519Keys(t, dir, "signing")
+	signer, err := NewSigner(SignEd25519, privateFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := (&Tracker{}).Init().SetChecksums([]ChecksumAlgorithm{SumSHA512}, signer)
+	job := NewTrackedJob("/job/foo", "foo", filepath.Join(dir, "foo"))
+	buildDir := filepath.Join(job.SyncDir, "3")
+	if err := os.MkdirAll(buildDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(buildDir, "a.txt"), []byte("hello"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	manifest := &BuildManifest{
+		Build:     3,
+		Artifacts: []*ArtifactManifest{{Path: "a.txt", Size: 5, SHA256: helloSHA256}},
+	}
+	if err := h.writeChecksums(job, manifest); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := VerifyBuild(buildDir, publicFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a.txt against SHA256SUMS and SHA512SUMS, and the signature of SHA256SUMS
+	if len(results) != 3 {
+		t.Fatalf("%d files were checked, expected 3", len(results))
+	}
+	for _, result := range results {
+		if result.Err != nil {
+			t.Errorf("%s failed its check against %s: %v", result.File, result.List, result.Err)
+		}
+	}
+
+	// a list which has been changed since it was signed fails
+	sumsPath := filepath.Join(buildDir, "SHA256SUMS")
+	list, err := ioutil.ReadFile(sumsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(sumsPath, append(list, '\n'), 0600); err != nil {
+		t.Fatal(err)
+	}
+	results, err = VerifyBuild(buildDir, publicFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range results {
+		if failed := result.Err != nil; failed != (result.List == "SHA256SUMS.sig") {
+			t.Errorf("%s checked against %s after the list was changed: %v", result.File, result.List, result.Err)
+		}
+	}
+}
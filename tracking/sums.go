@@ -0,0 +1,215 @@
+package tracking
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"golang.org/x/crypto/blake2b"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ChecksumAlgorithm is a hash written into a checksum list for each build.
+type ChecksumAlgorithm string
+
+const (
+	SumSHA256  ChecksumAlgorithm = "sha256"
+	SumSHA512  ChecksumAlgorithm = "sha512"
+	SumBLAKE2b ChecksumAlgorithm = "blake2b"
+)
+
+var ChecksumAlgorithms = []ChecksumAlgorithm{SumSHA256, SumSHA512, SumBLAKE2b}
+
+// ParseChecksumAlgorithm validates a checksum algorithm as used in
+// configuration.
+func ParseChecksumAlgorithm(name string) (ChecksumAlgorithm, error) {
+	for _, a := range ChecksumAlgorithms {
+		if strings.EqualFold(name, string(a)) {
+			return a, nil
+		}
+	}
+	return "", fmt.Errorf("unknown checksum algorithm %q; it should be one of sha256, sha512 or blake2b", name)
+}
+
+// SumsFile returns the name of the checksum list for the algorithm, named
+// after the coreutils tool which can check it, eg. `sha256sum -c SHA256SUMS`.
+func (a ChecksumAlgorithm) SumsFile() string {
+	if a == SumBLAKE2b {
+		return "B2SUMS"
+	}
+	return strings.ToUpper(string(a)) + "SUMS"
+}
+
+func (a ChecksumAlgorithm) newHash() hash.Hash {
+	switch a {
+	case SumSHA512:
+		return sha512.New()
+	case SumBLAKE2b:
+		// b2sum uses BLAKE2b-512
+		h, _ := blake2b.New512(nil)
+		return h
+	}
+	return sha256.New()
+}
+
+// isMirrorFile reports whether the file name is one of those Jenkronize writes
+// into a build dir, rather than an artifact.
+func isMirrorFile(name string) bool {
+	if name == ManifestFile {
+		return true
+	}
+	for _, a := range ChecksumAlgorithms {
+		if name == a.SumsFile() || strings.HasPrefix(name, a.SumsFile()+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// writeChecksums writes a checksum list of the build's artifacts for each of
+// the tracker's algorithms, and signs SHA256SUMS if the tracker has a signer.
+func (h *Tracker) writeChecksums(job *TrackedJob, manifest *BuildManifest) error {
+	buildDir := filepath.Join(job.SyncDir, fmt.Sprintf("%d", manifest.Build))
+	for _, artifact := range manifest.Artifacts {
+		if isMirrorFile(artifact.Path) {
+			return fmt.Errorf("an artifact of build %d is named %s, so the build has no checksum lists", manifest.Build, artifact.Path)
+		}
+	}
+	for _, algorithm := range h.checksumAlgorithms() {
+		var list bytes.Buffer
+		for _, artifact := range manifest.Artifacts {
+			sum := artifact.SHA256
+			if algorithm != SumSHA256 {
+				var err error
+				if sum, err = fileChecksum(filepath.Join(buildDir, artifact.Path), algorithm); err != nil {
+					return err
+				}
+			}
+			// the format read by sha256sum -c and friends
+			fmt.Fprintf(&list, "%s  %s\n", sum, artifact.Path)
+		}
+		sumsPath := filepath.Join(buildDir, algorithm.SumsFile())
+		if err := writeFile(sumsPath, list.Bytes()); err != nil {
+			return err
+		}
+		if algorithm != SumSHA256 || h.signer == nil {
+			continue
+		}
+		signature, err := h.signer.Sign(list.Bytes())
+		if err != nil {
+			return fmt.Errorf("unable to sign %s: %v", sumsPath, err)
+		}
+		if err := writeFile(sumsPath+h.signer.Extension(), signature); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checksumAlgorithms returns the algorithms to write checksum lists for;
+// SHA256SUMS is always written.
+func (h *Tracker) checksumAlgorithms() []ChecksumAlgorithm {
+	algorithms := []ChecksumAlgorithm{SumSHA256}
+	for _, a := range h.checksums {
+		if a != SumSHA256 {
+			algorithms = append(algorithms, a)
+		}
+	}
+	return algorithms
+}
+
+// fileChecksum returns the hex encoded checksum of the file.
+func fileChecksum(filePath string, algorithm ChecksumAlgorithm) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := algorithm.newHash()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// VerifyResult is the outcome of checking one file of a build dir.
+type VerifyResult struct {
+	// File is the file checked, and List the checksum list or signature it
+	// was checked against
+	File string
+	List string
+	// Err is why the file failed the check, or nil if it passed
+	Err error
+}
+
+// VerifyBuild rechecks the files of a build dir against each of its checksum
+// lists. If publicKey is set, the signature of SHA256SUMS is checked against
+// it too, and must be present. It returns an error if the dir can't be
+// checked at all, eg. because it has no checksum lists.
+func VerifyBuild(buildDir string, publicKey string) ([]*VerifyResult, error) {
+	results := []*VerifyResult{}
+	for _, algorithm := range ChecksumAlgorithms {
+		sumsPath := filepath.Join(buildDir, algorithm.SumsFile())
+		list, err := ioutil.ReadFile(sumsPath)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		listResults, err := verifyList(buildDir, algorithm, list)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", sumsPath, err)
+		}
+		results = append(results, listResults...)
+		if algorithm == SumSHA256 && publicKey != "" {
+			signature, err := VerifySignature(sumsPath, list, publicKey)
+			results = append(results, &VerifyResult{File: algorithm.SumsFile(), List: signature, Err: err})
+		}
+	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("%s has no checksum lists", buildDir)
+	}
+	return results, nil
+}
+
+func verifyList(buildDir string, algorithm ChecksumAlgorithm, list []byte) ([]*VerifyResult, error) {
+	results := []*VerifyResult{}
+	scanner := bufio.NewScanner(bytes.NewReader(list))
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		parts := strings.SplitN(scanner.Text(), " ", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[1], " ") && !strings.HasPrefix(parts[1], "*") {
+			return nil, fmt.Errorf("line %d isn't a checksum and a file name", line)
+		}
+		// the file name is marked with a * by tools which read it in binary mode
+		parts[1] = parts[1][1:]
+		result := &VerifyResult{File: parts[1], List: algorithm.SumsFile()}
+		results = append(results, result)
+		// the lists only name files in the build dir itself
+		if filepath.Base(parts[1]) != parts[1] {
+			result.Err = fmt.Errorf("not a file in the build dir")
+			continue
+		}
+		sum, err := fileChecksum(filepath.Join(buildDir, parts[1]), algorithm)
+		switch {
+		case err != nil:
+			result.Err = err
+		case !strings.EqualFold(sum, parts[0]):
+			result.Err = fmt.Errorf("checksum mismatch")
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].File < results[j].File
+	})
+	return results, scanner.Err()
+}
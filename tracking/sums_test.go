@@ -0,0 +1,130 @@
+package tracking
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// sha256 of "hello"
+const helloSHA256 = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+func buildDir(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "jenkronize-build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestVerifyList(t *testing.T) {
+	dir := buildDir(t, map[string]string{"a.txt": "hello", "b.txt": "changed", "c.bin": "hello"})
+	defer os.RemoveAll(dir)
+	list := helloSHA256 + "  a.txt\n" +
+		helloSHA256 + "  b.txt\n" +
+		"\n" +
+		helloSHA256 + " *c.bin\n" +
+		helloSHA256 + "  missing.txt\n" +
+		helloSHA256 + "  ../a.txt\n"
+	results, err := verifyList(dir, SumSHA256, []byte(list))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]bool{"a.txt": true, "b.txt": false, "c.bin": true, "missing.txt": false, "../a.txt": false}
+	if len(results) != len(expected) {
+		t.Fatalf("%d files were checked, expected %d", len(results), len(expected))
+	}
+	for i, result := range results {
+		if i > 0 && results[i-1].File > result.File {
+			t.Errorf("results aren't sorted by file")
+		}
+		if result.List != "SHA256SUMS" {
+			t.Errorf("%s was checked against %s", result.File, result.List)
+		}
+		if passed := result.Err == nil; passed != expected[result.File] {
+			t.Errorf("%s passed is %v: %v", result.File, passed, result.Err)
+		}
+	}
+}
+
+func TestVerifyListMalformed(t *testing.T) {
+	for _, list := range []string{helloSHA256 + "\n", helloSHA256 + " a.txt\n", helloSHA256 + "\ta.txt\n"} {
+		if _, err := verifyList(os.TempDir(), SumSHA256, []byte(list)); err == nil {
+			t.Errorf("verifyList(%q) succeeded", list)
+		}
+	}
+}
+
+func TestVerifyBuild(t *testing.T) {
+	dir := buildDir(t, map[string]string{
+		"a.txt":  "hello",
+		"B2SUMS": "0000  a.txt\n",
+	})
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "SHA256SUMS"), []byte(helloSHA256+"  a.txt\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	results, err := VerifyBuild(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("%d files were checked, expected 2", len(results))
+	}
+	for _, result := range results {
+		if passed := result.Err == nil; passed != (result.List == "SHA256SUMS") {
+			t.Errorf("%s against %s passed is %v: %v", result.File, result.List, passed, result.Err)
+		}
+	}
+
+	empty := buildDir(t, map[string]string{"a.txt": "hello"})
+	defer os.RemoveAll(empty)
+	if _, err := VerifyBuild(empty, ""); err == nil {
+		t.Error("verifying a build without checksum lists succeeded")
+	}
+}
+
+func TestParseChecksumAlgorithm(t *testing.T) {
+	tests := []struct {
+		name     string
+		sumsFile string
+	}{
+		{"sha256", "SHA256SUMS"},
+		{"SHA512", "SHA512SUMS"},
+		{"Blake2b", "B2SUMS"},
+	}
+	for _, test := range tests {
+		algorithm, err := ParseChecksumAlgorithm(test.name)
+		if err != nil {
+			t.Errorf("ParseChecksumAlgorithm(%q) returned %v", test.name, err)
+			continue
+		}
+		if algorithm.SumsFile() != test.sumsFile {
+			t.Errorf("the list for %s is %s", test.name, algorithm.SumsFile())
+		}
+	}
+	if _, err := ParseChecksumAlgorithm("md5"); err == nil {
+		t.Error("md5 is accepted")
+	}
+}
+
+func TestIsMirrorFile(t *testing.T) {
+	for name, expected := range map[string]bool{
+		ManifestFile:       true,
+		"SHA256SUMS":       true,
+		"SHA256SUMS.asc":   true,
+		"B2SUMS":           true,
+		"installer.exe":    false,
+		"SHA256SUMS-notes": false,
+	} {
+		if isMirrorFile(name) != expected {
+			t.Errorf("isMirrorFile(%q) is %v", name, !expected)
+		}
+	}
+}
@@ -1,8 +1,11 @@
 package tracking
 
 import (
+	"fmt"
+	"github.com/go-yaml/yaml"
 	"github.com/pakohler/jenkronize/jenkins"
 	"strings"
+	"time"
 )
 
 type TrackedJob struct {
@@ -10,7 +13,41 @@ type TrackedJob struct {
 	Alias         string         `yaml:"alias"`
 	Build         *jenkins.Build `yaml:"-" json:"build"`
 	SyncDir       string         `yaml:"sync_dir"`
-	BuildsToCache int            `yaml:buildsToCache`
+	BuildsToCache int            `yaml:"builds_to_cache"`
+	PublicUrl     string         `yaml:"public_url"`
+	// BuildSelection is how the build to sync is chosen; see BuildSelections.
+	// AllowedResults only apply to last_completed, and Promotion to promoted.
+	BuildSelection BuildSelection `yaml:"build_selection" json:",omitempty"`
+	AllowedResults []string       `yaml:"allowed_results" json:",omitempty"`
+	Promotion      string         `yaml:"promotion" json:",omitempty"`
+	// Mode is whether only the selected build is synced, or every build since
+	// the last synced one; InitialBackfill is how many builds are synced when
+	// the job hasn't been synced before.
+	Mode            SyncMode `yaml:"mode" json:",omitempty"`
+	InitialBackfill int      `yaml:"initial_backfill" json:",omitempty"`
+	// PinBuild syncs exactly that build, instead of selecting one.
+	PinBuild int32 `yaml:"pin_build" json:",omitempty"`
+	// MatchParameters, MatchDescription and MatchDisplayName only let builds
+	// whose parameters, description and display name match the given
+	// wildcard patterns be selected.
+	MatchParameters  map[string]string `yaml:"match_parameters" json:",omitempty"`
+	MatchDescription string            `yaml:"match_description" json:",omitempty"`
+	MatchDisplayName string            `yaml:"match_display_name" json:",omitempty"`
+	// NamePattern and NameRegex make this a selector for any number of jobs
+	// rather than a job itself; see JobSelector.
+	NamePattern string `yaml:"name_pattern" json:",omitempty"`
+	NameRegex   string `yaml:"name_regex" json:",omitempty"`
+	// Multibranch makes this a selector for the branches of a multibranch
+	// project, or of every repo in an organization folder.
+	Multibranch     bool          `yaml:"multibranch" json:",omitempty"`
+	PullRequests    bool          `yaml:"pull_requests" json:",omitempty"`
+	BranchRetention time.Duration `yaml:"branch_retention" json:",omitempty"`
+	// Gone is when the branch this job was discovered for was deleted from
+	// Jenkins; its builds are kept for the branch_retention of its project.
+	Gone *time.Time `yaml:"-" json:"gone,omitempty"`
+	// selector is the selector the job was discovered by, if any. It isn't
+	// saved, as loading the state expands the job with its selector again.
+	selector *JobSelector
 }
 
 func NewTrackedJob(name string, alias string, syncDir string) *TrackedJob {
@@ -26,6 +63,62 @@ func NewTrackedJob(name string, alias string, syncDir string) *TrackedJob {
 	return &t
 }
 
+// UnmarshalYAML decodes a job from the config. Without builds_to_cache, every
+// build is kept, as before the setting was read. The job is decoded as far as
+// it can be even if some of its settings are invalid.
+func (t *TrackedJob) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain TrackedJob
+	job := plain{BuildsToCache: -1}
+	err := unmarshal(&job)
+	*t = TrackedJob(job)
+	return err
+}
+
+// TrackedJobs is the list of jobs in the config. A job with an invalid setting
+// is kept rather than dropped from the list, so the jobs after it keep their
+// indices and each of their problems can be reported where it is.
+type TrackedJobs []*TrackedJob
+
+// trackedJobItem decodes a job of the list without failing, holding on to what
+// was wrong with it instead.
+type trackedJobItem struct {
+	job *TrackedJob
+	err error
+}
+
+func (i *trackedJobItem) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	i.job = &TrackedJob{}
+	i.err = i.job.UnmarshalYAML(unmarshal)
+	if typeErr, ok := i.err.(*yaml.TypeError); ok {
+		// the errors share their array with the decoder, which reuses it for
+		// the errors of the next item
+		i.err = &yaml.TypeError{Errors: append([]string{}, typeErr.Errors...)}
+	}
+	return nil
+}
+
+func (jobs *TrackedJobs) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	items := []trackedJobItem{}
+	if err := unmarshal(&items); err != nil {
+		return err
+	}
+	*jobs = make(TrackedJobs, len(items))
+	problems := []string{}
+	for i, item := range items {
+		// an empty item stays nil
+		(*jobs)[i] = item.job
+		if typeErr, ok := item.err.(*yaml.TypeError); ok {
+			problems = append(problems, typeErr.Errors...)
+		} else if item.err != nil {
+			return item.err
+		}
+	}
+	if len(problems) > 0 {
+		return &yaml.TypeError{Errors: problems}
+	}
+	return nil
+}
+
 func (t *TrackedJob) Init() {
 	if t.Alias == "" {
 		t.Alias = t.Name
@@ -51,6 +144,18 @@ func (t *TrackedJob) BuildNumber() int32 {
 	return t.Build.Number
 }
 
+// HasMatchers reports whether builds are only selected if they match the
+// job's parameter, description or display name patterns.
+func (t *TrackedJob) HasMatchers() bool {
+	return len(t.MatchParameters) > 0 || t.MatchDescription != "" || t.MatchDisplayName != ""
+}
+
+// IsSelector reports whether the job selects other jobs by a pattern rather
+// than being a job itself.
+func (t *TrackedJob) IsSelector() bool {
+	return t.NamePattern != "" || t.NameRegex != "" || t.Multibranch
+}
+
 func (t *TrackedJob) GetName() string {
 	return t.Name
 }
@@ -59,6 +164,15 @@ func (t *TrackedJob) GetAlias() string {
 	return t.Alias
 }
 
+// MirrorUrl returns the public URL of the mirrored artifacts for the given
+// build, or an empty string if no public URL is configured for the job.
+func (t *TrackedJob) MirrorUrl(build int32) string {
+	if t.PublicUrl == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/%d/", strings.TrimRight(t.PublicUrl, "/"), build)
+}
+
 func (t *TrackedJob) Equals(other *TrackedJob) bool {
 	// There shouldn't be any case where you end up with multiple instances of
 	// the same job to be tracked, but if so, synchronize the last seen build
@@ -31,21 +31,31 @@ func (c *comboError) Error() string {
 }
 
 type Tracker struct {
-	client      *jenkins.JenkinsAPIClient
-	log         *logging.Logger
-	trackedJobs map[string]*TrackedJob
-	interval    time.Duration
-	notifiers   []notifications.Notifier
-	mux         sync.Mutex
-	dns         bool
-	outofspace  bool
+	client            *jenkins.JenkinsAPIClient
+	log               *logging.Logger
+	trackedJobs       map[string]*TrackedJob
+	selectors         []*JobSelector
+	interval          time.Duration
+	discoveryInterval time.Duration
+	notifiers         []notifications.Notifier
+	alerts            *notifications.Alerts
+	messages          *Messages
+	stateFile         string
+	checksums         []ChecksumAlgorithm
+	signer            Signer
+	blobStoreEnabled  bool
+	globalBlobStore   *BlobStore
+	blobStores        map[string]*BlobStore
+	mux               sync.Mutex
 }
 
 func (h *Tracker) Init() *Tracker {
-	h.log = logging.GetLogger()
+	h.log = logging.GetPackageLogger("tracking")
 	h.trackedJobs = map[string]*TrackedJob{}
+	h.blobStores = map[string]*BlobStore{}
 	h.notifiers = []notifications.Notifier{}
-	h.dns = true
+	h.alerts = notifications.NewAlerts(notifications.NotifierFunc(h.broadcast)).SetSummaryText(h.summaryText)
+	h.messages, _ = NewMessages(nil)
 	return h
 }
 
@@ -63,7 +73,19 @@ func (h *Tracker) SetInterval(new string) *Tracker {
 	return h
 }
 
+// Track adds a job to be tracked. A job with a name_pattern or name_regex is a
+// selector instead, and the jobs it matches are tracked as they're discovered.
 func (h *Tracker) Track(job *TrackedJob) *Tracker {
+	if job.IsSelector() {
+		selector, err := NewJobSelector(job)
+		if err != nil {
+			h.log.Fatal.Fatal(err)
+		}
+		h.selectors = append(h.selectors, selector)
+		return h
+	}
+	h.mux.Lock()
+	defer h.mux.Unlock()
 	_, ok := h.trackedJobs[job.GetName()]
 	if !ok {
 		h.trackedJobs[job.GetName()] = job
@@ -71,256 +93,895 @@ func (h *Tracker) Track(job *TrackedJob) *Tracker {
 	return h
 }
 
+// SetDiscoveryInterval sets how often the Jenkins server is searched for new
+// jobs matching the selectors. By default it's the same as the interval.
+func (h *Tracker) SetDiscoveryInterval(new time.Duration) *Tracker {
+	h.discoveryInterval = new
+	return h
+}
+
 func (h *Tracker) AddNotifier(newNotifier notifications.Notifier) *Tracker {
 	h.notifiers = append(h.notifiers, newNotifier)
 	return h
 }
 
+// SetMessages replaces the templates used to render notifications.
+func (h *Tracker) SetMessages(messages *Messages) *Tracker {
+	h.messages = messages
+	return h
+}
+
+// SetStateFile sets where the build each job is synced to is saved. By
+// default it's state.json beside the executable.
+func (h *Tracker) SetStateFile(path string) *Tracker {
+	h.stateFile = path
+	return h
+}
+
+// SetChecksums sets the checksum lists written into each synced build, on top
+// of SHA256SUMS, and the signer of SHA256SUMS, if it's to be signed.
+func (h *Tracker) SetChecksums(algorithms []ChecksumAlgorithm, signer Signer) *Tracker {
+	h.checksums = algorithms
+	h.signer = signer
+	return h
+}
+
+// SetBlobStore keeps the artifacts of the builds in a content-addressed blob
+// store, so identical artifacts are only downloaded and stored once. The store
+// is in dir if it's given, or else each job has its own in its sync dir.
+func (h *Tracker) SetBlobStore(enabled bool, dir string) *Tracker {
+	h.blobStoreEnabled = enabled
+	h.globalBlobStore = nil
+	if enabled && dir != "" {
+		h.globalBlobStore = NewBlobStore(dir)
+	}
+	return h
+}
+
+// SetRateLimit caps the number of notifications sent per period; anything over
+// the limit is summarized in a single message at the end of the period.
+func (h *Tracker) SetRateLimit(max int, period time.Duration) *Tracker {
+	h.alerts.SetRateLimit(max, period)
+	return h
+}
+
+// Jobs returns the tracked jobs, ordered by alias.
+func (h *Tracker) Jobs() []*TrackedJob {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	jobs := make([]*TrackedJob, 0, len(h.trackedJobs))
+	for _, job := range h.trackedJobs {
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].GetAlias() < jobs[j].GetAlias()
+	})
+	return jobs
+}
+
+// Job finds a tracked job by its alias or its name.
+func (h *Tracker) Job(name string) (*TrackedJob, error) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	for _, job := range h.trackedJobs {
+		if job.GetAlias() == name {
+			return job, nil
+		}
+	}
+	trimmed := strings.TrimRight(name, "/")
+	for _, job := range h.trackedJobs {
+		if strings.EqualFold(strings.TrimRight(job.GetName(), "/"), trimmed) {
+			return job, nil
+		}
+	}
+	return nil, fmt.Errorf("%q is not a tracked job", name)
+}
+
+// Go tracks every job, forever. Any selectors are first expanded into the
+// jobs they match, and new jobs are tracked as they're discovered.
 func (h *Tracker) Go() {
-	for _, trackedJob := range h.trackedJobs {
+	if len(h.selectors) > 0 {
+		h.Discover(true)
+	}
+	for _, trackedJob := range h.Jobs() {
 		go h.TrackJob(trackedJob)
 	}
-	for {
-		time.Sleep(10000)
+	if len(h.selectors) > 0 {
+		go h.discoverForever()
 	}
+	select {}
 }
 
-func (h *Tracker) notify(msg string) {
-	for _, n := range h.notifiers {
-		err := n.Post(msg)
-		if err != nil {
-			h.log.Error.Print(err.Error())
+func (h *Tracker) discoverForever() {
+	interval := h.discoveryInterval
+	if interval <= 0 {
+		interval = h.interval
+	}
+	for {
+		time.Sleep(interval)
+		jobs, _ := h.Discover(true)
+		for _, job := range jobs {
+			go h.TrackJob(job)
 		}
 	}
 }
 
-func (h *Tracker) TrackJob(job *TrackedJob) {
-	for {
-		currentBuild, err := h.client.GetLastSuccessfulBuildForJob(job.GetName())
-		h.mux.Lock()
+// Discover searches the Jenkins server for jobs matching the selectors, and
+// tracks any which aren't already. The newly tracked jobs are returned. With
+// announce, each of them is notified of and the state is saved, so they're
+// only announced once, and the branches of multibranch projects which have
+// been deleted are retired.
+func (h *Tracker) Discover(announce bool) ([]*TrackedJob, error) {
+	added := []*TrackedJob{}
+	errorSet := []error{}
+	for _, selector := range h.selectors {
+		log := h.log.With(logging.Fields{"selector": selector.Pattern()})
+		jobPaths, err := selector.Discover(h.client)
 		if err != nil {
-			h.handleApiError(job, err)
-			// we'll wait the interval out and try again.
-			h.mux.Unlock()
-			time.Sleep(h.interval)
+			log.With(logging.Fields{"error": err}).Error("failed to discover jobs")
+			errorSet = append(errorSet, fmt.Errorf("%s: %v", selector.Pattern(), err))
 			continue
 		}
-		// if we got here, we know we can reach the host.
-		h.dns = true
-		h.mux.Unlock()
-		if currentBuild.Number > job.BuildNumber() {
-			msg := fmt.Sprintf(
-				"%s - new build number %d detected - last tracked was %d. Downloading artifacts...",
-				job.GetAlias(),
-				currentBuild.Number,
-				job.BuildNumber(),
-			)
-			h.notify(msg)
-			h.log.Info.Print(msg)
-			err = h.handleNewBuild(job, currentBuild)
-			// set and save the build state _after_ the artifacts are synced so they can be retried if something crashes
+		for _, jobPath := range jobPaths {
+			job, err := h.expand(selector, jobPath)
 			if err != nil {
-				h.handleArtifactErrors(job, err)
+				log.With(logging.Fields{"job_path": jobPath, "error": err}).Error("failed to expand selector")
+				errorSet = append(errorSet, fmt.Errorf("%s: %v", jobPath, err))
+				continue
+			}
+			if job != nil {
+				added = append(added, job)
+			}
+		}
+		if announce && selector.IsMultibranch() {
+			h.retireBranches(selector, jobPaths)
+		}
+	}
+	if announce {
+		for _, job := range added {
+			event := h.newEvent(notifications.EventJobDiscovered, notifications.SeverityInfo, h.jobData(job))
+			h.notify(event)
+			h.eventLog(event).Info(event.Text)
+		}
+		if len(added) > 0 {
+			h.saveState()
+		}
+	}
+	if len(errorSet) > 0 {
+		return added, &comboError{errorSet: errorSet}
+	}
+	return added, nil
+}
+
+// expand tracks the job at jobPath for the selector, returning it if it wasn't
+// tracked already.
+func (h *Tracker) expand(selector *JobSelector, jobPath string) (*TrackedJob, error) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	if existing, ok := h.trackedJobs[jobPath]; ok {
+		if existing.Gone != nil {
+			h.jobLog(existing).Info("branch is back in Jenkins; it will be synced again")
+			existing.Gone = nil
+		}
+		return nil, nil
+	}
+	job, ok, err := selector.Expand(jobPath)
+	if err != nil || !ok {
+		return nil, err
+	}
+	h.trackedJobs[job.GetName()] = job
+	return job, nil
+}
+
+// retireBranches stops syncing the branches of the multibranch selector which
+// are no longer in Jenkins, and removes them along with their builds once
+// they've been gone for the branch_retention. Only jobs the selector itself
+// discovered are retired; explicitly configured jobs and those of other
+// selectors are left alone, even if their path is within the project.
+func (h *Tracker) retireBranches(selector *JobSelector, branches []string) {
+	if len(branches) == 0 {
+		// more likely a permissions change or a Jenkins hiccup than every
+		// branch having been deleted
+		h.log.With(logging.Fields{"selector": selector.Pattern()}).Warn("discovery found no branches; none will be retired")
+		return
+	}
+	current := map[string]bool{}
+	for _, branch := range branches {
+		current[branch] = true
+	}
+	now := time.Now()
+	changed := false
+	removed := false
+	h.mux.Lock()
+	for name, job := range h.trackedJobs {
+		if current[name] {
+			continue
+		}
+		if job.selector != selector {
+			continue
+		}
+		log := h.jobLog(job)
+		if job.Gone == nil {
+			gone := now
+			job.Gone = &gone
+			changed = true
+			if selector.BranchRetention() > 0 {
+				log.Infof("branch was deleted from Jenkins; its builds will be removed after %s", selector.BranchRetention())
 			} else {
-				job.SetBuild(currentBuild)
-				msg = fmt.Sprintf(
-					"%s - completed downloading artifacts for build number %d.",
-					job.GetAlias(),
-					job.BuildNumber(),
-				)
-				h.notify(msg)
-				h.log.Info.Print(msg)
-				h.saveState()
+				log.Info("branch was deleted from Jenkins; its builds will be kept")
 			}
-		} else {
-			h.log.Info.Printf(
-				"%s - last observed build number %d is up-to-date; no action required.",
-				job.GetAlias(),
-				currentBuild.Number,
-			)
 		}
+		if selector.BranchRetention() <= 0 || now.Sub(*job.Gone) < selector.BranchRetention() {
+			continue
+		}
+		log.With(logging.Fields{"path": job.SyncDir}).Warn("removing the builds of deleted branch")
+		if err := os.RemoveAll(job.SyncDir); err != nil {
+			log.With(logging.Fields{"error": err}).Error("failed to remove the builds of deleted branch")
+			continue
+		}
+		if h.globalBlobStore == nil {
+			// a branch's own store is beside its sync dir
+			if err := os.RemoveAll(jobBlobStoreDir(job.SyncDir)); err != nil {
+				log.With(logging.Fields{"error": err}).Error("failed to remove the blob store of deleted branch")
+			}
+		}
+		delete(h.trackedJobs, name)
+		changed = true
+		removed = true
+	}
+	h.mux.Unlock()
+	if changed {
+		h.saveState()
+	}
+	if removed && h.globalBlobStore != nil {
+		h.collectBlobs(h.globalBlobStore)
+	}
+}
+
+// isTracked reports whether the job is still tracked, and if so whether its
+// branch has been deleted from Jenkins.
+func (h *Tracker) isTracked(job *TrackedJob) (bool, bool) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	return h.trackedJobs[job.GetName()] == job, job.Gone != nil
+}
+
+// SyncOnce checks each of the jobs for a new build a single time, syncing
+// them concurrently, and returns an error if any of them failed.
+func (h *Tracker) SyncOnce(jobs []*TrackedJob) error {
+	var wg sync.WaitGroup
+	var errMux sync.Mutex
+	errorSet := []error{}
+	for _, job := range jobs {
+		wg.Add(1)
+		go func(job *TrackedJob) {
+			defer wg.Done()
+			if err := h.SyncJob(job); err != nil {
+				errMux.Lock()
+				errorSet = append(errorSet, fmt.Errorf("%s: %v", job.GetAlias(), err))
+				errMux.Unlock()
+			}
+		}(job)
+	}
+	wg.Wait()
+	if len(errorSet) > 0 {
+		return &comboError{errorSet: errorSet}
+	}
+	return nil
+}
+
+// Close flushes and closes the notifiers, so nothing queued is lost on exit.
+func (h *Tracker) Close() error {
+	errorSet := []error{}
+	if err := h.alerts.Close(); err != nil {
+		errorSet = append(errorSet, err)
+	}
+	for _, n := range h.notifiers {
+		if closer, ok := n.(interface{ Close() error }); ok {
+			if err := closer.Close(); err != nil {
+				errorSet = append(errorSet, err)
+			}
+		}
+	}
+	if len(errorSet) > 0 {
+		return &comboError{errorSet: errorSet}
+	}
+	return nil
+}
+
+func (h *Tracker) notify(event *notifications.Event) {
+	err := h.alerts.Post(event)
+	if err != nil {
+		h.log.Error.Print(err.Error())
+	}
+}
+
+func (h *Tracker) broadcast(event *notifications.Event) error {
+	for _, n := range h.notifiers {
+		err := n.Post(event)
+		if err != nil {
+			h.log.Error.Print(err.Error())
+		}
+	}
+	return nil
+}
+
+// summaryText renders the message summarizing the notifications held back by
+// the rate limit.
+func (h *Tracker) summaryText(summary *notifications.Summary) string {
+	data := h.serverData()
+	data.SuppressedCount = summary.Count
+	data.Period = summary.Period
+	data.SuppressedTypes = strings.Join(summary.Types, ", ")
+	data.SuppressedJobs = strings.Join(summary.Jobs, ", ")
+	return h.messages.Render(notifications.EventSuppressed, data)
+}
+
+// raise notifies of a problem with a job; repeats are suppressed until the
+// problem is resolved.
+func (h *Tracker) raise(event *notifications.Event) {
+	event.Condition = string(event.Type)
+	h.notify(event)
+}
+
+// resolve clears a problem raised earlier, notifying that it has cleared if it
+// was active. A nil job resolves a problem with the Jenkins server as a whole.
+func (h *Tracker) resolve(job *TrackedJob, condition notifications.EventType) {
+	data := h.serverData()
+	if job != nil {
+		data = h.jobData(job)
+	}
+	data.Condition = string(condition)
+	event := h.newEvent(notifications.EventResolved, notifications.SeveritySuccess, data)
+	event.Condition = string(condition)
+	h.notify(event)
+}
+
+func (h *Tracker) serverData() *MessageData {
+	return &MessageData{
+		JenkinsUrl: h.client.GetBaseUrl(),
+	}
+}
+
+func (h *Tracker) jobData(job *TrackedJob) *MessageData {
+	data := h.serverData()
+	data.Job = job.GetAlias()
+	data.JobPath = job.GetName()
+	data.LastBuildNumber = job.BuildNumber()
+	return data
+}
+
+func (h *Tracker) buildData(job *TrackedJob, build *jenkins.Build) *MessageData {
+	data := h.jobData(job)
+	data.BuildNumber = build.Number
+	data.BuildUrl = build.Url
+	data.MirrorUrl = job.MirrorUrl(build.Number)
+	return data
+}
+
+// addBuildDetails fills in who and what the build was built from, and its test
+// results, for the messages about it.
+func addBuildDetails(data *MessageData, details *jenkins.JobBuild) {
+	data.Result = details.Result
+	data.StartedBy = details.StartedBy()
+	if causes := details.Causes(); len(causes) > 0 {
+		data.Cause = causes[0].ShortDescription
+	}
+	if revision := details.Revision(); revision != nil {
+		data.Commit = revision.SHA1
+		data.ShortCommit = revision.SHA1
+		if len(data.ShortCommit) > 7 {
+			data.ShortCommit = data.ShortCommit[:7]
+		}
+		data.Branch = revision.BranchName()
+	}
+	changes := details.Changes()
+	data.ChangeCount = len(changes)
+	authors := []string{}
+	seen := map[string]bool{}
+	for _, change := range changes {
+		if change.Author == nil || change.Author.FullName == "" || seen[change.Author.FullName] {
+			continue
+		}
+		seen[change.Author.FullName] = true
+		authors = append(authors, change.Author.FullName)
+	}
+	data.Authors = strings.Join(authors, ", ")
+	if tests := details.TestResult(); tests != nil {
+		data.Tests = tests.String()
+		data.TestsFailed = tests.Failed
+	}
+}
+
+// newEvent renders the message for the event type from data, and wraps it in
+// an event carrying the same details for notifiers that can make use of them.
+func (h *Tracker) newEvent(eventType notifications.EventType, severity notifications.Severity, data *MessageData) *notifications.Event {
+	event := &notifications.Event{
+		Type:          eventType,
+		Severity:      severity,
+		Text:          h.messages.Render(eventType, data),
+		Job:           data.Job,
+		JobPath:       data.JobPath,
+		BuildNumber:   data.BuildNumber,
+		BuildUrl:      data.BuildUrl,
+		ArtifactCount: data.ArtifactCount,
+		ArtifactBytes: data.ArtifactBytes,
+		SavedBytes:    data.SavedBytes,
+		Duration:      data.Duration,
+		MirrorUrl:     data.MirrorUrl,
+		Commit:        data.Commit,
+		Branch:        data.Branch,
+		StartedBy:     data.StartedBy,
+	}
+	if data.BuildNumber > 0 {
+		event.Thread = fmt.Sprintf("%s#%d", data.JobPath, data.BuildNumber)
+	}
+	return event
+}
+
+func (h *Tracker) jobLog(job *TrackedJob) *logging.Entry {
+	return h.log.With(logging.Fields{
+		"job":      job.GetAlias(),
+		"job_path": job.GetName(),
+	})
+}
+
+func (h *Tracker) buildLog(job *TrackedJob, build int32) *logging.Entry {
+	return h.jobLog(job).With(logging.Fields{"build": build})
+}
+
+// eventLog returns an entry carrying the structured details of the event.
+func (h *Tracker) eventLog(event *notifications.Event) *logging.Entry {
+	fields := logging.Fields{}
+	if event.Job != "" {
+		fields["job"] = event.Job
+		fields["job_path"] = event.JobPath
+	}
+	if event.BuildNumber > 0 {
+		fields["build"] = event.BuildNumber
+	}
+	if event.ArtifactCount > 0 {
+		fields["artifacts"] = event.ArtifactCount
+		fields["bytes"] = event.ArtifactBytes
+	}
+	if event.SavedBytes > 0 {
+		fields["saved_bytes"] = event.SavedBytes
+	}
+	if event.Duration > 0 {
+		fields["duration"] = event.Duration.String()
+	}
+	if event.Commit != "" {
+		fields["commit"] = event.Commit
+	}
+	return h.log.With(fields)
+}
+
+// TrackJob syncs the job every interval, until it's no longer tracked.
+func (h *Tracker) TrackJob(job *TrackedJob) {
+	for {
+		// failures have already been logged and notified of; we'll wait the
+		// interval out and try again.
+		h.SyncJob(job)
 		time.Sleep(h.interval)
+		if tracked, _ := h.isTracked(job); !tracked {
+			h.jobLog(job).Debug("no longer tracked")
+			return
+		}
+	}
+}
+
+// SyncJob checks the job for a new build once, and syncs its artifacts if
+// there is one. The job of a deleted branch isn't synced.
+func (h *Tracker) SyncJob(job *TrackedJob) error {
+	if _, gone := h.isTracked(job); gone {
+		h.jobLog(job).Debug("branch was deleted from Jenkins; not syncing")
+		return nil
+	}
+	currentBuild, err := h.LatestBuild(job)
+	if err != nil {
+		h.handleApiError(job, err)
+		return err
+	}
+	// if we got here, we know we can reach the host.
+	h.resolveApiErrors(job)
+	if currentBuild == nil {
+		h.jobLog(job).Debug("no build has been selected to sync yet; no action required.")
+		return nil
+	}
+	// a pinned or matched build may be older than the synced one if the config
+	// has changed, but it's still the one wanted
+	wanted := job.PinBuild > 0 || job.HasMatchers()
+	if currentBuild.Number == job.BuildNumber() || (currentBuild.Number < job.BuildNumber() && !wanted) {
+		h.buildLog(job, currentBuild.Number).Debug("last observed build is up-to-date; no action required.")
+		return nil
+	}
+	builds, err := h.pendingBuilds(job, currentBuild)
+	if err != nil {
+		h.handleApiError(job, err)
+		return err
+	}
+	// the builds are synced oldest first, so a failure is retried from the
+	// build that failed
+	for _, build := range builds {
+		if err := h.syncBuild(job, build); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncBuild syncs the artifacts of a new build of the job, and makes it the
+// build the job is synced to.
+func (h *Tracker) syncBuild(job *TrackedJob, build *jenkins.Build) error {
+	details, err := h.client.GetBuildByUrl(build.Url)
+	if err != nil {
+		data := h.buildData(job, build)
+		data.Error = err.Error()
+		h.raise(h.newEvent(notifications.EventApiError, notifications.SeverityError, data))
+		h.buildLog(job, build.Number).With(logging.Fields{"error": err}).Error("failed to get build details")
+		return err
+	}
+	data := h.buildData(job, build)
+	addBuildDetails(data, details)
+	event := h.newEvent(notifications.EventNewBuild, notifications.SeverityInfo, data)
+	h.notify(event)
+	h.eventLog(event).Info(event.Text)
+	start := time.Now()
+	result, err := h.handleNewBuild(job, build, details)
+	// set and save the build state _after_ the artifacts are synced so they can be retried if something crashes
+	if err != nil {
+		h.handleArtifactErrors(job, build, err)
+		return err
+	}
+	data = h.buildData(job, build)
+	addBuildDetails(data, details)
+	job.SetBuild(build)
+	data.ArtifactCount = result.artifacts
+	data.ArtifactBytes = result.bytes
+	data.ArtifactSize = notifications.FormatBytes(result.bytes)
+	data.ReusedCount = result.reused
+	data.SavedBytes = result.saved
+	if result.saved > 0 {
+		data.SavedSize = notifications.FormatBytes(result.saved)
+	}
+	data.Duration = time.Since(start).Round(time.Second)
+	event = h.newEvent(notifications.EventSyncComplete, notifications.SeveritySuccess, data)
+	h.notify(event)
+	h.eventLog(event).Info(event.Text)
+	h.resolveArtifactErrors(job)
+	h.removeOutdatedBuilds(job)
+	h.updateIndex(job)
+	h.saveState()
+	return nil
+}
+
+// FetchBuild downloads the artifacts of a specific build of the job into its
+// sync dir, without changing which build the job is synced to.
+func (h *Tracker) FetchBuild(job *TrackedJob, number int32) error {
+	jobBuild, err := h.client.GetBuild(job.GetName(), number)
+	if err != nil {
+		return err
 	}
+	build := &jenkins.Build{Class: jobBuild.Class, Number: jobBuild.Number, Url: jobBuild.Url}
+	result, err := h.handleNewBuild(job, build, jobBuild)
+	if err != nil {
+		return err
+	}
+	h.buildLog(job, number).With(logging.Fields{
+		"artifacts": result.artifacts,
+		"bytes":     result.bytes,
+		"reused":    result.reused,
+	}).Info("fetched build")
+	h.updateIndex(job)
+	return nil
+}
+
+// Prune removes the builds of the job which are no longer meant to be cached.
+func (h *Tracker) Prune(job *TrackedJob) {
+	h.removeOutdatedBuilds(job)
+	h.updateIndex(job)
 }
 
 func (h *Tracker) handleApiError(job *TrackedJob, err error) {
-	h.log.Error.Print(err.Error())
+	h.jobLog(job).With(logging.Fields{"error": err}).Error("failed to check for new builds")
+	data := h.jobData(job)
+	data.Error = err.Error()
 	if strings.Contains(err.Error(), "dial tcp: lookup") {
-		// special handling for common DNS issues
-		if h.dns {
-			// We'll only send notifications when we used to be able to reach the host,
-			// but can't now, to avoid being too spammy.
-			h.notify(fmt.Sprintf(
-				"DNS lookup failed for Jenkins server %s - check your VPN, DNS, or network connectivity",
-				h.client.GetBaseUrl(),
-			))
-		}
-		h.dns = false
+		// special handling for common DNS issues; this isn't specific to the
+		// job, so it's raised once for the whole tracker.
+		data = h.serverData()
+		data.Error = err.Error()
+		h.raise(h.newEvent(notifications.EventDnsFailure, notifications.SeverityError, data))
 	} else if strings.Contains(err.Error(), "invalid character '<'") {
 		// we got HTML instead of JSON for some reason
-		h.notify(fmt.Sprintf(
-			"%s - received HTML instead of JSON when attempting to check for latest build via Jenkins API. This is usually an intermittent issue which should resolve itself. Will try again after interval.",
-			job.GetAlias(),
-		))
+		h.raise(h.newEvent(notifications.EventHtmlResponse, notifications.SeverityWarning, data))
 	} else {
 		// send notifications of the error message
-		h.notify(err.Error())
+		h.raise(h.newEvent(notifications.EventApiError, notifications.SeverityError, data))
 	}
 }
 
-func (h *Tracker) handleArtifactErrors(job *TrackedJob, err error) {
-	var msg string
+func (h *Tracker) resolveApiErrors(job *TrackedJob) {
+	h.resolve(nil, notifications.EventDnsFailure)
+	h.resolve(job, notifications.EventHtmlResponse)
+	h.resolve(job, notifications.EventApiError)
+}
+
+func (h *Tracker) handleArtifactErrors(job *TrackedJob, build *jenkins.Build, err error) {
+	data := h.buildData(job, build)
+	data.Error = err.Error()
 	if strings.Contains(err.Error(), "no space left on device") {
-		h.mux.Lock()
-		defer h.mux.Unlock()
-		if h.outofspace {
-			// we've already noticed we're out of disk space; no reason to keep spamming
-			return
-		}
-		h.outofspace = true
-		msg = fmt.Sprintf(
-			"%s - downloads failed due to disk being full; please clean up disk space and reduce builds_to_cache for job",
-			job.GetAlias(),
-		)
-		h.notify(msg)
-		h.log.Error.Print(err.Error())
-		h.log.Error.Print(msg)
+		event := h.newEvent(notifications.EventDiskFull, notifications.SeverityError, data)
+		h.eventLog(event).With(logging.Fields{"error": err}).Error(event.Text)
+		h.raise(event)
 	}
-	msg = fmt.Sprintf(
-		"%s - artifact download for build number %d failed on one or more artifacts; will retry after wait interval.",
-		job.GetAlias(),
-		job.BuildNumber(),
-	)
-	h.log.Error.Print(msg)
-	h.notify(msg)
+	event := h.newEvent(notifications.EventSyncFailed, notifications.SeverityError, data)
+	h.eventLog(event).With(logging.Fields{"error": err}).Error(event.Text)
+	h.raise(event)
 }
 
-func (h *Tracker) handleNewBuild(job *TrackedJob, newBuild *jenkins.Build) error {
-	artifacts, err := h.client.GetArtifactUrlsFromBuild(newBuild.Url)
-	if err != nil {
-		h.notify(err.Error())
-		h.log.Error.Print(err.Error())
-		return err
+func (h *Tracker) resolveArtifactErrors(job *TrackedJob) {
+	h.resolve(job, notifications.EventDiskFull)
+	h.resolve(job, notifications.EventDownloadFailed)
+	h.resolve(job, notifications.EventSyncFailed)
+}
+
+type syncResult struct {
+	artifacts int
+	bytes     int64
+	// reused and saved are the number and size of the artifacts which were
+	// linked from earlier builds rather than downloaded
+	reused    int
+	saved     int64
+	downloads []downloadResult
+}
+
+type downloadResult struct {
+	artifact *jenkins.Artifact
+	url      string
+	path     string
+	err      error
+	finished time.Time
+	took     time.Duration
+	sha256   string
+	md5      string
+	verified bool
+	// linked is whether the artifact was reused from the previous build or
+	// the blob store rather than downloaded
+	linked bool
+}
+
+func (h *Tracker) handleNewBuild(job *TrackedJob, newBuild *jenkins.Build, details *jenkins.JobBuild) (*syncResult, error) {
+	if len(details.Artifacts) > 0 {
+		fingerprints, err := h.client.GetFingerprints(details.Url)
+		if err != nil {
+			data := h.buildData(job, newBuild)
+			data.Error = err.Error()
+			event := h.newEvent(notifications.EventUnverified, notifications.SeverityWarning, data)
+			h.eventLog(event).With(logging.Fields{"error": err}).Warn(event.Text)
+			h.raise(event)
+		} else {
+			h.resolve(job, notifications.EventUnverified)
+		}
+		details.Fingerprint = fingerprints
 	}
-	// kick off all the downloads; when they're complete, their channel will recieve an error
-	// or `nil` if the download was successful
-	downloadChannels := make([]<-chan error, 0)
-	for _, artifactUrl := range artifacts {
-		downloadChannels = append(downloadChannels, h.handleNewArtifact(job.GetName(), newBuild.Number, artifactUrl))
+	previous := h.previousArtifacts(job, newBuild.Number)
+	// kick off all the downloads; when they're complete, their channel will recieve the
+	// downloaded file or an error if the download failed
+	downloadChannels := make([]<-chan downloadResult, 0)
+	for _, artifact := range details.Artifacts {
+		fingerprint := details.ArtifactFingerprint(artifact)
+		downloadChannels = append(downloadChannels, h.handleNewArtifact(job, newBuild.Number, artifact, details.ArtifactUrl(artifact), fingerprint, previous[strings.ToLower(fingerprint)]))
 	}
+	result := &syncResult{}
 	errorSet := []error{}
 	// wait for all downloads to complete
 	for _, c := range downloadChannels {
-		err := <-c
-		if err != nil {
-			errorSet = append(errorSet, err)
-			h.notify(err.Error())
-			h.log.Error.Print(err.Error())
+		download := <-c
+		if download.err != nil {
+			errorSet = append(errorSet, download.err)
+			data := h.buildData(job, newBuild)
+			data.Error = download.err.Error()
+			h.raise(h.newEvent(notifications.EventDownloadFailed, notifications.SeverityError, data))
+			h.buildLog(job, newBuild.Number).With(logging.Fields{
+				"artifact_url": download.url,
+				"error":        download.err,
+			}).Error("artifact download failed")
+			continue
+		}
+		result.artifacts++
+		result.downloads = append(result.downloads, download)
+		if info, err := os.Stat(download.path); err == nil {
+			result.bytes += info.Size()
+			if download.linked {
+				result.reused++
+				result.saved += info.Size()
+			}
 		}
 	}
 	if len(errorSet) > 0 {
-		return &comboError{errorSet: errorSet}
+		return nil, &comboError{errorSet: errorSet}
 	}
-	h.removeOutdatedBuilds(job)
-	return nil
+	// the artifacts themselves are fine, so the build is still synced if its
+	// manifest or checksums can't be written
+	manifest, err := newBuildManifest(job, details, result.downloads)
+	if err != nil {
+		h.buildLog(job, newBuild.Number).With(logging.Fields{"error": err}).Error("failed to checksum artifacts")
+		return result, nil
+	}
+	if err := h.writeManifest(job, manifest); err != nil {
+		h.buildLog(job, newBuild.Number).With(logging.Fields{"error": err}).Error("failed to write build manifest")
+	}
+	if err := h.writeChecksums(job, manifest); err != nil {
+		h.buildLog(job, newBuild.Number).With(logging.Fields{"error": err}).Error("failed to write checksum lists")
+	}
+	return result, nil
 }
 
-func (h *Tracker) handleNewArtifact(job string, build int32, url string) <-chan error {
-	ch := make(chan error)
-	downloadDir := path.Join(h.trackedJobs[job].SyncDir, fmt.Sprintf("%d", build))
+func (h *Tracker) handleNewArtifact(job *TrackedJob, build int32, artifact *jenkins.Artifact, url string, fingerprint string, previous string) <-chan downloadResult {
+	ch := make(chan downloadResult)
+	downloadDir := path.Join(job.SyncDir, fmt.Sprintf("%d", build))
 	go func() {
-		err := h.client.DownloadFile(url, downloadDir)
-		ch <- err
+		ch <- h.downloadArtifact(job, build, downloadResult{artifact: artifact, url: url}, downloadDir, fingerprint, previous)
 	}()
 	return ch
 }
 
+// updateIndex rebuilds the index of the job's cached builds, logging rather
+// than returning any failure since the builds themselves are unaffected.
+func (h *Tracker) updateIndex(job *TrackedJob) {
+	if err := h.writeIndex(job); err != nil {
+		h.jobLog(job).With(logging.Fields{"error": err}).Error("failed to write job index")
+	}
+}
+
 func (h *Tracker) getStateFile() (*os.File, error) {
-	dir, err := common.GetExeDir()
-	if err != nil {
-		h.log.Fatal.Fatal(err)
+	stateFilePath := h.stateFile
+	if stateFilePath == "" {
+		dir, err := common.GetExeDir()
+		if err != nil {
+			h.log.Fatal.Fatal(err)
+		}
+		stateFilePath = filepath.Join(dir, "state.json")
+	}
+	if err := os.MkdirAll(filepath.Dir(stateFilePath), 0700); err != nil {
+		return nil, err
 	}
-	stateFilePath := filepath.Join(dir, "state.json")
 	return os.OpenFile(stateFilePath, os.O_RDWR|os.O_CREATE, 0600)
 }
 
 func (h *Tracker) saveState() {
-	file, err := h.getStateFile()
-	if err != nil {
-		h.log.Error.Printf("unable to open state file for saving: %v", err)
-		return
+	if err := h.SaveState(); err != nil {
+		h.log.Error.Print(err)
 	}
-	defer file.Close()
+}
 
+// SaveState writes the build each job is synced to into the state file.
+func (h *Tracker) SaveState() error {
+	// jobs are tracked concurrently, so make sure only one of them writes at a time
+	h.mux.Lock()
+	defer h.mux.Unlock()
 	stateBytes, err := json.Marshal(h.trackedJobs)
 	if err != nil {
-		h.log.Error.Printf("unable to marshal state for saving: %v", err)
-		return
+		return fmt.Errorf("unable to marshal state for saving: %v", err)
+	}
+	file, err := h.getStateFile()
+	if err != nil {
+		return fmt.Errorf("unable to open state file for saving: %v", err)
+	}
+	defer file.Close()
+	if err := file.Truncate(0); err != nil {
+		return fmt.Errorf("unable to save state: %v", err)
 	}
-	file.Write(stateBytes)
+	if _, err := file.Write(stateBytes); err != nil {
+		return fmt.Errorf("unable to save state: %v", err)
+	}
+	return nil
 }
 
-func (h *Tracker) removeOutdatedBuilds(job *TrackedJob) {
-	if job.BuildsToCache < 0 {
-		// negative numbers mean we'll keep all the old jobs
-		return
-	}
-	h.log.Info.Printf("Cleaning up old builds for %s", job.GetName())
+// CachedBuilds returns the numbers of the builds in the job's sync dir, in
+// ascending order.
+func (h *Tracker) CachedBuilds(job *TrackedJob) ([]int, error) {
 	items, err := ioutil.ReadDir(job.SyncDir)
 	if err != nil {
-		h.log.Error.Printf(
-			"Failed to list dir contents for %s: %v",
-			job.SyncDir,
-			err,
-		)
-		return
+		if os.IsNotExist(err) {
+			return []int{}, nil
+		}
+		return nil, err
 	}
-	dirs := map[int]os.FileInfo{}
 	builds := []int{}
 	// filter for just dirs that are integers; these should be the build cache dirs
 	for _, item := range items {
 		if item.IsDir() {
-			dirInt, err := strconv.Atoi(item.Name())
-			dirInt32 := dirInt
+			build, err := strconv.Atoi(item.Name())
 			if err != nil {
 				continue
 			}
-			dirs[dirInt32] = item
-			builds = append(builds, dirInt32)
+			builds = append(builds, build)
 		}
 	}
 	sort.Ints(builds)
-	h.log.Info.Printf("%s currently has the following builds cached: [%d]", job.GetName(), builds)
-	if len(builds) <= job.BuildsToCache+1 {
+	return builds, nil
+}
+
+func (h *Tracker) removeOutdatedBuilds(job *TrackedJob) {
+	if job.BuildsToCache < 0 {
+		// negative numbers mean we'll keep all the old jobs
+		return
+	}
+	log := h.jobLog(job)
+	log.Debug("Cleaning up old builds")
+	builds, err := h.CachedBuilds(job)
+	if err != nil {
+		log.With(logging.Fields{"path": job.SyncDir, "error": err}).Error("Failed to list dir contents")
+		return
+	}
+	log.Debugf("currently has the following builds cached: %v", builds)
+	// the latest build is kept along with BuildsToCache builds before it
+	keep := job.BuildsToCache + 1
+	if len(builds) <= keep {
 		// we still have more builds to cache, so we don't need to purge anything
 		return
 	}
-	for i, build := range builds {
-		if i < job.BuildsToCache {
-			h.log.Info.Printf("removing outdated build %d", build)
-			err = os.RemoveAll(path.Join(job.SyncDir, fmt.Sprintf("%d", build)))
-			if err != nil {
-				h.log.Error.Printf("failed to remove build %d: %v", build, err)
-			}
+	for _, build := range builds[:len(builds)-keep] {
+		if build == int(job.BuildNumber()) {
+			// a pinned build may be older than the builds kept with it
+			continue
+		}
+		buildLog := log.With(logging.Fields{"build": build})
+		buildLog.Info("removing outdated build")
+		err = os.RemoveAll(path.Join(job.SyncDir, fmt.Sprintf("%d", build)))
+		if err != nil {
+			buildLog.With(logging.Fields{"error": err}).Error("failed to remove build")
 		}
 	}
+	if store := h.blobStore(job); store != nil {
+		h.collectBlobs(store)
+	}
 }
 
-func (h *Tracker) LoadState() {
+// LoadState restores the build each job is synced to from the state file.
+// Jobs in the state file which match a selector are tracked again without
+// being rediscovered, and any others which are no longer tracked are ignored.
+func (h *Tracker) LoadState() error {
 	file, err := h.getStateFile()
 	if err != nil {
-		h.log.Error.Printf("unable to open state file for loading: %v", err)
-		return
+		return fmt.Errorf("unable to open state file for loading: %v", err)
 	}
 	defer file.Close()
-	tmpJobs := map[string]*TrackedJob{}
 	stateBytes, err := ioutil.ReadAll(file)
+	if err != nil {
+		return fmt.Errorf("unable to read state file: %v", err)
+	}
+	if len(strings.TrimSpace(string(stateBytes))) == 0 {
+		// nothing has been synced yet
+		return nil
+	}
+	tmpJobs := map[string]*TrackedJob{}
 	err = json.Unmarshal(stateBytes, &tmpJobs)
 	if err != nil {
-		h.log.Error.Printf("unable to load state from file: %v", err)
-		return
+		return fmt.Errorf("unable to load state from file: %v", err)
 	}
 	for key, val := range tmpJobs {
-		h.trackedJobs[key].Build.Number = val.BuildNumber()
+		h.mux.Lock()
+		job, ok := h.trackedJobs[key]
+		h.mux.Unlock()
+		for _, selector := range h.selectors {
+			if ok {
+				break
+			}
+			job, err = h.expand(selector, key)
+			if err != nil {
+				return fmt.Errorf("unable to load state of %s: %v", key, err)
+			}
+			ok = job != nil
+		}
+		if !ok {
+			h.log.With(logging.Fields{"job_path": key}).Debug("ignoring state of untracked job")
+			continue
+		}
+		job.SetBuild(val.GetBuild())
+		job.Gone = val.Gone
 	}
+	return nil
 }
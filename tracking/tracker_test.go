@@ -0,0 +1,95 @@
+package tracking
+
+import (
+	"fmt"
+	"github.com/go-yaml/yaml"
+	"github.com/pakohler/jenkronize/jenkins"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRemoveOutdatedBuilds(t *testing.T) {
+	tests := []struct {
+		buildsToCache int
+		synced        int32
+		kept          string
+	}{
+		{-1, 5, "[1 2 3 4 5]"},
+		{0, 5, "[5]"},
+		{2, 5, "[3 4 5]"},
+		{10, 5, "[1 2 3 4 5]"},
+		// a pinned build is kept even if it's older
+		{1, 2, "[2 4 5]"},
+	}
+	for _, test := range tests {
+		syncDir, err := ioutil.TempDir("", "jenkronize-sync")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(syncDir)
+		for build := 1; build <= 5; build++ {
+			if err := os.MkdirAll(filepath.Join(syncDir, fmt.Sprintf("%d", build)), 0700); err != nil {
+				t.Fatal(err)
+			}
+		}
+		job := NewTrackedJob("/job/foo", "foo", syncDir)
+		job.BuildsToCache = test.buildsToCache
+		job.SetBuild(&jenkins.Build{Number: test.synced})
+		h := (&Tracker{}).Init()
+		h.removeOutdatedBuilds(job)
+		builds, err := h.CachedBuilds(job)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kept := fmt.Sprint(builds); kept != test.kept {
+			t.Errorf("builds_to_cache %d synced to %d kept %s, expected %s", test.buildsToCache, test.synced, kept, test.kept)
+		}
+	}
+}
+
+func TestTrackedJobBuildsToCache(t *testing.T) {
+	jobs := []*TrackedJob{}
+	source := `
+- name: /job/foo
+- name: /job/bar
+  builds_to_cache: 0
+`
+	if err := yaml.Unmarshal([]byte(source), &jobs); err != nil {
+		t.Fatal(err)
+	}
+	if jobs[0].BuildsToCache != -1 {
+		t.Errorf("a job without builds_to_cache caches %d builds; it should keep them all", jobs[0].BuildsToCache)
+	}
+	if jobs[1].BuildsToCache != 0 {
+		t.Errorf("a job with builds_to_cache 0 caches %d builds", jobs[1].BuildsToCache)
+	}
+}
+
+func TestTrackedJobsKeepsInvalidJobs(t *testing.T) {
+	var jobs TrackedJobs
+	source := `
+- name: /job/foo
+  builds_to_cache: many
+-
+- name: /job/bar
+`
+	err := yaml.Unmarshal([]byte(source), &jobs)
+	typeErr, ok := err.(*yaml.TypeError)
+	if !ok || len(typeErr.Errors) != 1 {
+		t.Fatalf("decoding returned %v", err)
+	}
+	if len(jobs) != 3 {
+		t.Fatalf("%d jobs were decoded, expected 3", len(jobs))
+	}
+	if jobs[0] == nil || jobs[0].Name != "/job/foo" || jobs[0].BuildsToCache != -1 {
+		t.Errorf("the invalid job is %+v", jobs[0])
+	}
+	if jobs[1] != nil {
+		t.Errorf("the empty job is %+v", jobs[1])
+	}
+	if jobs[2] == nil || jobs[2].Name != "/job/bar" {
+		t.Errorf("the job after the invalid one is %+v", jobs[2])
+	}
+}